@@ -0,0 +1,124 @@
+/*
+Copyright 2020 Red Hat Community of Practice.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/redhat-cop/operator-utils/pkg/util/apis"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// CAInjectionTargetSpec defines the desired state of CAInjectionTarget
+type CAInjectionTargetSpec struct {
+	// Group is the API group of the objects in which the ca bundle is injected, empty for the core group
+	// +kubebuilder:validation:Optional
+	Group string `json:"group,omitempty"`
+
+	// Version is the API version of the objects in which the ca bundle is injected
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Version string `json:"version"`
+
+	// Kind is the kind of the objects in which the ca bundle is injected
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Kind string `json:"kind"`
+
+	// Paths are the fields of the objects in which the ca bundle is injected
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	Paths []InjectionPath `json:"paths"`
+}
+
+// InjectionPath is a field in which the ca bundle is injected
+type InjectionPath struct {
+	// Path is a JSONPath expression identifying the field, for example {.spec.endpoints[*].tlsConfig.ca}.
+	// Only field names, [*] wildcards and numeric array indexes are supported. Missing intermediate fields are created.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Path string `json:"path"`
+
+	// Encoding is the encoding of the injected ca bundle, either pem or base64 encoded pem
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=pem;base64
+	// +kubebuilder:default=pem
+	Encoding Encoding `json:"encoding,omitempty"`
+}
+
+// Encoding is the encoding of an injected ca bundle
+type Encoding string
+
+const (
+	// PEMEncoding injects the ca bundle as a pem string
+	PEMEncoding Encoding = "pem"
+	// Base64Encoding injects the ca bundle as a base64 encoded pem string
+	Base64Encoding Encoding = "base64"
+)
+
+// CAInjectionTargetStatus defines the observed state of CAInjectionTarget
+type CAInjectionTargetStatus struct {
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+var _ apis.ConditionsAware = &CAInjectionTarget{}
+
+func (m *CAInjectionTarget) GetConditions() []metav1.Condition {
+	return m.Status.Conditions
+}
+
+func (m *CAInjectionTarget) SetConditions(conditions []metav1.Condition) {
+	m.Status.Conditions = conditions
+}
+
+// GetTargetGroupVersionKind returns the GroupVersionKind of the objects in which the ca bundle is injected
+func (m *CAInjectionTarget) GetTargetGroupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{
+		Group:   m.Spec.Group,
+		Version: m.Spec.Version,
+		Kind:    m.Spec.Kind,
+	}
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=cainjectiontargets,scope=Cluster
+
+// CAInjectionTarget is the Schema for the cainjectiontargets API
+type CAInjectionTarget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CAInjectionTargetSpec   `json:"spec,omitempty"`
+	Status CAInjectionTargetStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// CAInjectionTargetList contains a list of CAInjectionTarget
+type CAInjectionTargetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CAInjectionTarget `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CAInjectionTarget{}, &CAInjectionTargetList{})
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2020 Red Hat Community of Practice.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/redhat-cop/operator-utils/pkg/util/apis"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CertificatePolicySpec defines the desired state of CertificatePolicy
+type CertificatePolicySpec struct {
+	// NamespaceSelector selects the namespaces whose tls secrets are checked against the policy, all namespaces if not set
+	// +kubebuilder:validation:Optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Rules are the requirements the certificates must satisfy
+	// +kubebuilder:validation:Required
+	Rules CertificatePolicyRules `json:"rules"`
+
+	// EnforcementAction is the action taken on the secrets that violate the policy. With warn violations are only reported,
+	// with deny the validating webhook of the operator, when enabled, also rejects the creation and the update of the secrets.
+	// The webhook has failurePolicy Ignore, so deny is not enforced, and violating secrets are admitted, while the operator is down.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=warn;deny
+	// +kubebuilder:default=warn
+	EnforcementAction EnforcementAction `json:"enforcementAction,omitempty"`
+}
+
+// CertificatePolicyRules are the requirements of a CertificatePolicy, unset rules are not checked
+type CertificatePolicyRules struct {
+	// MinRSAKeySize is the minimum size, in bits, of the RSA keys of the certificates of the chain
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MinRSAKeySize int `json:"minRSAKeySize,omitempty"`
+
+	// ForbidSHA1Signatures rejects the certificates of the chain signed with SHA-1, self signed roots excluded
+	// +kubebuilder:validation:Optional
+	ForbidSHA1Signatures bool `json:"forbidSHA1Signatures,omitempty"`
+
+	// MaxLifetime is the maximum time between the start and the end of the validity of the leaf certificate, for example 9552h (398 days)
+	// +kubebuilder:validation:Optional
+	MaxLifetime *metav1.Duration `json:"maxLifetime,omitempty"`
+
+	// RequireSANs rejects leaf certificates without subject alternative names, identified only by the common name of their subject
+	// +kubebuilder:validation:Optional
+	RequireSANs bool `json:"requireSANs,omitempty"`
+}
+
+// EnforcementAction is the action taken on the secrets that violate a CertificatePolicy
+type EnforcementAction string
+
+const (
+	// WarnEnforcementAction reports the violations with events and metrics
+	WarnEnforcementAction EnforcementAction = "warn"
+	// DenyEnforcementAction also rejects the secrets that violate the policy in the validating webhook
+	DenyEnforcementAction EnforcementAction = "deny"
+)
+
+// CertificatePolicyStatus defines the observed state of CertificatePolicy
+type CertificatePolicyStatus struct {
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+var _ apis.ConditionsAware = &CertificatePolicy{}
+
+func (m *CertificatePolicy) GetConditions() []metav1.Condition {
+	return m.Status.Conditions
+}
+
+func (m *CertificatePolicy) SetConditions(conditions []metav1.Condition) {
+	m.Status.Conditions = conditions
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=certificatepolicies,scope=Cluster
+// +kubebuilder:printcolumn:name="Enforcement",type=string,JSONPath=`.spec.enforcementAction`
+
+// CertificatePolicy is the Schema for the certificatepolicies API
+type CertificatePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CertificatePolicySpec   `json:"spec,omitempty"`
+	Status CertificatePolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// CertificatePolicyList contains a list of CertificatePolicy
+type CertificatePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CertificatePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CertificatePolicy{}, &CertificatePolicyList{})
+}
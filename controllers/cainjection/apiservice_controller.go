@@ -2,7 +2,6 @@ package cainjection
 
 import (
 	"context"
-	"strings"
 
 	"github.com/go-logr/logr"
 	"github.com/redhat-cop/cert-utils-operator/controllers/util"
@@ -10,7 +9,6 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
@@ -23,13 +21,20 @@ type APIServiceReconciler struct {
 	outils.ReconcilerBase
 	Log            logr.Logger
 	controllerName string
+	// ServiceCAWatcher triggers the injection of the service ca when it changes
+	ServiceCAWatcher *util.ServiceCAWatcher
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *APIServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.controllerName = "apiservice_ca_injection_controller"
 
-	return ctrl.NewControllerManagedBy(mgr).
+	err := util.IndexReferencingObjects(mgr.GetFieldIndexer(), &apiregistrationv1.APIService{}, util.CertAnnotationSecret, util.CertAnnotationConfigMap, util.CertAnnotationServiceCA)
+	if err != nil {
+		return err
+	}
+
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
 		For(&apiregistrationv1.APIService{
 			TypeMeta: v1.TypeMeta{
 				Kind: "APIService",
@@ -39,12 +44,25 @@ func (r *APIServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			TypeMeta: v1.TypeMeta{
 				Kind: "Secret",
 			},
-		}}, util.NewEnqueueRequestForReferecingObject(r.GetRestConfig(), schema.FromAPIVersionAndKind("apiregistration.k8s.io/v1", "APIService")), builder.WithPredicates(util.IsCAContentChanged)).
-		Complete(r)
+		}}, util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &apiregistrationv1.APIServiceList{}, util.CertAnnotationSecret), builder.WithPredicates(util.IsCAContentChanged)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{
+			TypeMeta: v1.TypeMeta{
+				Kind: "ConfigMap",
+			},
+		}}, util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &apiregistrationv1.APIServiceList{}, util.CertAnnotationConfigMap), builder.WithPredicates(util.IsCAConfigMapContentChanged))
+
+	if r.ServiceCAWatcher != nil {
+		controllerBuilder = controllerBuilder.Watches(r.ServiceCAWatcher.NewSource(), util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &apiregistrationv1.APIServiceList{}, util.CertAnnotationServiceCA))
+	}
+
+	return controllerBuilder.Complete(r)
 }
 
+const apiServiceCABundleField = "spec.caBundle"
+
 // +kubebuilder:rbac:groups="apiregistration.k8s.io",resources=apiservices,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
 
 func (r *APIServiceReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
@@ -64,24 +82,34 @@ func (r *APIServiceReconciler) Reconcile(context context.Context, req ctrl.Reque
 		return reconcile.Result{}, err
 	}
 
-	caBundle := []byte{}
-
-	if secretNamespacedName, ok := instance.GetAnnotations()[util.CertAnnotationSecret]; ok {
-		err = util.ValidateSecretName(secretNamespacedName)
-		if err != nil {
-			log.Error(err, "invalid ca secret name", "secret", secretNamespacedName)
-			return r.ManageError(context, instance, err)
-		}
-		//we need to inject the secret ca
-		caBundle, err = util.GetSecretCA(r.GetClient(), secretNamespacedName[strings.Index(secretNamespacedName, "/")+1:], secretNamespacedName[:strings.Index(secretNamespacedName, "/")])
-		if err != nil {
-			log.Error(err, "unable to retrive ca from secret", "secret", secretNamespacedName)
-			return r.ManageError(context, instance, err)
-		}
+	original := instance.DeepCopy()
+	caBundle, err := util.GetCABundle(r.GetClient(), instance)
+	if err != nil {
+		log.Error(err, "unable to retrive ca bundle")
+		return r.ManageError(context, instance, err)
 	}
 
-	instance.Spec.CABundle = caBundle
-	err = r.GetClient().Update(context, instance)
+	injectedFields, err := util.GetInjectedFields(instance)
+	if err != nil {
+		return r.ManageError(context, instance, err)
+	}
+	enabled := util.IsCAInjectionEnabled(instance)
+	released := util.InjectedFields{}
+	if !enabled {
+		released = injectedFields.Release()
+	}
+	if value, ok := released[apiServiceCABundleField]; ok {
+		// the injection was disabled, the original ca bundle is restored
+		instance.Spec.CABundle = value
+	} else if enabled {
+		injectedFields.Track(apiServiceCABundleField, instance.Spec.CABundle, caBundle)
+		instance.Spec.CABundle = caBundle
+	}
+	err = util.SetInjectedFields(instance, injectedFields)
+	if err != nil {
+		return r.ManageError(context, instance, err)
+	}
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, instance, original)
 	if err != nil {
 		return r.ManageError(context, instance, err)
 	}
@@ -0,0 +1,78 @@
+package cainjection
+
+import (
+	"context"
+	"testing"
+
+	cutil "github.com/redhat-cop/cert-utils-operator/controllers/util"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestAPIServiceReconcilerRevertsInjection(t *testing.T) {
+	caBundle := newTestCA(t)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ca",
+			Namespace: "test",
+		},
+		Type: cutil.TLSSecret,
+		Data: map[string][]byte{
+			"ca.crt": caBundle,
+		},
+	}
+	apiService := &apiregistrationv1.APIService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "v1.example.com",
+			Annotations: map[string]string{
+				cutil.CertAnnotationSecret: "test/ca",
+			},
+		},
+		Spec: apiregistrationv1.APIServiceSpec{
+			CABundle: []byte("original"),
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(apiregistrationv1.AddToScheme(s))
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(secret, apiService).Build()
+	r := &APIServiceReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("apiservice_ca_injection_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, record.NewFakeRecorder(3), nil),
+	}
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "v1.example.com",
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance := &apiregistrationv1.APIService{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, caBundle, instance.Spec.CABundle)
+
+	delete(instance.Annotations, cutil.CertAnnotationSecret)
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &apiregistrationv1.APIService{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("original"), instance.Spec.CABundle)
+	assert.NotContains(t, instance.Annotations, cutil.CertAnnotationInjectedFields)
+}
@@ -0,0 +1,364 @@
+package cainjection
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	redhatcopv1alpha1 "github.com/redhat-cop/cert-utils-operator/api/v1alpha1"
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	outils "github.com/redhat-cop/operator-utils/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// CAInjectionTargetReconciler reconciles a CAInjectionTarget object.
+// For every kind declared by a CAInjectionTarget it registers a controller that injects the ca bundle in the objects of that kind.
+type CAInjectionTargetReconciler struct {
+	outils.ReconcilerBase
+	Log            logr.Logger
+	controllerName string
+	// ServiceCAWatcher triggers the injection of the service ca when it changes
+	ServiceCAWatcher *util.ServiceCAWatcher
+	mgr              ctrl.Manager
+	lock             sync.Mutex
+	registeredGVKs   map[schema.GroupVersionKind]bool
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CAInjectionTargetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.controllerName = "cainjectiontarget_controller"
+	r.mgr = mgr
+	r.registeredGVKs = map[schema.GroupVersionKind]bool{}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&redhatcopv1alpha1.CAInjectionTarget{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
+
+// +kubebuilder:rbac:groups=redhatcop.redhat.io,resources=cainjectiontargets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=redhatcop.redhat.io,resources=cainjectiontargets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
+
+func (r *CAInjectionTargetReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
+	log := r.Log.WithValues("cainjectiontarget", req.NamespacedName)
+
+	// Fetch the CAInjectionTarget instance
+	instance := &redhatcopv1alpha1.CAInjectionTarget{}
+	err := r.GetClient().Get(context, req.NamespacedName, instance)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Controllers cannot be removed from a running manager, the controller for the deleted kind will stop injecting because no target matches.
+			// Return and don't requeue
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	for _, path := range instance.Spec.Paths {
+		_, err := parseInjectionPath(path.Path)
+		if err != nil {
+			log.Error(err, "invalid path", "path", path.Path)
+			return r.ManageError(context, instance, err)
+		}
+	}
+
+	gvk := instance.GetTargetGroupVersionKind()
+	found, err := r.IsAPIResourceAvailable(gvk)
+	if err != nil {
+		log.Error(err, "unable to verify if target kind is defined", "gvk", gvk)
+		return r.ManageError(context, instance, err)
+	}
+	if !found {
+		// the kind may be defined later, the error makes this reconcile be retried with backoff
+		err = errors.New("kind " + gvk.String() + " is not defined")
+		log.Error(err, "unable to register ca injection target")
+		return r.ManageError(context, instance, err)
+	}
+
+	err = r.registerTargetController(gvk)
+	if err != nil {
+		log.Error(err, "unable to register controller for target", "gvk", gvk)
+		return r.ManageError(context, instance, err)
+	}
+
+	return r.ManageSuccess(context, instance)
+}
+
+// registerTargetController adds to the manager a controller for the objects of kind gvk, if one has not been added yet
+func (r *CAInjectionTargetReconciler) registerTargetController(gvk schema.GroupVersionKind) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.registeredGVKs[gvk] {
+		return nil
+	}
+	targetReconciler := &caInjectionTargetObjectReconciler{
+		ReconcilerBase: r.ReconcilerBase,
+		Log:            r.Log.WithName(strings.ToLower(gvk.Kind)),
+		gvk:            gvk,
+	}
+	err := targetReconciler.SetupWithManager(r.mgr, r.ServiceCAWatcher)
+	if err != nil {
+		return err
+	}
+	r.registeredGVKs[gvk] = true
+	return nil
+}
+
+// caInjectionTargetObjectReconciler injects the ca bundle in the objects of a kind declared by one or more CAInjectionTargets
+type caInjectionTargetObjectReconciler struct {
+	outils.ReconcilerBase
+	Log            logr.Logger
+	controllerName string
+	gvk            schema.GroupVersionKind
+	// cache is used to look up the objects of kind gvk when events are received
+	cache client.Reader
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *caInjectionTargetObjectReconciler) SetupWithManager(mgr ctrl.Manager, serviceCAWatcher *util.ServiceCAWatcher) error {
+	r.cache = mgr.GetCache()
+	r.controllerName = strings.ToLower("cainjectiontarget_" + r.gvk.Kind + "_" + r.gvk.Version + "_" + r.gvk.Group)
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(r.gvk)
+	objList := &unstructured.UnstructuredList{}
+	objList.SetGroupVersionKind(r.gvk.GroupVersion().WithKind(r.gvk.Kind + "List"))
+
+	isTargetForKind := predicate.NewPredicateFuncs(func(object client.Object) bool {
+		target, ok := object.(*redhatcopv1alpha1.CAInjectionTarget)
+		return ok && target.GetTargetGroupVersionKind() == r.gvk
+	})
+
+	// this controller is added after the cache is started, when indexes cannot be added anymore, so the referencing objects are looked up without an index
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
+		Named(r.controllerName).
+		For(obj, builder.WithPredicates(util.IsAnnotatedForSecretCAInjection)).
+		Watches(&source.Kind{Type: &corev1.Secret{
+			TypeMeta: v1.TypeMeta{
+				Kind: "Secret",
+			},
+		}}, util.NewEnqueueRequestForReferecingUnindexedObject(r.cache, objList, util.CertAnnotationSecret), builder.WithPredicates(util.IsCAContentChanged)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{
+			TypeMeta: v1.TypeMeta{
+				Kind: "ConfigMap",
+			},
+		}}, util.NewEnqueueRequestForReferecingUnindexedObject(r.cache, objList, util.CertAnnotationConfigMap), builder.WithPredicates(util.IsCAConfigMapContentChanged)).
+		// when a target for this kind is changed, the paths may have changed, so all the annotated objects are reconciled
+		Watches(&source.Kind{Type: &redhatcopv1alpha1.CAInjectionTarget{}}, handler.EnqueueRequestsFromMapFunc(r.findAnnotatedObjects), builder.WithPredicates(isTargetForKind, predicate.GenerationChangedPredicate{}))
+
+	if serviceCAWatcher != nil {
+		controllerBuilder = controllerBuilder.Watches(serviceCAWatcher.NewSource(), util.NewEnqueueRequestForReferecingUnindexedObject(r.cache, objList, util.CertAnnotationServiceCA))
+	}
+
+	return controllerBuilder.Complete(r)
+}
+
+func (r *caInjectionTargetObjectReconciler) findAnnotatedObjects(object client.Object) []reconcile.Request {
+	objList := &unstructured.UnstructuredList{}
+	objList.SetGroupVersionKind(r.gvk.GroupVersion().WithKind(r.gvk.Kind + "List"))
+	err := r.cache.List(context.TODO(), objList)
+	if err != nil {
+		r.Log.Error(err, "unable to list objects", "gvk", r.gvk)
+		return []reconcile.Request{}
+	}
+	requests := []reconcile.Request{}
+	for i := range objList.Items {
+		if util.IsAnnotatedForSecretCAInjection.Create(event.CreateEvent{Object: &objList.Items[i]}) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Name:      objList.Items[i].GetName(),
+				Namespace: objList.Items[i].GetNamespace(),
+			}})
+		}
+	}
+	return requests
+}
+
+func (r *caInjectionTargetObjectReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
+	log := r.Log.WithValues("object", req.NamespacedName)
+
+	// Fetch the target instance
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(r.gvk)
+	err := r.GetClient().Get(context, req.NamespacedName, instance)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	original := instance.DeepCopy()
+
+	targetList := &redhatcopv1alpha1.CAInjectionTargetList{}
+	err = r.GetClient().List(context, targetList)
+	if err != nil {
+		log.Error(err, "unable to list ca injection targets")
+		return r.ManageError(context, instance, err)
+	}
+
+	caBundle, err := util.GetCABundle(r.GetClient(), instance)
+	if err != nil {
+		if util.RecordCrossNamespaceCAInjectionDenied(r.GetRecorder(), instance, err) {
+			// the injection is retried when the source is shared with the namespace of the object
+			return reconcile.Result{}, nil
+		}
+		log.Error(err, "unable to retrive ca bundle")
+		return r.ManageError(context, instance, err)
+	}
+
+	injectedFields, err := util.GetInjectedFields(instance)
+	if err != nil {
+		return r.ManageError(context, instance, err)
+	}
+	enabled := util.IsCAInjectionEnabled(instance)
+	fields := []string{}
+	for _, target := range targetList.Items {
+		if !enabled || target.GetTargetGroupVersionKind() != r.gvk {
+			continue
+		}
+		for _, path := range target.Spec.Paths {
+			pathFields, err := getInjectedPathFields(instance.Object, path.Path)
+			if err != nil {
+				log.Error(err, "invalid injection path", "cainjectiontarget", target.GetName(), "path", path.Path)
+				return r.ManageError(context, instance, err)
+			}
+			fields = append(fields, pathFields...)
+		}
+	}
+
+	// the paths that are no longer injected, because the injection was disabled or the path was removed from the targets, are restored
+	for field, value := range injectedFields.Release(fields...) {
+		_, err := restoreInjectedPath(instance.Object, field, value)
+		if err != nil {
+			log.Error(err, "unable to restore injected path", "path", field)
+			return r.ManageError(context, instance, err)
+		}
+	}
+	for _, target := range targetList.Items {
+		if !enabled || target.GetTargetGroupVersionKind() != r.gvk {
+			continue
+		}
+		for _, path := range target.Spec.Paths {
+			err := trackInjectedPath(injectedFields, instance.Object, path, caBundle)
+			if err != nil {
+				log.Error(err, "unable to inject ca bundle", "cainjectiontarget", target.GetName(), "path", path.Path)
+				return r.ManageError(context, instance, err)
+			}
+			_, err = injectCABundle(instance.Object, path, caBundle)
+			if err != nil {
+				log.Error(err, "unable to inject ca bundle", "cainjectiontarget", target.GetName(), "path", path.Path)
+				return r.ManageError(context, instance, err)
+			}
+		}
+	}
+	err = util.SetInjectedFields(instance, injectedFields)
+	if err != nil {
+		return r.ManageError(context, instance, err)
+	}
+
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, instance, original)
+	if err != nil {
+		log.Error(err, "unable to update object")
+		return r.ManageError(context, instance, err)
+	}
+
+	return r.ManageSuccess(context, instance)
+}
+
+// injectCABundle sets the ca bundle, encoded as requested, in all the fields matched by path.
+// When the ca bundle is empty the fields are removed. It returns whether the object was changed.
+func injectCABundle(obj map[string]interface{}, path redhatcopv1alpha1.InjectionPath, caBundle []byte) (bool, error) {
+	elements, err := parseInjectionPath(path.Path)
+	if err != nil {
+		return false, err
+	}
+	value, err := encodeCABundle(path, caBundle)
+	if err != nil {
+		return false, err
+	}
+	return setPathValue(obj, elements, value)
+}
+
+// encodeCABundle returns the ca bundle encoded as requested by path
+func encodeCABundle(path redhatcopv1alpha1.InjectionPath, caBundle []byte) (string, error) {
+	if len(caBundle) == 0 {
+		return "", nil
+	}
+	switch path.Encoding {
+	case redhatcopv1alpha1.Base64Encoding:
+		return base64.StdEncoding.EncodeToString(caBundle), nil
+	case redhatcopv1alpha1.PEMEncoding, "":
+		return string(caBundle), nil
+	}
+	return "", errors.New("unsupported encoding " + string(path.Encoding))
+}
+
+// getInjectedPathFields returns the fields of obj in which path injects the ca bundle, as tracked in the injected fields.
+// Paths with [*] wildcards are tracked item by item, so that each item keeps track of its value before the injection.
+func getInjectedPathFields(obj map[string]interface{}, path string) ([]string, error) {
+	elements, err := parseInjectionPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if !hasWildcard(elements) {
+		return []string{path}, nil
+	}
+	fields := []string{}
+	for _, itemElements := range expandWildcards(obj, elements) {
+		fields = append(fields, formatInjectionPath(itemElements))
+	}
+	return fields, nil
+}
+
+// trackInjectedPath marks the fields matched by path as injected in obj, recording their current value as the original one
+func trackInjectedPath(injectedFields util.InjectedFields, obj map[string]interface{}, path redhatcopv1alpha1.InjectionPath, caBundle []byte) error {
+	value, err := encodeCABundle(path, caBundle)
+	if err != nil {
+		return err
+	}
+	fields, err := getInjectedPathFields(obj, path.Path)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		elements, err := parseInjectionPath(field)
+		if err != nil {
+			return err
+		}
+		injectedFields.Track(field, getPathValue(obj, elements), []byte(value))
+	}
+	return nil
+}
+
+// restoreInjectedPath sets the fields matched by path to their original value, removing them if original is nil.
+// It returns whether the object was changed.
+func restoreInjectedPath(obj map[string]interface{}, path string, original []byte) (bool, error) {
+	elements, err := parseInjectionPath(path)
+	if err != nil {
+		return false, err
+	}
+	return setPathValue(obj, elements, string(original))
+}
@@ -0,0 +1,340 @@
+package cainjection
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	redhatcopv1alpha1 "github.com/redhat-cop/cert-utils-operator/api/v1alpha1"
+	cutil "github.com/redhat-cop/cert-utils-operator/controllers/util"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var issuerGVK = schema.GroupVersionKind{
+	Group:   "cert-manager.io",
+	Version: "v1",
+	Kind:    "Issuer",
+}
+
+func newTestCA(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestParseInjectionPath(t *testing.T) {
+	elements, err := parseInjectionPath("{.spec.endpoints[*].tlsConfig.ca}")
+	assert.NoError(t, err)
+	assert.Equal(t, []pathElement{{field: "spec"}, {field: "endpoints"}, {index: -1}, {field: "tlsConfig"}, {field: "ca"}}, elements)
+
+	elements, err = parseInjectionPath(".spec.servers[1].caBundle")
+	assert.NoError(t, err)
+	assert.Equal(t, []pathElement{{field: "spec"}, {field: "servers"}, {index: 1}, {field: "caBundle"}}, elements)
+
+	for _, path := range []string{"", "spec.caBundle", "{.spec..caBundle}", "{.spec.servers[*]}", "{.spec.servers[?(@.name)].ca}", "{.spec.servers[-1].ca}", "{.spec.servers[0.ca}"} {
+		_, err = parseInjectionPath(path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestInjectCABundle(t *testing.T) {
+	obj := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"servers": []interface{}{
+				map[string]interface{}{"name": "a"},
+				map[string]interface{}{"name": "b", "tls": map[string]interface{}{"ca": "old"}},
+			},
+		},
+	}
+	path := redhatcopv1alpha1.InjectionPath{Path: "{.spec.servers[*].tls.ca}", Encoding: redhatcopv1alpha1.PEMEncoding}
+
+	changed, err := injectCABundle(obj, path, []byte("ca"))
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	servers, _, _ := unstructured.NestedSlice(obj, "spec", "servers")
+	for _, server := range servers {
+		ca, _, _ := unstructured.NestedString(server.(map[string]interface{}), "tls", "ca")
+		assert.Equal(t, "ca", ca)
+	}
+
+	changed, err = injectCABundle(obj, path, []byte("ca"))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	changed, err = injectCABundle(obj, path, []byte{})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	servers, _, _ = unstructured.NestedSlice(obj, "spec", "servers")
+	_, found, _ := unstructured.NestedString(servers[0].(map[string]interface{}), "tls", "ca")
+	assert.False(t, found)
+
+	// lists are not created
+	changed, err = injectCABundle(obj, redhatcopv1alpha1.InjectionPath{Path: "{.spec.clients[*].ca}"}, []byte("ca"))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = injectCABundle(obj, redhatcopv1alpha1.InjectionPath{Path: "{.spec.servers.ca}"}, []byte("ca"))
+	assert.Error(t, err)
+}
+
+func TestCAInjectionTargetObjectReconciler(t *testing.T) {
+	var (
+		name      = "vault-issuer"
+		namespace = "cert-utils-operator"
+	)
+	caBundle := newTestCA(t)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vault-ca",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"ca.crt": caBundle,
+		},
+	}
+	issuer := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"vault": map[string]interface{}{
+					"server": "https://vault.example.com",
+				},
+			},
+		},
+	}
+	issuer.SetGroupVersionKind(issuerGVK)
+	issuer.SetName(name)
+	issuer.SetNamespace(namespace)
+	issuer.SetAnnotations(map[string]string{
+		"cert-utils-operator.redhat-cop.io/injectca-from-secret": namespace + "/vault-ca",
+	})
+	target := &redhatcopv1alpha1.CAInjectionTarget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cert-manager-vault-issuer",
+		},
+		Spec: redhatcopv1alpha1.CAInjectionTargetSpec{
+			Group:   issuerGVK.Group,
+			Version: issuerGVK.Version,
+			Kind:    issuerGVK.Kind,
+			Paths: []redhatcopv1alpha1.InjectionPath{
+				{Path: "{.spec.vault.caBundle}", Encoding: redhatcopv1alpha1.Base64Encoding},
+				{Path: "{.spec.vault.caBundlePEM}", Encoding: redhatcopv1alpha1.PEMEncoding},
+			},
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(redhatcopv1alpha1.AddToScheme(s))
+	s.AddKnownTypeWithName(issuerGVK, &unstructured.Unstructured{})
+	s.AddKnownTypeWithName(issuerGVK.GroupVersion().WithKind(issuerGVK.Kind+"List"), &unstructured.UnstructuredList{})
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(secret, issuer, target).Build()
+
+	r := &caInjectionTargetObjectReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("cainjectiontarget_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, record.NewFakeRecorder(3), nil),
+		gvk:            issuerGVK,
+		cache:          cl,
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(issuerGVK)
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	encoded, _, _ := unstructured.NestedString(instance.Object, "spec", "vault", "caBundle")
+	assert.Equal(t, base64.StdEncoding.EncodeToString(caBundle), encoded)
+	plain, _, _ := unstructured.NestedString(instance.Object, "spec", "vault", "caBundlePEM")
+	assert.Equal(t, string(caBundle), plain)
+	server, _, _ := unstructured.NestedString(instance.Object, "spec", "vault", "server")
+	assert.Equal(t, "https://vault.example.com", server)
+
+	assert.Equal(t, []reconcile.Request{req}, r.findAnnotatedObjects(target))
+
+	// deleting the target reverts the injection
+	err = cl.Delete(context.TODO(), target)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(issuerGVK)
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	vault, _, _ := unstructured.NestedMap(instance.Object, "spec", "vault")
+	assert.Equal(t, map[string]interface{}{"server": "https://vault.example.com"}, vault)
+	assert.NotContains(t, instance.GetAnnotations(), cutil.CertAnnotationInjectedFields)
+}
+
+func TestExpandWildcards(t *testing.T) {
+	obj := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"servers": []interface{}{
+				map[string]interface{}{"name": "a"},
+				map[string]interface{}{"name": "b", "tls": map[string]interface{}{"ca": "old"}},
+			},
+		},
+	}
+	elements, err := parseInjectionPath("{.spec.servers[*].tls.ca}")
+	assert.NoError(t, err)
+	assert.True(t, hasWildcard(elements))
+	paths := []string{}
+	for _, itemElements := range expandWildcards(obj, elements) {
+		paths = append(paths, formatInjectionPath(itemElements))
+	}
+	assert.Equal(t, []string{"{.spec.servers[0].tls.ca}", "{.spec.servers[1].tls.ca}"}, paths)
+
+	elements, err = parseInjectionPath("{.spec.clients[*].ca}")
+	assert.NoError(t, err)
+	assert.Empty(t, expandWildcards(obj, elements))
+}
+
+func TestCAInjectionTargetObjectReconcilerWildcardPath(t *testing.T) {
+	var (
+		name      = "vault-issuer"
+		namespace = "cert-utils-operator"
+	)
+	caBundle := newTestCA(t)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vault-ca",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"ca.crt": caBundle,
+		},
+	}
+	issuer := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"servers": []interface{}{
+					map[string]interface{}{"name": "a", "caBundle": "user ca"},
+					map[string]interface{}{"name": "b"},
+				},
+			},
+		},
+	}
+	issuer.SetGroupVersionKind(issuerGVK)
+	issuer.SetName(name)
+	issuer.SetNamespace(namespace)
+	issuer.SetAnnotations(map[string]string{
+		"cert-utils-operator.redhat-cop.io/injectca-from-secret": namespace + "/vault-ca",
+	})
+	target := &redhatcopv1alpha1.CAInjectionTarget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "issuer-servers",
+		},
+		Spec: redhatcopv1alpha1.CAInjectionTargetSpec{
+			Group:   issuerGVK.Group,
+			Version: issuerGVK.Version,
+			Kind:    issuerGVK.Kind,
+			Paths: []redhatcopv1alpha1.InjectionPath{
+				{Path: "{.spec.servers[*].caBundle}", Encoding: redhatcopv1alpha1.PEMEncoding},
+			},
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(redhatcopv1alpha1.AddToScheme(s))
+	s.AddKnownTypeWithName(issuerGVK, &unstructured.Unstructured{})
+	s.AddKnownTypeWithName(issuerGVK.GroupVersion().WithKind(issuerGVK.Kind+"List"), &unstructured.UnstructuredList{})
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(secret, issuer, target).Build()
+
+	r := &caInjectionTargetObjectReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("cainjectiontarget_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, record.NewFakeRecorder(3), nil),
+		gvk:            issuerGVK,
+		cache:          cl,
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(issuerGVK)
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	servers, _, _ := unstructured.NestedSlice(instance.Object, "spec", "servers")
+	for _, server := range servers {
+		assert.Equal(t, string(caBundle), server.(map[string]interface{})["caBundle"])
+	}
+	// every item is tracked on its own
+	injectedFields, err := cutil.GetInjectedFields(instance)
+	assert.NoError(t, err)
+	assert.Equal(t, cutil.InjectedFields{
+		"{.spec.servers[0].caBundle}": []byte("user ca"),
+		"{.spec.servers[1].caBundle}": nil,
+	}, injectedFields)
+
+	// disabling the injection restores the ca bundles set before the injection and removes the other ones
+	annotations := instance.GetAnnotations()
+	delete(annotations, "cert-utils-operator.redhat-cop.io/injectca-from-secret")
+	instance.SetAnnotations(annotations)
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+
+	instance = &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(issuerGVK)
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	servers, _, _ = unstructured.NestedSlice(instance.Object, "spec", "servers")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "a", "caBundle": "user ca"},
+		map[string]interface{}{"name": "b"},
+	}, servers)
+	assert.NotContains(t, instance.GetAnnotations(), cutil.CertAnnotationInjectedFields)
+}
@@ -1,7 +1,6 @@
 package cainjection
 
 import (
-	"bytes"
 	"context"
 	"strings"
 
@@ -11,7 +10,6 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -23,13 +21,20 @@ type ConfigmapReconciler struct {
 	outils.ReconcilerBase
 	Log            logr.Logger
 	controllerName string
+	// ServiceCAWatcher triggers the injection of the service ca when it changes
+	ServiceCAWatcher *util.ServiceCAWatcher
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ConfigmapReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.controllerName = "configmap_ca_injection_controller"
 
-	return ctrl.NewControllerManagedBy(mgr).
+	err := util.IndexReferencingObjects(mgr.GetFieldIndexer(), &corev1.ConfigMap{}, util.CertAnnotationSecret, util.CertAnnotationConfigMap, util.CertAnnotationServiceCA)
+	if err != nil {
+		return err
+	}
+
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.ConfigMap{
 			TypeMeta: v1.TypeMeta{
 				Kind: "ConfigMap",
@@ -39,12 +44,23 @@ func (r *ConfigmapReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			TypeMeta: v1.TypeMeta{
 				Kind: "Secret",
 			},
-		}}, util.NewEnqueueRequestForReferecingObject(r.GetRestConfig(), schema.FromAPIVersionAndKind("v1", "ConfigMap")), builder.WithPredicates(util.IsCAContentChanged)).
-		Complete(r)
+		}}, util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &corev1.ConfigMapList{}, util.CertAnnotationSecret), builder.WithPredicates(util.IsCAContentChanged)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{
+			TypeMeta: v1.TypeMeta{
+				Kind: "ConfigMap",
+			},
+		}}, util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &corev1.ConfigMapList{}, util.CertAnnotationConfigMap), builder.WithPredicates(util.IsCAConfigMapContentChanged))
+
+	if r.ServiceCAWatcher != nil {
+		controllerBuilder = controllerBuilder.Watches(r.ServiceCAWatcher.NewSource(), util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &corev1.ConfigMapList{}, util.CertAnnotationServiceCA))
+	}
+
+	return controllerBuilder.Complete(r)
 }
 
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
 
 func (r *ConfigmapReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
@@ -64,36 +80,131 @@ func (r *ConfigmapReconciler) Reconcile(context context.Context, req ctrl.Reques
 		return reconcile.Result{}, err
 	}
 
-	caBundle := []byte{}
-
-	if secretNamespacedName, ok := instance.GetAnnotations()[util.CertAnnotationSecret]; ok {
-		err = util.ValidateSecretName(secretNamespacedName)
-		if err != nil {
-			log.Error(err, "invalid ca secret name", "secret", secretNamespacedName)
-			return r.ManageError(context, instance, err)
+	original := instance.DeepCopy()
+	caBundle, err := util.GetCABundle(r.GetClient(), instance)
+	if err != nil {
+		if util.RecordCrossNamespaceCAInjectionDenied(r.GetRecorder(), instance, err) {
+			// the injection is retried when the source is shared with the namespace of the object
+			return reconcile.Result{}, nil
 		}
-
-		//we need to inject the secret ca
-		caBundle, err = util.GetSecretCA(r.GetClient(), secretNamespacedName[strings.Index(secretNamespacedName, "/")+1:], secretNamespacedName[:strings.Index(secretNamespacedName, "/")])
+		log.Error(err, "unable to retrive ca bundle")
+		return r.ManageError(context, instance, err)
+	}
+	format, err := util.GetCAFormat(instance)
+	if err != nil {
+		log.Error(err, "invalid ca bundle format")
+		return r.ManageError(context, instance, err)
+	}
+	password := ""
+	if format == util.CAFormatJKS || format == util.CAFormatPKCS12 {
+		password, err = util.GetKeystorePassword(r.GetClient(), instance)
 		if err != nil {
-			log.Error(err, "unable to retrive ca from secret", "secret", secretNamespacedName)
+			log.Error(err, "unable to retrieve truststore password")
 			return r.ManageError(context, instance, err)
 		}
 	}
-	if len(caBundle) == 0 {
-		delete(instance.Data, util.CA)
-	} else {
-		if instance.Data == nil {
-			instance.Data = map[string]string{}
+	encoded, err := util.EncodeCABundle(caBundle, format, password)
+	if err != nil {
+		log.Error(err, "unable to encode ca bundle", "format", format)
+		return r.ManageError(context, instance, err)
+	}
+	injectedFields, err := util.GetInjectedFields(instance)
+	if err != nil {
+		return r.ManageError(context, instance, err)
+	}
+	enabled := util.IsCAInjectionEnabled(instance)
+	keys := []string{}
+	fields := []string{}
+	if enabled {
+		keys = util.GetCATargetKeys(instance)
+		for _, key := range keys {
+			fields = append(fields, configMapDataField(key), configMapBinaryDataField(key))
+		}
+	}
+	// the keys that are no longer injected are restored to their original value
+	for field, value := range injectedFields.Release(fields...) {
+		restoreConfigMapField(instance, field, value)
+	}
+	for _, key := range keys {
+		var currentData []byte
+		if value, ok := instance.Data[key]; ok {
+			currentData = []byte(value)
 		}
-		buffer := bytes.NewBuffer(caBundle)
-		instance.Data[util.CA] = buffer.String()
+		injectedFields.Track(configMapDataField(key), currentData, encoded)
+		injectedFields.Track(configMapBinaryDataField(key), instance.BinaryData[key], encoded)
+		injectConfigMapKey(instance, key, encoded, format, password)
+	}
+	err = util.SetInjectedFields(instance, injectedFields)
+	if err != nil {
+		return r.ManageError(context, instance, err)
 	}
-	err = r.GetClient().Update(context, instance)
 
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, instance, original)
 	if err != nil {
 		return r.ManageError(context, instance, err)
 	}
 
 	return r.ManageSuccess(context, instance)
 }
+
+// injectConfigMapKey stores the encoded ca bundle in key, in the data of the configmap for pem bundles and in its binary data otherwise.
+// An empty bundle removes the key.
+func injectConfigMapKey(configMap *corev1.ConfigMap, key string, encoded []byte, format string, password string) {
+	if len(encoded) == 0 {
+		delete(configMap.Data, key)
+		delete(configMap.BinaryData, key)
+		return
+	}
+	if util.IsBinaryCAFormat(format) {
+		if current, ok := configMap.BinaryData[key]; ok && util.IsEncodedCABundleEqual(current, encoded, format, password) {
+			// the current truststore contains the same certificates, it is kept to avoid a write
+			encoded = current
+		}
+		// a key cannot be both in data and binary data
+		delete(configMap.Data, key)
+		if configMap.BinaryData == nil {
+			configMap.BinaryData = map[string][]byte{}
+		}
+		configMap.BinaryData[key] = encoded
+		return
+	}
+	delete(configMap.BinaryData, key)
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[key] = string(encoded)
+}
+
+// configMapDataField is the field in which a pem ca bundle is injected for the given key of a configmap
+func configMapDataField(key string) string {
+	return "data." + key
+}
+
+// configMapBinaryDataField is the field in which a binary ca bundle is injected for the given key of a configmap
+func configMapBinaryDataField(key string) string {
+	return "binaryData." + key
+}
+
+// restoreConfigMapField sets field, either a data or a binary data key of the configmap, to its original value, removing it if original is nil
+func restoreConfigMapField(configMap *corev1.ConfigMap, field string, original []byte) {
+	if key := strings.TrimPrefix(field, "binaryData."); key != field {
+		if original == nil {
+			delete(configMap.BinaryData, key)
+			return
+		}
+		if configMap.BinaryData == nil {
+			configMap.BinaryData = map[string][]byte{}
+		}
+		configMap.BinaryData[key] = original
+		return
+	}
+	key := strings.TrimPrefix(field, "data.")
+	if original == nil {
+		delete(configMap.Data, key)
+		return
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[key] = string(original)
+}
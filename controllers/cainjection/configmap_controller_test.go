@@ -0,0 +1,256 @@
+package cainjection
+
+import (
+	"context"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	cutil "github.com/redhat-cop/cert-utils-operator/controllers/util"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestConfigmapReconcilerCrossNamespaceDenied(t *testing.T) {
+	previous := cutil.CrossNamespaceCAInjectionPolicy
+	cutil.CrossNamespaceCAInjectionPolicy = cutil.CrossNamespaceCAInjectionShared
+	defer func() {
+		cutil.CrossNamespaceCAInjectionPolicy = previous
+	}()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "private-ca",
+			Namespace: "certificates",
+		},
+		Type: cutil.TLSSecret,
+		Data: map[string][]byte{
+			"ca.crt": newTestCA(t),
+		},
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "target",
+			Namespace: "tenant",
+			Annotations: map[string]string{
+				cutil.CertAnnotationSecret: "certificates/private-ca",
+			},
+		},
+	}
+	cl := fake.NewFakeClient(secret, configMap)
+	recorder := record.NewFakeRecorder(3)
+	r := &ConfigmapReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("configmap_ca_injection_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, scheme.Scheme, nil, recorder, nil),
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      "target",
+			Namespace: "tenant",
+		},
+	}
+	// the denial is not retried until the source is shared
+	result, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, reconcile.Result{}, result)
+
+	instance := &corev1.ConfigMap{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	_, found := instance.Data["ca.crt"]
+	assert.False(t, found)
+
+	// the denial is reported as an event on the target
+	assert.Len(t, recorder.Events, 1)
+	event := <-recorder.Events
+	assert.True(t, strings.HasPrefix(event, "Warning"), event)
+	assert.Contains(t, event, cutil.CrossNamespaceCAInjectionDeniedReason)
+	assert.Contains(t, event, "cross-namespace ca injection denied")
+
+	secret.Annotations = map[string]string{
+		cutil.SharedWithNamespacesAnnotation: "tenant",
+	}
+	err = cl.Update(context.TODO(), secret)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &corev1.ConfigMap{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, string(secret.Data["ca.crt"]), instance.Data["ca.crt"])
+}
+
+func TestConfigmapReconcilerTargetKeysAndFormat(t *testing.T) {
+	caBundle := newTestCA(t)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ca",
+			Namespace: "test",
+		},
+		Type: cutil.TLSSecret,
+		Data: map[string][]byte{
+			"ca.crt": caBundle,
+		},
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "target",
+			Namespace: "test",
+			Annotations: map[string]string{
+				cutil.CertAnnotationSecret:    "test/ca",
+				cutil.CertAnnotationTargetKey: "ca-bundle.crt, service-ca.crt",
+			},
+		},
+		Data: map[string]string{
+			"truststore.p12": "stale",
+		},
+	}
+	cl := fake.NewFakeClient(secret, configMap)
+	r := &ConfigmapReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("configmap_ca_injection_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, scheme.Scheme, nil, record.NewFakeRecorder(3), nil),
+	}
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      "target",
+			Namespace: "test",
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance := &corev1.ConfigMap{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, string(caBundle), instance.Data["ca-bundle.crt"])
+	assert.Equal(t, string(caBundle), instance.Data["service-ca.crt"])
+	_, found := instance.Data["ca.crt"]
+	assert.False(t, found)
+
+	instance.Annotations[cutil.CertAnnotationTargetKey] = "truststore.p12"
+	instance.Annotations[cutil.CertAnnotationFormat] = "p12"
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &corev1.ConfigMap{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	_, found = instance.Data["truststore.p12"]
+	assert.False(t, found, "a key cannot be both in data and binary data")
+	certs, err := pkcs12.DecodeTrustStore(instance.BinaryData["truststore.p12"], "changeme")
+	assert.NoError(t, err)
+	assert.Len(t, certs, 1)
+
+	// pkcs12 truststores are salted, an up to date truststore is not rewritten
+	resourceVersion := instance.ResourceVersion
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &corev1.ConfigMap{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, resourceVersion, instance.ResourceVersion)
+
+	instance.Annotations[cutil.CertAnnotationTargetKey] = "ca.der"
+	instance.Annotations[cutil.CertAnnotationFormat] = "der"
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &corev1.ConfigMap{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	block, _ := pem.Decode(caBundle)
+	assert.Equal(t, block.Bytes, instance.BinaryData["ca.der"])
+
+	instance.Annotations[cutil.CertAnnotationFormat] = "pkcs7"
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.Error(t, err)
+}
+
+func TestConfigmapReconcilerRevertsInjection(t *testing.T) {
+	caBundle := newTestCA(t)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ca",
+			Namespace: "test",
+		},
+		Type: cutil.TLSSecret,
+		Data: map[string][]byte{
+			"ca.crt": caBundle,
+		},
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "target",
+			Namespace: "test",
+			Annotations: map[string]string{
+				cutil.CertAnnotationSecret:    "test/ca",
+				cutil.CertAnnotationTargetKey: "ca-bundle.crt,ca.crt",
+			},
+		},
+		Data: map[string]string{
+			"ca-bundle.crt": "original",
+		},
+	}
+	cl := fake.NewFakeClient(secret, configMap)
+	r := &ConfigmapReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("configmap_ca_injection_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, scheme.Scheme, nil, record.NewFakeRecorder(3), nil),
+	}
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      "target",
+			Namespace: "test",
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance := &corev1.ConfigMap{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, string(caBundle), instance.Data["ca-bundle.crt"])
+	assert.Equal(t, string(caBundle), instance.Data["ca.crt"])
+	injectedFields, err := cutil.GetInjectedFields(instance)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("original"), injectedFields["data.ca-bundle.crt"])
+	assert.Nil(t, injectedFields["data.ca.crt"])
+
+	// a key that is no longer a target is restored
+	instance.Annotations[cutil.CertAnnotationTargetKey] = "ca.crt"
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &corev1.ConfigMap{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, "original", instance.Data["ca-bundle.crt"])
+	assert.Equal(t, string(caBundle), instance.Data["ca.crt"])
+
+	// removing the injection annotation reverts the injection
+	delete(instance.Annotations, cutil.CertAnnotationSecret)
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &corev1.ConfigMap{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"ca-bundle.crt": "original"}, instance.Data)
+	_, found := instance.Annotations[cutil.CertAnnotationInjectedFields]
+	assert.False(t, found)
+}
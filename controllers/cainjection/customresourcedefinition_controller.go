@@ -2,7 +2,6 @@ package cainjection
 
 import (
 	"context"
-	"strings"
 
 	"github.com/go-logr/logr"
 	"github.com/redhat-cop/cert-utils-operator/controllers/util"
@@ -11,7 +10,6 @@ import (
 	crd "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -23,13 +21,20 @@ type CRDReconciler struct {
 	outils.ReconcilerBase
 	Log            logr.Logger
 	controllerName string
+	// ServiceCAWatcher triggers the injection of the service ca when it changes
+	ServiceCAWatcher *util.ServiceCAWatcher
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *CRDReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.controllerName = "crd_ca_injection_controller"
 
-	return ctrl.NewControllerManagedBy(mgr).
+	err := util.IndexReferencingObjects(mgr.GetFieldIndexer(), &crd.CustomResourceDefinition{}, util.CertAnnotationSecret, util.CertAnnotationConfigMap, util.CertAnnotationServiceCA)
+	if err != nil {
+		return err
+	}
+
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
 		For(&crd.CustomResourceDefinition{
 			TypeMeta: v1.TypeMeta{
 				Kind: "CustomResourceDefinition",
@@ -39,12 +44,25 @@ func (r *CRDReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			TypeMeta: v1.TypeMeta{
 				Kind: "Secret",
 			},
-		}}, util.NewEnqueueRequestForReferecingObject(r.GetRestConfig(), schema.FromAPIVersionAndKind("apiextensions.k8s.io/v1", "CustomResourceDefinition")), builder.WithPredicates(util.IsCAContentChanged)).
-		Complete(r)
+		}}, util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &crd.CustomResourceDefinitionList{}, util.CertAnnotationSecret), builder.WithPredicates(util.IsCAContentChanged)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{
+			TypeMeta: v1.TypeMeta{
+				Kind: "ConfigMap",
+			},
+		}}, util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &crd.CustomResourceDefinitionList{}, util.CertAnnotationConfigMap), builder.WithPredicates(util.IsCAConfigMapContentChanged))
+
+	if r.ServiceCAWatcher != nil {
+		controllerBuilder = controllerBuilder.Watches(r.ServiceCAWatcher.NewSource(), util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &crd.CustomResourceDefinitionList{}, util.CertAnnotationServiceCA))
+	}
+
+	return controllerBuilder.Complete(r)
 }
 
+const crdCABundleField = "spec.conversion.webhook.clientConfig.caBundle"
+
 // +kubebuilder:rbac:groups="apiextensions.k8s.io",resources=customresourcedefinitions,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
 
 func (r *CRDReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
@@ -64,29 +82,38 @@ func (r *CRDReconciler) Reconcile(context context.Context, req ctrl.Request) (re
 		return reconcile.Result{}, err
 	}
 
-	caBundle := []byte{}
-
-	if secretNamespacedName, ok := instance.GetAnnotations()[util.CertAnnotationSecret]; ok {
-		err = util.ValidateSecretName(secretNamespacedName)
-		if err != nil {
-			log.Error(err, "invalid ca secret name", "secret", secretNamespacedName)
-			return r.ManageError(context, instance, err)
-		}
-		//we need to inject the secret ca
-		caBundle, err = util.GetSecretCA(r.GetClient(), secretNamespacedName[strings.Index(secretNamespacedName, "/")+1:], secretNamespacedName[:strings.Index(secretNamespacedName, "/")])
-		if err != nil {
-			log.Error(err, "unable to retrive ca from secret", "secret", secretNamespacedName)
-			return r.ManageError(context, instance, err)
-		}
+	original := instance.DeepCopy()
+	caBundle, err := util.GetCABundle(r.GetClient(), instance)
+	if err != nil {
+		log.Error(err, "unable to retrive ca bundle")
+		return r.ManageError(context, instance, err)
 	}
 
+	injectedFields, err := util.GetInjectedFields(instance)
+	if err != nil {
+		return r.ManageError(context, instance, err)
+	}
+	enabled := util.IsCAInjectionEnabled(instance)
+	released := util.InjectedFields{}
+	if !enabled {
+		released = injectedFields.Release()
+	}
 	//we update only if the fields are initialized
-	if instance.Spec.Conversion != nil {
-		if instance.Spec.Conversion.Webhook != nil {
-			instance.Spec.Conversion.Webhook.ClientConfig.CABundle = caBundle
-			err = r.GetClient().Update(context, instance)
+	if instance.Spec.Conversion != nil && instance.Spec.Conversion.Webhook != nil && instance.Spec.Conversion.Webhook.ClientConfig != nil {
+		clientConfig := instance.Spec.Conversion.Webhook.ClientConfig
+		if value, ok := released[crdCABundleField]; ok {
+			// the injection was disabled, the original ca bundle is restored
+			clientConfig.CABundle = value
+		} else if enabled {
+			injectedFields.Track(crdCABundleField, clientConfig.CABundle, caBundle)
+			clientConfig.CABundle = caBundle
 		}
 	}
+	err = util.SetInjectedFields(instance, injectedFields)
+	if err != nil {
+		return r.ManageError(context, instance, err)
+	}
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, instance, original)
 	if err != nil {
 		return r.ManageError(context, instance, err)
 	}
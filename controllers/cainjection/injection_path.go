@@ -0,0 +1,211 @@
+package cainjection
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// pathElement is either a field of an object or a selection of items of a list
+type pathElement struct {
+	field string
+	// index is used when field is empty, a negative index selects all the items
+	index int
+}
+
+func (e pathElement) isField() bool {
+	return e.field != ""
+}
+
+// parseInjectionPath parses a simple JSONPath expression such as {.spec.endpoints[*].tlsConfig.ca}.
+// Only field names, [*] wildcards and numeric array indexes are supported, and the path must end with a field.
+func parseInjectionPath(path string) ([]pathElement, error) {
+	expression := strings.TrimSpace(path)
+	expression = strings.TrimSuffix(strings.TrimPrefix(expression, "{"), "}")
+	expression = strings.TrimPrefix(expression, "$")
+	if !strings.HasPrefix(expression, ".") {
+		return nil, errors.New("invalid path " + path + ", it must start with .")
+	}
+	elements := []pathElement{}
+	for len(expression) > 0 {
+		switch expression[0] {
+		case '.':
+			end := strings.IndexAny(expression[1:], ".[")
+			if end == -1 {
+				end = len(expression) - 1
+			}
+			field := expression[1 : end+1]
+			if field == "" {
+				return nil, errors.New("invalid path " + path + ", empty field name")
+			}
+			elements = append(elements, pathElement{field: field})
+			expression = expression[end+1:]
+		case '[':
+			end := strings.Index(expression, "]")
+			if end == -1 {
+				return nil, errors.New("invalid path " + path + ", unterminated [")
+			}
+			selector := expression[1:end]
+			if selector == "*" {
+				elements = append(elements, pathElement{index: -1})
+			} else {
+				index, err := strconv.Atoi(selector)
+				if err != nil || index < 0 {
+					return nil, errors.New("invalid path " + path + ", only [*] and non negative indexes are supported")
+				}
+				elements = append(elements, pathElement{index: index})
+			}
+			expression = expression[end+1:]
+		default:
+			return nil, errors.New("invalid path " + path + ", unexpected character " + string(expression[0]))
+		}
+	}
+	if len(elements) == 0 || !elements[len(elements)-1].isField() {
+		return nil, errors.New("invalid path " + path + ", it must end with a field")
+	}
+	return elements, nil
+}
+
+// setPathValue sets value in the fields of current matched by elements, creating the missing intermediate objects.
+// An empty value removes the fields. Lists are never created, so a path going through a missing list matches nothing.
+// It returns whether current was changed.
+func setPathValue(current interface{}, elements []pathElement, value string) (bool, error) {
+	element := elements[0]
+	if element.isField() {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return false, errors.New("unable to select field " + element.field + ", parent is not an object")
+		}
+		child, found := object[element.field]
+		if len(elements) == 1 {
+			if value == "" {
+				if found {
+					delete(object, element.field)
+					return true, nil
+				}
+				return false, nil
+			}
+			if found && child == value {
+				return false, nil
+			}
+			object[element.field] = value
+			return true, nil
+		}
+		if !found || child == nil {
+			if value == "" || !elements[1].isField() {
+				return false, nil
+			}
+			child = map[string]interface{}{}
+			object[element.field] = child
+		}
+		return setPathValue(child, elements[1:], value)
+	}
+	list, ok := current.([]interface{})
+	if !ok {
+		return false, errors.New("unable to select items, parent is not a list")
+	}
+	if element.index >= len(list) {
+		return false, nil
+	}
+	items := list
+	if element.index >= 0 {
+		items = list[element.index : element.index+1]
+	}
+	changed := false
+	for _, item := range items {
+		itemChanged, err := setPathValue(item, elements[1:], value)
+		if err != nil {
+			return false, err
+		}
+		changed = itemChanged || changed
+	}
+	return changed, nil
+}
+
+// getPathValue returns the string value of the field of current matched by elements, or nil if the field is not set.
+// Paths selecting all the items of a list may match more than one field, so they always return nil, use expandWildcards to read them.
+func getPathValue(current interface{}, elements []pathElement) []byte {
+	for _, element := range elements {
+		if element.isField() {
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			current, ok = object[element.field]
+			if !ok {
+				return nil
+			}
+			continue
+		}
+		list, ok := current.([]interface{})
+		if !ok || element.index < 0 || element.index >= len(list) {
+			return nil
+		}
+		current = list[element.index]
+	}
+	value, ok := current.(string)
+	if !ok {
+		return nil
+	}
+	return []byte(value)
+}
+
+// hasWildcard returns whether elements select all the items of a list
+func hasWildcard(elements []pathElement) bool {
+	for _, element := range elements {
+		if !element.isField() && element.index < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// expandWildcards returns, for every item of the lists of current selected by the [*] wildcards of elements, the path selecting
+// that item by its index. Lists are never created, so a path going through a missing list expands to nothing.
+func expandWildcards(current interface{}, elements []pathElement) [][]pathElement {
+	if len(elements) == 0 {
+		return [][]pathElement{{}}
+	}
+	element := elements[0]
+	var children []interface{}
+	var selectors []pathElement
+	if element.isField() {
+		object, _ := current.(map[string]interface{})
+		children, selectors = []interface{}{object[element.field]}, []pathElement{element}
+	} else if list, _ := current.([]interface{}); element.index >= 0 {
+		var item interface{}
+		if element.index < len(list) {
+			item = list[element.index]
+		}
+		children, selectors = []interface{}{item}, []pathElement{element}
+	} else {
+		for i, item := range list {
+			children = append(children, item)
+			selectors = append(selectors, pathElement{index: i})
+		}
+	}
+	paths := [][]pathElement{}
+	for i, child := range children {
+		for _, rest := range expandWildcards(child, elements[1:]) {
+			paths = append(paths, append([]pathElement{selectors[i]}, rest...))
+		}
+	}
+	return paths
+}
+
+// formatInjectionPath returns the JSONPath expression of elements, in the format accepted by parseInjectionPath
+func formatInjectionPath(elements []pathElement) string {
+	var builder strings.Builder
+	builder.WriteString("{")
+	for _, element := range elements {
+		if element.isField() {
+			builder.WriteString("." + element.field)
+		} else if element.index < 0 {
+			builder.WriteString("[*]")
+		} else {
+			builder.WriteString("[" + strconv.Itoa(element.index) + "]")
+		}
+	}
+	builder.WriteString("}")
+	return builder.String()
+}
@@ -10,7 +10,6 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -22,14 +21,21 @@ type SecretReconciler struct {
 	outils.ReconcilerBase
 	Log            logr.Logger
 	controllerName string
+	// ServiceCAWatcher triggers the injection of the service ca when it changes
+	ServiceCAWatcher *util.ServiceCAWatcher
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.controllerName = "secret_ca_injection_controller"
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.ConfigMap{
+	err := util.IndexReferencingObjects(mgr.GetFieldIndexer(), &corev1.Secret{}, util.CertAnnotationSecret, util.CertAnnotationConfigMap, util.CertAnnotationServiceCA)
+	if err != nil {
+		return err
+	}
+
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Secret{
 			TypeMeta: v1.TypeMeta{
 				Kind: "Secret",
 			},
@@ -38,12 +44,23 @@ func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			TypeMeta: v1.TypeMeta{
 				Kind: "Secret",
 			},
-		}}, util.NewEnqueueRequestForReferecingObject(r.GetRestConfig(), schema.FromAPIVersionAndKind("v1", "Secret")), builder.WithPredicates(util.IsCAContentChanged)).
-		Complete(r)
+		}}, util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &corev1.SecretList{}, util.CertAnnotationSecret), builder.WithPredicates(util.IsCAContentChanged)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{
+			TypeMeta: v1.TypeMeta{
+				Kind: "ConfigMap",
+			},
+		}}, util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &corev1.SecretList{}, util.CertAnnotationConfigMap), builder.WithPredicates(util.IsCAConfigMapContentChanged))
+
+	if r.ServiceCAWatcher != nil {
+		controllerBuilder = controllerBuilder.Watches(r.ServiceCAWatcher.NewSource(), util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &corev1.SecretList{}, util.CertAnnotationServiceCA))
+	}
+
+	return controllerBuilder.Complete(r)
 }
 
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
 
 func (r *SecretReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
@@ -63,33 +80,89 @@ func (r *SecretReconciler) Reconcile(context context.Context, req ctrl.Request)
 		return reconcile.Result{}, err
 	}
 
-	caBundle := []byte{}
-
-	if secretNamespacedName, ok := instance.GetAnnotations()[util.CertAnnotationSecret]; ok {
-		err = util.ValidateSecretName(secretNamespacedName)
-		if err != nil {
-			log.Error(err, "invalid ca secret name", "secret", secretNamespacedName)
-			return r.ManageError(context, instance, err)
+	original := instance.DeepCopy()
+	caBundle, err := util.GetCABundle(r.GetClient(), instance)
+	if err != nil {
+		if util.RecordCrossNamespaceCAInjectionDenied(r.GetRecorder(), instance, err) {
+			// the injection is retried when the source is shared with the namespace of the object
+			return reconcile.Result{}, nil
 		}
-
-		//we need to inject the secret ca
-		caBundle, err = util.GetSecretCA(r.GetClient(), secretNamespacedName[strings.Index(secretNamespacedName, "/")+1:], secretNamespacedName[:strings.Index(secretNamespacedName, "/")])
+		log.Error(err, "unable to retrive ca bundle")
+		return r.ManageError(context, instance, err)
+	}
+	format, err := util.GetCAFormat(instance)
+	if err != nil {
+		log.Error(err, "invalid ca bundle format")
+		return r.ManageError(context, instance, err)
+	}
+	password := ""
+	if format == util.CAFormatJKS || format == util.CAFormatPKCS12 {
+		password, err = util.GetKeystorePassword(r.GetClient(), instance)
 		if err != nil {
-			log.Error(err, "unable to retrive ca from secret", "secret", secretNamespacedName)
+			log.Error(err, "unable to retrieve truststore password")
 			return r.ManageError(context, instance, err)
 		}
 	}
-	if len(caBundle) == 0 {
-		delete(instance.Data, util.CA)
-	} else {
-		instance.Data[util.CA] = caBundle
+	encoded, err := util.EncodeCABundle(caBundle, format, password)
+	if err != nil {
+		log.Error(err, "unable to encode ca bundle", "format", format)
+		return r.ManageError(context, instance, err)
+	}
+	injectedFields, err := util.GetInjectedFields(instance)
+	if err != nil {
+		return r.ManageError(context, instance, err)
+	}
+	enabled := util.IsCAInjectionEnabled(instance)
+	keys := []string{}
+	fields := []string{}
+	if enabled {
+		keys = util.GetCATargetKeys(instance)
+		for _, key := range keys {
+			fields = append(fields, secretDataField(key))
+		}
+	}
+	// the keys that are no longer injected are restored to their original value
+	for field, value := range injectedFields.Release(fields...) {
+		key := strings.TrimPrefix(field, "data.")
+		if value == nil {
+			delete(instance.Data, key)
+			continue
+		}
+		if instance.Data == nil {
+			instance.Data = map[string][]byte{}
+		}
+		instance.Data[key] = value
+	}
+	for _, key := range keys {
+		current, found := instance.Data[key]
+		injectedFields.Track(secretDataField(key), current, encoded)
+		if len(caBundle) == 0 {
+			delete(instance.Data, key)
+			continue
+		}
+		if found && util.IsEncodedCABundleEqual(current, encoded, format, password) {
+			// the ca bundle is already up to date
+			continue
+		}
+		if instance.Data == nil {
+			instance.Data = map[string][]byte{}
+		}
+		instance.Data[key] = encoded
+	}
+	err = util.SetInjectedFields(instance, injectedFields)
+	if err != nil {
+		return r.ManageError(context, instance, err)
 	}
 
-	err = r.GetClient().Update(context, instance)
-
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, instance, original)
 	if err != nil {
 		return r.ManageError(context, instance, err)
 	}
 
 	return r.ManageSuccess(context, instance)
 }
+
+// secretDataField is the field in which the ca bundle is injected for the given key of a secret
+func secretDataField(key string) string {
+	return "data." + key
+}
@@ -0,0 +1,73 @@
+package cainjection
+
+import (
+	"context"
+	"testing"
+
+	cutil "github.com/redhat-cop/cert-utils-operator/controllers/util"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestSecretReconcilerRevertsInjection(t *testing.T) {
+	caBundle := newTestCA(t)
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ca",
+			Namespace: "test",
+		},
+		Type: cutil.TLSSecret,
+		Data: map[string][]byte{
+			"ca.crt": caBundle,
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "target",
+			Namespace: "test",
+			Annotations: map[string]string{
+				cutil.CertAnnotationSecret: "test/ca",
+			},
+		},
+		Data: map[string][]byte{
+			"ca.crt": []byte("original"),
+		},
+	}
+	cl := fake.NewFakeClient(source, secret)
+	r := &SecretReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("secret_ca_injection_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, scheme.Scheme, nil, record.NewFakeRecorder(3), nil),
+	}
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      "target",
+			Namespace: "test",
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance := &corev1.Secret{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, caBundle, instance.Data["ca.crt"])
+
+	delete(instance.Annotations, cutil.CertAnnotationSecret)
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &corev1.Secret{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"ca.crt": []byte("original")}, instance.Data)
+	assert.NotContains(t, instance.Annotations, cutil.CertAnnotationInjectedFields)
+}
@@ -2,7 +2,6 @@ package cainjection
 
 import (
 	"context"
-	"strings"
 
 	"github.com/go-logr/logr"
 	"github.com/redhat-cop/cert-utils-operator/controllers/util"
@@ -11,9 +10,9 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
@@ -23,13 +22,20 @@ type ValidatingWebhookConfigurationReconciler struct {
 	outils.ReconcilerBase
 	Log            logr.Logger
 	controllerName string
+	// ServiceCAWatcher triggers the injection of the service ca when it changes
+	ServiceCAWatcher *util.ServiceCAWatcher
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ValidatingWebhookConfigurationReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.controllerName = "validating_webhook_ca_injection_controller"
 
-	return ctrl.NewControllerManagedBy(mgr).
+	err := util.IndexReferencingObjects(mgr.GetFieldIndexer(), &admissionregistrationv1.ValidatingWebhookConfiguration{}, util.CertAnnotationSecret, util.CertAnnotationConfigMap, util.CertAnnotationServiceCA)
+	if err != nil {
+		return err
+	}
+
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
 		For(&admissionregistrationv1.ValidatingWebhookConfiguration{
 			TypeMeta: v1.TypeMeta{
 				Kind: "ValidatingWebhookConfiguration",
@@ -39,12 +45,23 @@ func (r *ValidatingWebhookConfigurationReconciler) SetupWithManager(mgr ctrl.Man
 			TypeMeta: v1.TypeMeta{
 				Kind: "Secret",
 			},
-		}}, util.NewEnqueueRequestForReferecingObject(r.GetRestConfig(), schema.FromAPIVersionAndKind("admissionregistration.k8s.io/v1", "ValidatingWebhookConfiguration")), builder.WithPredicates(util.IsCAContentChanged)).
-		Complete(r)
+		}}, util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &admissionregistrationv1.ValidatingWebhookConfigurationList{}, util.CertAnnotationSecret), builder.WithPredicates(util.IsCAContentChanged)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{
+			TypeMeta: v1.TypeMeta{
+				Kind: "ConfigMap",
+			},
+		}}, util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &admissionregistrationv1.ValidatingWebhookConfigurationList{}, util.CertAnnotationConfigMap), builder.WithPredicates(util.IsCAConfigMapContentChanged))
+
+	if r.ServiceCAWatcher != nil {
+		controllerBuilder = controllerBuilder.Watches(r.ServiceCAWatcher.NewSource(), util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &admissionregistrationv1.ValidatingWebhookConfigurationList{}, util.CertAnnotationServiceCA))
+	}
+
+	return controllerBuilder.Complete(r)
 }
 
 // +kubebuilder:rbac:groups="admissionregistration.k8s.io",resources=validatingwebhookconfigurations,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
 
 func (r *ValidatingWebhookConfigurationReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
@@ -64,25 +81,40 @@ func (r *ValidatingWebhookConfigurationReconciler) Reconcile(context context.Con
 		return reconcile.Result{}, err
 	}
 
-	caBundle := []byte{}
-
-	if secretNamespacedName, ok := instance.GetAnnotations()[util.CertAnnotationSecret]; ok {
-		err = util.ValidateSecretName(secretNamespacedName)
-		if err != nil {
-			log.Error(err, "invalid ca secret name", "secret", secretNamespacedName)
-			return r.ManageError(context, instance, err)
-		}
-		//we need to inject the secret ca
-		caBundle, err = util.GetSecretCA(r.GetClient(), secretNamespacedName[strings.Index(secretNamespacedName, "/")+1:], secretNamespacedName[:strings.Index(secretNamespacedName, "/")])
-		if err != nil {
-			log.Error(err, "unable to retrive ca from secret", "secret", secretNamespacedName)
-			return r.ManageError(context, instance, err)
+	original := instance.DeepCopy()
+	caBundle, err := util.GetCABundle(r.GetClient(), instance)
+	if err != nil {
+		log.Error(err, "unable to retrive ca bundle")
+		return r.ManageError(context, instance, err)
+	}
+	injectedFields, err := util.GetInjectedFields(instance)
+	if err != nil {
+		return r.ManageError(context, instance, err)
+	}
+	enabled := util.IsCAInjectionEnabled(instance)
+	fields := []string{}
+	if enabled {
+		for i := range instance.Webhooks {
+			fields = append(fields, webhookCABundleField(instance.Webhooks[i].Name))
 		}
 	}
+	released := injectedFields.Release(fields...)
 	for i := range instance.Webhooks {
-		instance.Webhooks[i].ClientConfig.CABundle = caBundle
+		field := webhookCABundleField(instance.Webhooks[i].Name)
+		if value, ok := released[field]; ok {
+			// the injection was disabled, the original ca bundle is restored
+			instance.Webhooks[i].ClientConfig.CABundle = value
+		} else if enabled {
+			injectedFields.Track(field, instance.Webhooks[i].ClientConfig.CABundle, caBundle)
+			instance.Webhooks[i].ClientConfig.CABundle = caBundle
+		}
+	}
+	err = util.SetInjectedFields(instance, injectedFields)
+	if err != nil {
+		return r.ManageError(context, instance, err)
 	}
-	err = r.GetClient().Update(context, instance)
+	// webhooks are merged by name, so that only their ca bundles are changed
+	_, err = util.PatchIfChanged(context, r.GetClient(), r.controllerName, instance, original, client.StrategicMergeFrom(original))
 	if err != nil {
 		return r.ManageError(context, instance, err)
 	}
@@ -0,0 +1,76 @@
+package cainjection
+
+import (
+	"context"
+	"testing"
+
+	cutil "github.com/redhat-cop/cert-utils-operator/controllers/util"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestValidatingWebhookConfigurationReconcilerRevertsInjection(t *testing.T) {
+	caBundle := newTestCA(t)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ca",
+			Namespace: "test",
+		},
+		Type: cutil.TLSSecret,
+		Data: map[string][]byte{
+			"ca.crt": caBundle,
+		},
+	}
+	webhookConfiguration := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "webhook",
+			Annotations: map[string]string{
+				cutil.CertAnnotationSecret: "test/ca",
+			},
+		},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{Name: "a.example.com", ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: []byte("original")}},
+			{Name: "b.example.com"},
+		},
+	}
+	cl := fake.NewFakeClient(secret, webhookConfiguration)
+	r := &ValidatingWebhookConfigurationReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("validating_webhook_ca_injection_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, scheme.Scheme, nil, record.NewFakeRecorder(3), nil),
+	}
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "webhook",
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	for _, webhook := range instance.Webhooks {
+		assert.Equal(t, caBundle, webhook.ClientConfig.CABundle)
+	}
+
+	delete(instance.Annotations, cutil.CertAnnotationSecret)
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("original"), instance.Webhooks[0].ClientConfig.CABundle)
+	assert.Empty(t, instance.Webhooks[1].ClientConfig.CABundle)
+	assert.NotContains(t, instance.Annotations, cutil.CertAnnotationInjectedFields)
+}
@@ -73,6 +73,9 @@ func init() {
 func deleteMetrics(ctx context.Context, secret *corev1.Secret) {
 	issueTime.DeleteLabelValues(secret.Name, secret.Namespace)
 	expiryTime.DeleteLabelValues(secret.Name, secret.Namespace)
+	if chainMetricsInstance != nil {
+		chainMetricsInstance.delete(secret)
+	}
 }
 
 func updateMetrics(ctx context.Context, secret *corev1.Secret) {
@@ -81,11 +84,18 @@ func updateMetrics(ctx context.Context, secret *corev1.Secret) {
 	expiryGauge := expiryTime.WithLabelValues(secret.Name, secret.Namespace)
 	creationGauge.Set(float64(creation.Unix()))
 	expiryGauge.Set(float64(expiry.Unix()))
+	if chainMetricsInstance != nil {
+		chainMetricsInstance.update(ctx, secret)
+	}
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *CertExpiryAlertReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.controllerName = "certexpiryalert_controller"
+	err := registerChainMetrics()
+	if err != nil {
+		return err
+	}
 	ctx := context.TODO()
 	ctx = log.IntoContext(ctx, r.Log)
 	isAnnotatedSecret := predicate.Funcs{
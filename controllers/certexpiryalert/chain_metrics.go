@@ -0,0 +1,214 @@
+package certexpiryalert
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// labels of the certificate chain metrics, in addition to the name and the namespace of the secret
+const (
+	// ChainMetricLabelKey is the key of the secret containing the certificate, tls.crt or ca.crt
+	ChainMetricLabelKey = "key"
+	// ChainMetricLabelPosition is the position of the certificate in the chain or bundle, starting from 0
+	ChainMetricLabelPosition = "position"
+	// ChainMetricLabelSubjectCN is the common name of the subject of the certificate
+	ChainMetricLabelSubjectCN = "subject_cn"
+	// ChainMetricLabelIssuerCN is the common name of the issuer of the certificate
+	ChainMetricLabelIssuerCN = "issuer_cn"
+	// ChainMetricLabelSerial is the serial number of the certificate
+	ChainMetricLabelSerial = "serial"
+)
+
+// SupportedChainMetricLabels are the labels that can be added to the certificate chain metrics
+var SupportedChainMetricLabels = []string{ChainMetricLabelKey, ChainMetricLabelPosition, ChainMetricLabelSubjectCN, ChainMetricLabelIssuerCN, ChainMetricLabelSerial}
+
+// ChainMetricLabels are the labels added to the certificate chain metrics. The serial is excluded by default, as it changes at every renewal.
+var ChainMetricLabels = []string{ChainMetricLabelKey, ChainMetricLabelPosition, ChainMetricLabelSubjectCN, ChainMetricLabelIssuerCN}
+
+// ValidateChainMetricLabels returns an error if one of labels is not in SupportedChainMetricLabels, or if labels does not contain
+// ChainMetricLabelKey, which the CertificateAuthorityBundleContainsExpiredCertificate alert uses to select the certificates of ca.crt
+func ValidateChainMetricLabels(labels []string) error {
+	hasKey := false
+	for _, label := range labels {
+		if label == ChainMetricLabelKey {
+			hasKey = true
+		}
+		supported := false
+		for _, supportedLabel := range SupportedChainMetricLabels {
+			if label == supportedLabel {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return errors.New("unsupported certificate chain metric label " + label + ", it must be one of " + strings.Join(SupportedChainMetricLabels, ", "))
+		}
+	}
+	if !hasKey {
+		return errors.New("the certificate chain metric labels must contain " + ChainMetricLabelKey + ", the alerting rules select the certificates by key")
+	}
+	return nil
+}
+
+// chainMetrics are the gauges describing every certificate of the tls.crt and ca.crt of the tls secrets
+type chainMetrics struct {
+	labels     []string
+	issueTime  *prometheus.GaugeVec
+	expiryTime *prometheus.GaugeVec
+	lock       sync.Mutex
+	// series are the label values of the series of every secret, so that they can be deleted when the certificates change
+	series map[types.NamespacedName][][]string
+}
+
+// chainMetricsInstance is registered by SetupWithManager, because its labels are only known after parsing the flags
+var chainMetricsInstance *chainMetrics
+
+func newChainMetrics(labels []string) *chainMetrics {
+	labelNames := append([]string{"name", "namespace"}, labels...)
+	return &chainMetrics{
+		labels: labels,
+		issueTime: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Subsystem: "certutils",
+				Name:      "certificate_chain_issue_time",
+				Help:      "time at which each certificate of tls.crt and ca.crt was issued in number of seconds from January 1, 1970 UTC",
+			},
+			labelNames,
+		),
+		expiryTime: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Subsystem: "certutils",
+				Name:      "certificate_chain_expiry_time",
+				Help:      "time at which each certificate of tls.crt and ca.crt expires in number of seconds from January 1, 1970 UTC",
+			},
+			labelNames,
+		),
+		series: map[types.NamespacedName][][]string{},
+	}
+}
+
+// chainMetricSample is the value of the series of a certificate
+type chainMetricSample struct {
+	labelValues []string
+	issue       time.Time
+	expiry      time.Time
+}
+
+// update replaces the series of secret with the ones of its current certificates. When the allowed labels do not distinguish
+// two certificates, the series describes the one that expires first.
+func (m *chainMetrics) update(ctx context.Context, secret *corev1.Secret) {
+	samples := []*chainMetricSample{}
+	index := map[string]*chainMetricSample{}
+	for _, key := range []string{util.Cert, util.CA} {
+		for position, cert := range parseChain(ctx, secret.Data[key]) {
+			labelValues := []string{secret.Name, secret.Namespace}
+			for _, label := range m.labels {
+				labelValues = append(labelValues, chainMetricLabelValue(label, key, position, cert))
+			}
+			id := strings.Join(labelValues, "\x00")
+			if sample, ok := index[id]; ok {
+				if cert.NotAfter.Before(sample.expiry) {
+					sample.issue, sample.expiry = cert.NotBefore, cert.NotAfter
+				}
+				continue
+			}
+			sample := &chainMetricSample{labelValues: labelValues, issue: cert.NotBefore, expiry: cert.NotAfter}
+			index[id] = sample
+			samples = append(samples, sample)
+		}
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	name := types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}
+	for _, labelValues := range m.series[name] {
+		if _, ok := index[strings.Join(labelValues, "\x00")]; !ok {
+			m.issueTime.DeleteLabelValues(labelValues...)
+			m.expiryTime.DeleteLabelValues(labelValues...)
+		}
+	}
+	series := [][]string{}
+	for _, sample := range samples {
+		m.issueTime.WithLabelValues(sample.labelValues...).Set(float64(sample.issue.Unix()))
+		m.expiryTime.WithLabelValues(sample.labelValues...).Set(float64(sample.expiry.Unix()))
+		series = append(series, sample.labelValues)
+	}
+	if len(series) == 0 {
+		delete(m.series, name)
+	} else {
+		m.series[name] = series
+	}
+}
+
+// delete removes all the series of secret
+func (m *chainMetrics) delete(secret *corev1.Secret) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	name := types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}
+	for _, labelValues := range m.series[name] {
+		m.issueTime.DeleteLabelValues(labelValues...)
+		m.expiryTime.DeleteLabelValues(labelValues...)
+	}
+	delete(m.series, name)
+}
+
+func chainMetricLabelValue(label string, key string, position int, cert *x509.Certificate) string {
+	switch label {
+	case ChainMetricLabelKey:
+		return key
+	case ChainMetricLabelPosition:
+		return strconv.Itoa(position)
+	case ChainMetricLabelSubjectCN:
+		return cert.Subject.CommonName
+	case ChainMetricLabelIssuerCN:
+		return cert.Issuer.CommonName
+	case ChainMetricLabelSerial:
+		return cert.SerialNumber.Text(16)
+	}
+	return ""
+}
+
+// parseChain returns the certificates of the pem bundle, skipping the ones that cannot be parsed
+func parseChain(ctx context.Context, bundle []byte) []*x509.Certificate {
+	ilog := log.FromContext(ctx)
+	certs := []*x509.Certificate{}
+	for p, rest := pem.Decode(bundle); p != nil; p, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			ilog.Error(err, "unable to decode this entry, skipping", "entry", string(p.Bytes))
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	return certs
+}
+
+// registerChainMetrics creates and registers the certificate chain metrics with ChainMetricLabels
+func registerChainMetrics() error {
+	if chainMetricsInstance != nil {
+		return nil
+	}
+	instance := newChainMetrics(ChainMetricLabels)
+	err := metrics.Registry.Register(instance.issueTime)
+	if err != nil {
+		return err
+	}
+	err = metrics.Registry.Register(instance.expiryTime)
+	if err != nil {
+		return err
+	}
+	chainMetricsInstance = instance
+	return nil
+}
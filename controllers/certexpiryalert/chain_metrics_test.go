@@ -0,0 +1,176 @@
+package certexpiryalert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+// newTestCertificate creates a self signed certificate with the common name cn, valid from notBefore to notAfter
+func newTestCertificate(t *testing.T, cn string, notBefore time.Time, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestValidateChainMetricLabels(t *testing.T) {
+	assert.NoError(t, ValidateChainMetricLabels(SupportedChainMetricLabels))
+	assert.NoError(t, ValidateChainMetricLabels([]string{ChainMetricLabelKey}))
+	// the alerting rules select the certificates by key
+	assert.Error(t, ValidateChainMetricLabels([]string{}))
+	assert.Error(t, ValidateChainMetricLabels([]string{ChainMetricLabelPosition, ChainMetricLabelSubjectCN}))
+	assert.Error(t, ValidateChainMetricLabels([]string{ChainMetricLabelKey, "fingerprint"}))
+}
+
+func TestChainMetrics(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	expiredRoot := now.Add(-time.Hour)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "test",
+		},
+		Type: util.TLSSecret,
+		Data: map[string][]byte{
+			util.Cert: []byte(newTestCertificate(t, "app.example.com", now, now.Add(90*24*time.Hour)) +
+				newTestCertificate(t, "intermediate", now, now.Add(365*24*time.Hour))),
+			util.CA: []byte(newTestCertificate(t, "old root", now.Add(-24*time.Hour), expiredRoot) +
+				newTestCertificate(t, "new root", now, now.Add(3650*24*time.Hour))),
+		},
+	}
+
+	m := newChainMetrics(ChainMetricLabels)
+	m.update(context.TODO(), secret)
+	assert.Equal(t, 4, testutil.CollectAndCount(m.expiryTime))
+	assert.Equal(t, float64(expiredRoot.Unix()), testutil.ToFloat64(m.expiryTime.WithLabelValues("app", "test", util.CA, "0", "old root", "old root")))
+	assert.Equal(t, float64(now.Unix()), testutil.ToFloat64(m.issueTime.WithLabelValues("app", "test", util.Cert, "1", "intermediate", "intermediate")))
+
+	// the series of the removed certificates are deleted
+	secret.Data[util.CA] = []byte(newTestCertificate(t, "new root", now, now.Add(3650*24*time.Hour)))
+	m.update(context.TODO(), secret)
+	assert.Equal(t, 3, testutil.CollectAndCount(m.expiryTime))
+	assert.Equal(t, 3, testutil.CollectAndCount(m.issueTime))
+
+	m.delete(secret)
+	assert.Equal(t, 0, testutil.CollectAndCount(m.expiryTime))
+	assert.Equal(t, 0, testutil.CollectAndCount(m.issueTime))
+	assert.Empty(t, m.series)
+}
+
+func TestChainMetricsCollision(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	expiredRoot := now.Add(-time.Hour)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bundle",
+			Namespace: "test",
+		},
+		Type: util.TLSSecret,
+		Data: map[string][]byte{
+			util.CA: []byte(newTestCertificate(t, "new root", now, now.Add(3650*24*time.Hour)) +
+				newTestCertificate(t, "old root", now.Add(-24*time.Hour), expiredRoot)),
+		},
+	}
+
+	// without the position and the common names the certificates of a key share the same series, which reports the earliest expiry
+	m := newChainMetrics([]string{ChainMetricLabelKey})
+	m.update(context.TODO(), secret)
+	assert.Equal(t, 1, testutil.CollectAndCount(m.expiryTime))
+	assert.Equal(t, float64(expiredRoot.Unix()), testutil.ToFloat64(m.expiryTime.WithLabelValues("bundle", "test", util.CA)))
+}
+
+func TestChainMetricsMatchAlertingRules(t *testing.T) {
+	data, err := ioutil.ReadFile("../../config/prometheus/rules.yaml")
+	assert.NoError(t, err)
+	rules := struct {
+		Spec struct {
+			Groups []struct {
+				Rules []struct {
+					Alert       string            `json:"alert"`
+					Expr        string            `json:"expr"`
+					Annotations map[string]string `json:"annotations"`
+				} `json:"rules"`
+			} `json:"groups"`
+		} `json:"spec"`
+	}{}
+	err = yaml.Unmarshal(data, &rules)
+	assert.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bundle",
+			Namespace: "test",
+		},
+		Type: util.TLSSecret,
+		Data: map[string][]byte{
+			util.CA: []byte(newTestCertificate(t, "old root", now.Add(-24*time.Hour), now.Add(-time.Hour))),
+		},
+	}
+	m := newChainMetrics(ChainMetricLabels)
+	m.update(context.TODO(), secret)
+	labelNames := append([]string{"name", "namespace"}, ChainMetricLabels...)
+	labelValues := m.series[types.NamespacedName{Name: "bundle", Namespace: "test"}][0]
+
+	found := false
+	selector := regexp.MustCompile(`certutils_certificate_chain_expiry_time\{([^}]*)\}`)
+	matcher := regexp.MustCompile(`(\w+)="([^"]*)"`)
+	for _, group := range rules.Spec.Groups {
+		for _, rule := range group.Rules {
+			if rule.Alert != "CertificateAuthorityBundleContainsExpiredCertificate" {
+				continue
+			}
+			found = true
+			// the series of the expired certificate of ca.crt with the default labels matches the selector of the alert
+			matchers := selector.FindStringSubmatch(rule.Expr)
+			assert.NotNil(t, matchers)
+			if matchers == nil {
+				continue
+			}
+			for _, match := range matcher.FindAllStringSubmatch(matchers[1], -1) {
+				assert.Contains(t, labelNames, match[1])
+				for i, labelName := range labelNames {
+					if labelName == match[1] {
+						assert.Equal(t, match[2], labelValues[i])
+					}
+				}
+			}
+			// the labels printed by the alert are added by default
+			for _, annotation := range rule.Annotations {
+				for _, label := range regexp.MustCompile(`\$labels\.(\w+)`).FindAllStringSubmatch(annotation, -1) {
+					assert.Contains(t, labelNames, label[1])
+				}
+			}
+		}
+	}
+	assert.True(t, found)
+}
@@ -0,0 +1,195 @@
+package certificateinfo
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// certInfoFormatAnnotation selects the format of the certificate info, text by default. With json or yaml a machine readable document
+// describing every certificate of the chain is generated alongside the text one.
+const certInfoFormatAnnotation = util.AnnotationBase + "/cert-info-format"
+
+const (
+	certInfoFormatText = "text"
+	certInfoFormatJSON = "json"
+	certInfoFormatYAML = "yaml"
+)
+
+// certificateDocument is the machine readable description of a certificate
+type certificateDocument struct {
+	Subject                string    `json:"subject"`
+	Issuer                 string    `json:"issuer"`
+	SerialNumber           string    `json:"serialNumber"`
+	DNSNames               []string  `json:"dnsNames,omitempty"`
+	IPAddresses            []string  `json:"ipAddresses,omitempty"`
+	URIs                   []string  `json:"uris,omitempty"`
+	EmailAddresses         []string  `json:"emailAddresses,omitempty"`
+	PublicKeyAlgorithm     string    `json:"publicKeyAlgorithm"`
+	PublicKeySize          int       `json:"publicKeySize,omitempty"`
+	SignatureAlgorithm     string    `json:"signatureAlgorithm"`
+	NotBefore              time.Time `json:"notBefore"`
+	NotAfter               time.Time `json:"notAfter"`
+	SHA1Fingerprint        string    `json:"sha1Fingerprint"`
+	SHA256Fingerprint      string    `json:"sha256Fingerprint"`
+	SPKIPin                string    `json:"spkiPin"`
+	IsCA                   bool      `json:"isCA"`
+	KeyUsages              []string  `json:"keyUsages,omitempty"`
+	ExtKeyUsages           []string  `json:"extKeyUsages,omitempty"`
+	AuthorityKeyID         string    `json:"authorityKeyId,omitempty"`
+	SubjectKeyID           string    `json:"subjectKeyId,omitempty"`
+	CRLDistributionPoints  []string  `json:"crlDistributionPoints,omitempty"`
+	OCSPServers            []string  `json:"ocspServers,omitempty"`
+	IssuingCertificateURLs []string  `json:"issuingCertificateURLs,omitempty"`
+}
+
+var keyUsages = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "DigitalSignature"},
+	{x509.KeyUsageContentCommitment, "ContentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "KeyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "DataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "KeyAgreement"},
+	{x509.KeyUsageCertSign, "CertSign"},
+	{x509.KeyUsageCRLSign, "CRLSign"},
+	{x509.KeyUsageEncipherOnly, "EncipherOnly"},
+	{x509.KeyUsageDecipherOnly, "DecipherOnly"},
+}
+
+var extKeyUsages = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:                            "Any",
+	x509.ExtKeyUsageServerAuth:                     "ServerAuth",
+	x509.ExtKeyUsageClientAuth:                     "ClientAuth",
+	x509.ExtKeyUsageCodeSigning:                    "CodeSigning",
+	x509.ExtKeyUsageEmailProtection:                "EmailProtection",
+	x509.ExtKeyUsageIPSECEndSystem:                 "IPSECEndSystem",
+	x509.ExtKeyUsageIPSECTunnel:                    "IPSECTunnel",
+	x509.ExtKeyUsageIPSECUser:                      "IPSECUser",
+	x509.ExtKeyUsageTimeStamping:                   "TimeStamping",
+	x509.ExtKeyUsageOCSPSigning:                    "OCSPSigning",
+	x509.ExtKeyUsageMicrosoftServerGatedCrypto:     "MicrosoftServerGatedCrypto",
+	x509.ExtKeyUsageNetscapeServerGatedCrypto:      "NetscapeServerGatedCrypto",
+	x509.ExtKeyUsageMicrosoftCommercialCodeSigning: "MicrosoftCommercialCodeSigning",
+	x509.ExtKeyUsageMicrosoftKernelCodeSigning:     "MicrosoftKernelCodeSigning",
+}
+
+// getCertInfoFormat returns the format of the certificate info of obj
+func getCertInfoFormat(obj client.Object) (string, error) {
+	format, ok := obj.GetAnnotations()[certInfoFormatAnnotation]
+	if !ok || format == "" {
+		return certInfoFormatText, nil
+	}
+	switch format {
+	case certInfoFormatText, certInfoFormatJSON, certInfoFormatYAML:
+		return format, nil
+	}
+	return "", errors.New("unsupported certificate info format " + format + ", it must be one of text, json, yaml")
+}
+
+// newCertificateDocument describes cert
+func newCertificateDocument(cert *x509.Certificate) certificateDocument {
+	sha1Fingerprint := sha1.Sum(cert.Raw)
+	sha256Fingerprint := sha256.Sum256(cert.Raw)
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	document := certificateDocument{
+		Subject:                cert.Subject.String(),
+		Issuer:                 cert.Issuer.String(),
+		SerialNumber:           formatHex(cert.SerialNumber.Bytes()),
+		DNSNames:               cert.DNSNames,
+		EmailAddresses:         cert.EmailAddresses,
+		PublicKeyAlgorithm:     cert.PublicKeyAlgorithm.String(),
+		PublicKeySize:          publicKeySize(cert.PublicKey),
+		SignatureAlgorithm:     cert.SignatureAlgorithm.String(),
+		NotBefore:              cert.NotBefore.UTC(),
+		NotAfter:               cert.NotAfter.UTC(),
+		SHA1Fingerprint:        formatHex(sha1Fingerprint[:]),
+		SHA256Fingerprint:      formatHex(sha256Fingerprint[:]),
+		SPKIPin:                base64.StdEncoding.EncodeToString(spki[:]),
+		IsCA:                   cert.IsCA,
+		AuthorityKeyID:         formatHex(cert.AuthorityKeyId),
+		SubjectKeyID:           formatHex(cert.SubjectKeyId),
+		CRLDistributionPoints:  cert.CRLDistributionPoints,
+		OCSPServers:            cert.OCSPServer,
+		IssuingCertificateURLs: cert.IssuingCertificateURL,
+	}
+	for _, ip := range cert.IPAddresses {
+		document.IPAddresses = append(document.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		document.URIs = append(document.URIs, uri.String())
+	}
+	for _, keyUsage := range keyUsages {
+		if cert.KeyUsage&keyUsage.usage != 0 {
+			document.KeyUsages = append(document.KeyUsages, keyUsage.name)
+		}
+	}
+	for _, extKeyUsage := range cert.ExtKeyUsage {
+		name, ok := extKeyUsages[extKeyUsage]
+		if !ok {
+			name = fmt.Sprintf("Unknown(%d)", extKeyUsage)
+		}
+		document.ExtKeyUsages = append(document.ExtKeyUsages, name)
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		document.ExtKeyUsages = append(document.ExtKeyUsages, oid.String())
+	}
+	return document
+}
+
+// generateCertDocument returns the description of every certificate of the pem chain, in format json or yaml
+func generateCertDocument(pemCert []byte, format string) ([]byte, error) {
+	certs, err := parseCertificates(pemCert)
+	if err != nil {
+		return nil, err
+	}
+	documents := []certificateDocument{}
+	for _, cert := range certs {
+		documents = append(documents, newCertificateDocument(cert))
+	}
+	switch format {
+	case certInfoFormatJSON:
+		return json.MarshalIndent(documents, "", "  ")
+	case certInfoFormatYAML:
+		return yaml.Marshal(documents)
+	default:
+		return nil, errors.New("unsupported certificate info format " + format)
+	}
+}
+
+// publicKeySize returns the size in bits of key, or 0 if the key type is unknown
+func publicKeySize(key interface{}) int {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	default:
+		return 0
+	}
+}
+
+// formatHex formats data as colon separated upper case hex bytes, as openssl does
+func formatHex(data []byte) string {
+	bytes := make([]string, len(data))
+	for i, b := range data {
+		bytes[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(bytes, ":")
+}
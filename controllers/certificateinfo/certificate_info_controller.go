@@ -1,10 +1,12 @@
 package certificateinfo
 
 import (
+	"bytes"
 	"context"
 	"crypto/x509"
 	"encoding/pem"
 	"reflect"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/grantae/certinfo"
@@ -18,12 +20,18 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/yaml"
 )
 
 const certInfoAnnotation = util.AnnotationBase + "/generate-cert-info"
 const certInfo = "tls.crt.info"
 const caInfo = "ca.crt.info"
 
+// certInfoOptionAnnotations are the annotations that change the info generated for a secret
+var certInfoOptionAnnotations = []string{certInfoFormatAnnotation, certInfoMetadataAnnotation, verifyChainAnnotation, verifyChainSystemRootsAnnotation,
+	verifyChainCABundleAnnotation, verifyChainHostnamesAnnotation, verifyChainUsagesAnnotation}
+
 // CertificateInfoReconciler reconciles a Namespace object
 type CertificateInfoReconciler struct {
 	outils.ReconcilerBase
@@ -58,7 +66,18 @@ func (r *CertificateInfoReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				return new
 			}
 			// otherwise we trigger if the annotation has changed
-			return old != new
+			if old != new {
+				return true
+			}
+			// or if one of the options of the info has changed
+			if new {
+				for _, annotation := range certInfoOptionAnnotations {
+					if e.ObjectOld.GetAnnotations()[annotation] != e.ObjectNew.GetAnnotations()[annotation] {
+						return true
+					}
+				}
+			}
+			return false
 		},
 		CreateFunc: func(e event.CreateEvent) bool {
 			secret, ok := e.Object.(*corev1.Secret)
@@ -73,17 +92,28 @@ func (r *CertificateInfoReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		},
 	}
 
+	err := util.IndexReferencingObjects(mgr.GetFieldIndexer(), &corev1.Secret{}, verifyChainCABundleAnnotation)
+	if err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Secret{
 			TypeMeta: v1.TypeMeta{
 				Kind: "Secret",
 			},
 		}, builder.WithPredicates(isAnnotatedSecret)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{
+			TypeMeta: v1.TypeMeta{
+				Kind: "ConfigMap",
+			},
+		}}, util.NewEnqueueRequestForReferecingObject(mgr.GetCache(), &corev1.SecretList{}, verifyChainCABundleAnnotation), builder.WithPredicates(util.IsCAConfigMapContentChanged)).
 		Complete(r)
 }
 
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
 
 func (r *CertificateInfoReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
@@ -102,25 +132,89 @@ func (r *CertificateInfoReconciler) Reconcile(context context.Context, req ctrl.
 		// Error reading the object - requeue the request.
 		return reconcile.Result{}, err
 	}
+	original := instance.DeepCopy()
+	// the expires-in-days-bucket label must be updated when the certificate crosses into the next bucket
+	requeueAfter := time.Duration(0)
 	value, _ := instance.GetAnnotations()[certInfoAnnotation]
+	// the documents in formats other than the requested one are removed
+	for _, format := range []string{certInfoFormatJSON, certInfoFormatYAML} {
+		delete(instance.Data, certInfo+"."+format)
+		delete(instance.Data, caInfo+"."+format)
+	}
 	if value == "true" {
-		if value, ok := instance.Data[util.Cert]; ok && len(value) != 0 {
-			instance.Data[certInfo] = []byte(r.generateCertInfo(instance.Data[util.Cert]))
+		format, err := getCertInfoFormat(instance)
+		if err != nil {
+			log.Error(err, "invalid certificate info format")
+			return r.ManageError(context, instance, err)
 		}
-		if value, ok := instance.Data[util.CA]; ok && len(value) != 0 {
-			instance.Data[caInfo] = []byte(r.generateCertInfo(instance.Data[util.CA]))
+		for source, info := range map[string]string{util.Cert: certInfo, util.CA: caInfo} {
+			value, ok := instance.Data[source]
+			if !ok || len(value) == 0 {
+				continue
+			}
+			instance.Data[info] = []byte(r.generateCertInfo(value))
+			if format == certInfoFormatText {
+				continue
+			}
+			document, err := generateCertDocument(value, format)
+			if err != nil {
+				log.Error(err, "unable to describe certificates", "key", source)
+				return r.ManageError(context, instance, err)
+			}
+			instance.Data[info+"."+format] = document
+		}
+		if value, ok := instance.Data[util.Cert]; ok && len(value) != 0 && instance.GetAnnotations()[certInfoMetadataAnnotation] == "true" {
+			requeueAfter, err = projectCertificateMetadata(instance, value, time.Now())
+			if err != nil {
+				log.Error(err, "unable to parse certificate", "key", util.Cert)
+				return r.ManageError(context, instance, err)
+			}
+		} else {
+			removeCertificateMetadata(instance)
+		}
+		if value, ok := instance.Data[util.Cert]; ok && len(value) != 0 && instance.GetAnnotations()[verifyChainAnnotation] == "true" {
+			options, err := getVerifyChainOptions(r.GetClient(), instance)
+			if util.IsCrossNamespaceCAInjectionDenied(err) {
+				// the chain cannot be verified until the ca bundle is shared with the namespace of the secret, which triggers a new reconcile
+				r.GetRecorder().Event(instance, "Warning", verificationCABundleDenied, "verification of "+util.Cert+" skipped: "+err.Error())
+				delete(instance.Data, certVerify)
+			} else if err != nil {
+				log.Error(err, "unable to read chain verification options")
+				return r.ManageError(context, instance, err)
+			} else {
+				verification := verifyChain(value, options, time.Now())
+				report, err := yaml.Marshal(verification)
+				if err != nil {
+					return r.ManageError(context, instance, err)
+				}
+				// the failure is reported only once, not at every reconcile
+				if !verification.Verified && !bytes.Equal(report, original.Data[certVerify]) {
+					r.GetRecorder().Event(instance, "Warning", verification.Reason, "verification of "+util.Cert+" failed: "+verification.Message)
+				}
+				instance.Data[certVerify] = report
+				if verification.nextChange > 0 && (requeueAfter == 0 || verification.nextChange < requeueAfter) {
+					requeueAfter = verification.nextChange
+				}
+			}
+		} else {
+			delete(instance.Data, certVerify)
 		}
 	} else {
 		delete(instance.Data, certInfo)
 		delete(instance.Data, caInfo)
+		delete(instance.Data, certVerify)
+		removeCertificateMetadata(instance)
 	}
 
-	err = r.GetClient().Update(context, instance)
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, instance, original)
 	if err != nil {
 		log.Error(err, "unable to update secrer", "secret", instance.GetName())
 		return r.ManageError(context, instance, err)
 	}
 
+	if requeueAfter > 0 {
+		return r.ManageSuccessWithRequeue(context, instance, requeueAfter)
+	}
 	return r.ManageSuccess(context, instance)
 }
 
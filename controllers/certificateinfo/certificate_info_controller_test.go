@@ -0,0 +1,238 @@
+package certificateinfo
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	outils "github.com/redhat-cop/operator-utils/pkg/util"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubectl/pkg/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+)
+
+func newTestCertificate(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, _ := url.Parse("spiffe://cluster.local/ns/test/sa/app")
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(4096),
+		Subject:               pkix.Name{CommonName: "app.example.com"},
+		NotBefore:             time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:              []string{"app.example.com"},
+		IPAddresses:           []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:                  []*url.URL{uri},
+		EmailAddresses:        []string{"admin@example.com"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		SubjectKeyId:          []byte{1, 2, 3},
+		AuthorityKeyId:        []byte{1, 2, 3},
+		OCSPServer:            []string{"http://ocsp.example.com"},
+		CRLDistributionPoints: []string{"http://crl.example.com/ca.crl"},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestCertificateInfoFormat(t *testing.T) {
+	cert := newTestCertificate(t)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "test",
+			Annotations: map[string]string{
+				certInfoAnnotation:       "true",
+				certInfoFormatAnnotation: certInfoFormatJSON,
+			},
+		},
+		Type: util.TLSSecret,
+		Data: map[string][]byte{
+			util.Cert: []byte(cert),
+		},
+	}
+	cl := fake.NewFakeClient([]runtime.Object{secret}...)
+	r := &CertificateInfoReconciler{
+		ReconcilerBase: outils.NewReconcilerBase(cl, scheme.Scheme, nil, record.NewFakeRecorder(10), nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("certificate_info_controller"),
+		controllerName: "certificate_info_controller",
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "app", Namespace: "test"}}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance := &corev1.Secret{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, instance.Data[certInfo])
+	documents := []certificateDocument{}
+	err = json.Unmarshal(instance.Data[certInfo+".json"], &documents)
+	assert.NoError(t, err)
+	assert.Len(t, documents, 1)
+	document := documents[0]
+	assert.Equal(t, "CN=app.example.com", document.Subject)
+	assert.Equal(t, "CN=app.example.com", document.Issuer)
+	assert.Equal(t, "10:00", document.SerialNumber)
+	assert.Equal(t, []string{"app.example.com"}, document.DNSNames)
+	assert.Equal(t, []string{"10.0.0.1"}, document.IPAddresses)
+	assert.Equal(t, []string{"spiffe://cluster.local/ns/test/sa/app"}, document.URIs)
+	assert.Equal(t, []string{"admin@example.com"}, document.EmailAddresses)
+	assert.Equal(t, "ECDSA", document.PublicKeyAlgorithm)
+	assert.Equal(t, 256, document.PublicKeySize)
+	assert.Equal(t, "ECDSA-SHA256", document.SignatureAlgorithm)
+	assert.True(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).Equal(document.NotAfter))
+	assert.Len(t, document.SHA1Fingerprint, 59)
+	assert.Len(t, document.SHA256Fingerprint, 95)
+	assert.NotEmpty(t, document.SPKIPin)
+	assert.Equal(t, []string{"DigitalSignature", "KeyEncipherment"}, document.KeyUsages)
+	assert.Equal(t, []string{"ServerAuth"}, document.ExtKeyUsages)
+	assert.Equal(t, "01:02:03", document.SubjectKeyID)
+	assert.Equal(t, "01:02:03", document.AuthorityKeyID)
+	assert.Equal(t, []string{"http://ocsp.example.com"}, document.OCSPServers)
+	assert.Equal(t, []string{"http://crl.example.com/ca.crl"}, document.CRLDistributionPoints)
+
+	// switching to yaml replaces the json document
+	instance.Annotations[certInfoFormatAnnotation] = certInfoFormatYAML
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &corev1.Secret{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.NotContains(t, instance.Data, certInfo+".json")
+	yamlDocuments := []certificateDocument{}
+	err = yaml.Unmarshal(instance.Data[certInfo+".yaml"], &yamlDocuments)
+	assert.NoError(t, err)
+	assert.Equal(t, documents, yamlDocuments)
+
+	// text only keeps the openssl like description
+	instance.Annotations[certInfoFormatAnnotation] = certInfoFormatText
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &corev1.Secret{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.NotContains(t, instance.Data, certInfo+".yaml")
+	assert.NotEmpty(t, instance.Data[certInfo])
+
+	// unsupported formats are reported
+	instance.Annotations[certInfoFormatAnnotation] = "xml"
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.Error(t, err)
+}
+
+func TestCertificateInfoMetadata(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "test",
+			Labels: map[string]string{
+				"app": "test",
+			},
+			Annotations: map[string]string{
+				certInfoAnnotation:         "true",
+				certInfoMetadataAnnotation: "true",
+			},
+		},
+		Type: util.TLSSecret,
+		Data: map[string][]byte{
+			util.Cert: []byte(newTestCertificate(t)),
+		},
+	}
+	cl := fake.NewFakeClient([]runtime.Object{secret}...)
+	r := &CertificateInfoReconciler{
+		ReconcilerBase: outils.NewReconcilerBase(cl, scheme.Scheme, nil, record.NewFakeRecorder(10), nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("certificate_info_controller"),
+		controllerName: "certificate_info_controller",
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "app", Namespace: "test"}}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance := &corev1.Secret{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, "2030-01-01T00:00:00Z", instance.Annotations[notAfterAnnotation])
+	assert.Equal(t, "app.example.com", instance.Annotations[subjectCNAnnotation])
+	assert.Equal(t, "app.example.com", instance.Annotations[issuerCNAnnotation])
+	assert.Len(t, instance.Annotations[sha256FingerprintAnnotation], 95)
+	assert.Equal(t, "app.example.com", instance.Labels[issuerLabel])
+	assert.NotEmpty(t, instance.Labels[expiresInDaysBucketLabel])
+	assert.Equal(t, "test", instance.Labels["app"])
+
+	// disabling the projection removes the metadata
+	delete(instance.Annotations, certInfoMetadataAnnotation)
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	result, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, reconcile.Result{}, result)
+	instance = &corev1.Secret{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.NotContains(t, instance.Annotations, notAfterAnnotation)
+	assert.NotContains(t, instance.Annotations, sha256FingerprintAnnotation)
+	assert.NotContains(t, instance.Labels, issuerLabel)
+	assert.NotContains(t, instance.Labels, expiresInDaysBucketLabel)
+	assert.Equal(t, "test", instance.Labels["app"])
+	assert.NotEmpty(t, instance.Data[certInfo])
+}
+
+func TestExpiresInDaysBucket(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		notAfter   time.Time
+		bucket     string
+		nextChange time.Duration
+	}{
+		{now.Add(-day), "expired", 0},
+		{now.Add(2 * day), "7", 2 * day},
+		{now.Add(10 * day), "30", 3 * day},
+		{now.Add(90 * day), "90", 60 * day},
+		{now.Add(100 * day), "365", 10 * day},
+		{now.Add(400 * day), "over-365", 35 * day},
+	}
+	for _, test := range tests {
+		bucket, nextChange := expiresInDaysBucket(test.notAfter, now)
+		assert.Equal(t, test.bucket, bucket)
+		assert.Equal(t, test.nextChange, nextChange)
+	}
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	assert.Equal(t, "Example-Intermediate-CA-G2", sanitizeLabelValue("Example Intermediate CA (G2)"))
+	assert.Equal(t, "CN-old.example.com-O-Example", sanitizeLabelValue("CN=old.example.com,O=Example"))
+	assert.Len(t, sanitizeLabelValue(strings.Repeat("a", 100)), 63)
+}
@@ -0,0 +1,111 @@
+package certificateinfo
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// certInfoMetadataAnnotation enables the projection of the main facts of the tls.crt certificate on the annotations and labels of the secret
+const certInfoMetadataAnnotation = util.AnnotationBase + "/cert-info-metadata"
+
+const (
+	notAfterAnnotation          = util.AnnotationBase + "/not-after"
+	subjectCNAnnotation         = util.AnnotationBase + "/subject-cn"
+	issuerCNAnnotation          = util.AnnotationBase + "/issuer-cn"
+	sha256FingerprintAnnotation = util.AnnotationBase + "/sha256-fingerprint"
+	expiresInDaysBucketLabel    = util.AnnotationBase + "/expires-in-days-bucket"
+	issuerLabel                 = util.AnnotationBase + "/issuer"
+)
+
+// expiresInDaysBuckets are the upper bounds, in days, of the expires-in-days-bucket label values
+var expiresInDaysBuckets = []int{7, 30, 90, 365}
+
+var invalidLabelCharacters = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// projectCertificateMetadata sets the annotations and labels describing the first certificate of pemCert on obj.
+// It returns after how long the expires-in-days-bucket label will change, 0 if it will not change anymore.
+func projectCertificateMetadata(obj client.Object, pemCert []byte, now time.Time) (time.Duration, error) {
+	p, _ := pem.Decode(pemCert)
+	if p == nil {
+		return 0, errors.New("unable to decode certificate")
+	}
+	cert, err := x509.ParseCertificate(p.Bytes)
+	if err != nil {
+		return 0, err
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[notAfterAnnotation] = cert.NotAfter.UTC().Format(time.RFC3339)
+	annotations[subjectCNAnnotation] = cert.Subject.CommonName
+	annotations[issuerCNAnnotation] = cert.Issuer.CommonName
+	annotations[sha256FingerprintAnnotation] = formatHex(fingerprint[:])
+	obj.SetAnnotations(annotations)
+
+	bucket, nextChange := expiresInDaysBucket(cert.NotAfter, now)
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[expiresInDaysBucketLabel] = bucket
+	issuer := cert.Issuer.CommonName
+	if issuer == "" {
+		issuer = cert.Issuer.String()
+	}
+	labels[issuerLabel] = sanitizeLabelValue(issuer)
+	obj.SetLabels(labels)
+	return nextChange, nil
+}
+
+// removeCertificateMetadata removes the annotations and labels set by projectCertificateMetadata from obj
+func removeCertificateMetadata(obj client.Object) {
+	annotations := obj.GetAnnotations()
+	for _, annotation := range []string{notAfterAnnotation, subjectCNAnnotation, issuerCNAnnotation, sha256FingerprintAnnotation} {
+		delete(annotations, annotation)
+	}
+	obj.SetAnnotations(annotations)
+	labels := obj.GetLabels()
+	for _, label := range []string{expiresInDaysBucketLabel, issuerLabel} {
+		delete(labels, label)
+	}
+	obj.SetLabels(labels)
+}
+
+// expiresInDaysBucket returns the bucket of the time left before notAfter, either expired, the smallest of expiresInDaysBuckets not lower
+// than the days left, or over- the largest bucket. It also returns after how long the bucket will change, 0 once expired.
+func expiresInDaysBucket(notAfter time.Time, now time.Time) (string, time.Duration) {
+	left := notAfter.Sub(now)
+	if left <= 0 {
+		return "expired", 0
+	}
+	lower := time.Duration(0)
+	for _, days := range expiresInDaysBuckets {
+		upper := time.Duration(days) * 24 * time.Hour
+		if left <= upper {
+			return strconv.Itoa(days), left - lower
+		}
+		lower = upper
+	}
+	return "over-" + strconv.Itoa(expiresInDaysBuckets[len(expiresInDaysBuckets)-1]), left - lower
+}
+
+// sanitizeLabelValue turns value into a valid label value, replacing the invalid characters with - and truncating it
+func sanitizeLabelValue(value string) string {
+	value = invalidLabelCharacters.ReplaceAllString(value, "-")
+	if len(value) > validation.LabelValueMaxLength {
+		value = value[:validation.LabelValueMaxLength]
+	}
+	return strings.Trim(value, "-_.")
+}
@@ -0,0 +1,283 @@
+package certificateinfo
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// verifyChainAnnotation enables the verification of the tls.crt chain, whose result is recorded in the tls.crt.verify entry
+const verifyChainAnnotation = util.AnnotationBase + "/verify-chain"
+
+// verifyChainSystemRootsAnnotation adds the system roots of the operator to the roots trusted when verifying the chain
+const verifyChainSystemRootsAnnotation = util.AnnotationBase + "/verify-chain-system-roots"
+
+// verifyChainCABundleAnnotation references, as {namespace}/{configmap-name}[/{key}], a configmap with more roots trusted when verifying the chain
+const verifyChainCABundleAnnotation = util.AnnotationBase + "/verify-chain-ca-bundle"
+
+// verifyChainHostnamesAnnotation lists, comma separated, the names the certificate must be valid for, by default its own DNS and IP SANs
+const verifyChainHostnamesAnnotation = util.AnnotationBase + "/verify-chain-hostnames"
+
+// verifyChainUsagesAnnotation lists, comma separated, the extended key usages the chain must be valid for, serverAuth by default
+const verifyChainUsagesAnnotation = util.AnnotationBase + "/verify-chain-usages"
+
+const certVerify = "tls.crt.verify"
+
+// reasons of the chain verification results
+const (
+	verificationVerified            = "Verified"
+	verificationUnknownAuthority    = "UnknownAuthority"
+	verificationMissingIntermediate = "MissingIntermediate"
+	verificationExpiredCertificate  = "ExpiredCertificate"
+	verificationExpiredIntermediate = "ExpiredIntermediate"
+	verificationNotYetValid         = "NotYetValid"
+	verificationNameMismatch        = "NameMismatch"
+	verificationIncompatibleUsage   = "IncompatibleUsage"
+	verificationInvalidCertificate  = "InvalidCertificate"
+)
+
+// verificationCABundleDenied is the reason of the warning events reporting that the ca bundle referenced by the verify-chain-ca-bundle
+// annotation cannot be read from the namespace of the secret, so the chain is not verified
+const verificationCABundleDenied = "ChainVerificationCABundleDenied"
+
+var verifyChainUsages = map[string]x509.ExtKeyUsage{
+	"serverAuth": x509.ExtKeyUsageServerAuth,
+	"clientAuth": x509.ExtKeyUsageClientAuth,
+}
+
+// chainVerification is the result of the verification of a certificate chain
+type chainVerification struct {
+	Verified  bool     `json:"verified"`
+	Reason    string   `json:"reason"`
+	Message   string   `json:"message,omitempty"`
+	Hostnames []string `json:"hostnames,omitempty"`
+	Usages    []string `json:"usages"`
+	// Chain lists the subjects of the verified chain, from the leaf to the root
+	Chain []string `json:"chain,omitempty"`
+	// nextChange is after how long the result may change because the validity of one of the certificates starts or ends
+	nextChange time.Duration
+}
+
+// verifyChainOptions are the options of the verification of the chain of a secret
+type verifyChainOptions struct {
+	roots     *x509.CertPool
+	rootCerts []*x509.Certificate
+	hostnames []string
+	usages    []string
+}
+
+// getVerifyChainOptions reads the options of the verification of the chain of secret from its annotations. The trusted roots are the
+// certificates of ca.crt, plus the system roots and the referenced ca bundle if requested.
+func getVerifyChainOptions(c client.Client, secret *corev1.Secret) (verifyChainOptions, error) {
+	options := verifyChainOptions{
+		roots:  x509.NewCertPool(),
+		usages: []string{"serverAuth"},
+	}
+	annotations := secret.GetAnnotations()
+	if annotations[verifyChainSystemRootsAnnotation] == "true" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			return options, err
+		}
+		options.roots = roots
+	}
+	bundles := [][]byte{secret.Data[util.CA]}
+	if reference, ok := annotations[verifyChainCABundleAnnotation]; ok && reference != "" {
+		configMapName, key, err := util.ParseConfigMapReference(reference)
+		if err != nil {
+			return options, err
+		}
+		err = util.CheckCrossNamespaceCAInjection(c, secret, &corev1.ConfigMap{}, configMapName)
+		if err != nil {
+			return options, err
+		}
+		bundle, err := util.GetConfigMapCA(c, configMapName, key)
+		if err != nil {
+			return options, err
+		}
+		bundles = append(bundles, bundle)
+	}
+	for _, bundle := range bundles {
+		certs, err := parseCertificates(bundle)
+		if err != nil {
+			return options, err
+		}
+		for _, cert := range certs {
+			options.roots.AddCert(cert)
+		}
+		options.rootCerts = append(options.rootCerts, certs...)
+	}
+	if hostnames, ok := annotations[verifyChainHostnamesAnnotation]; ok && hostnames != "" {
+		options.hostnames = splitList(hostnames)
+	}
+	if usages, ok := annotations[verifyChainUsagesAnnotation]; ok && usages != "" {
+		options.usages = splitList(usages)
+		for _, usage := range options.usages {
+			if _, ok := verifyChainUsages[usage]; !ok {
+				return options, errors.New("unsupported usage " + usage + ", it must be one of serverAuth, clientAuth")
+			}
+		}
+	}
+	return options, nil
+}
+
+// verifyChain verifies that the leaf certificate of tlsCrt chains, through the intermediates that follow it, to one of the roots of options.
+// The chain must be valid at now for each of the usages and the leaf for each of the hostnames of options.
+func verifyChain(tlsCrt []byte, options verifyChainOptions, now time.Time) chainVerification {
+	result := chainVerification{
+		Usages: options.usages,
+	}
+	certs, err := parseCertificates(tlsCrt)
+	if err == nil && len(certs) == 0 {
+		err = errors.New("no certificate found")
+	}
+	if err != nil {
+		result.Reason = verificationInvalidCertificate
+		result.Message = "unable to parse " + util.Cert + ": " + err.Error()
+		return result
+	}
+	result.nextChange = nextValidityChange(append(certs, options.rootCerts...), now)
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	result.Hostnames = options.hostnames
+	if len(result.Hostnames) == 0 {
+		result.Hostnames = defaultHostnames(leaf)
+	}
+	var chain []*x509.Certificate
+	for _, usage := range options.usages {
+		chains, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         options.roots,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+			KeyUsages:     []x509.ExtKeyUsage{verifyChainUsages[usage]},
+		})
+		if err != nil {
+			result.Reason, result.Message = describeVerificationError(err, certs, options.rootCerts, now)
+			return result
+		}
+		chain = chains[0]
+	}
+	for _, hostname := range result.Hostnames {
+		err := leaf.VerifyHostname(hostname)
+		if err != nil {
+			result.Reason = verificationNameMismatch
+			result.Message = err.Error()
+			return result
+		}
+	}
+	result.Verified = true
+	result.Reason = verificationVerified
+	for _, cert := range chain {
+		result.Chain = append(result.Chain, cert.Subject.String())
+	}
+	return result
+}
+
+// describeVerificationError returns the reason and the message of a failed verification of the chain certs
+func describeVerificationError(err error, certs []*x509.Certificate, rootCerts []*x509.Certificate, now time.Time) (string, string) {
+	leaf := certs[0]
+	switch e := err.(type) {
+	case x509.CertificateInvalidError:
+		switch e.Reason {
+		case x509.Expired:
+			if now.Before(e.Cert.NotBefore) {
+				return verificationNotYetValid, "certificate " + e.Cert.Subject.String() + " is not valid before " + e.Cert.NotBefore.UTC().Format(time.RFC3339)
+			}
+			if bytes.Equal(e.Cert.Raw, leaf.Raw) {
+				return verificationExpiredCertificate, "certificate " + e.Cert.Subject.String() + " expired on " + e.Cert.NotAfter.UTC().Format(time.RFC3339)
+			}
+			return verificationExpiredIntermediate, "certificate " + e.Cert.Subject.String() + " expired on " + e.Cert.NotAfter.UTC().Format(time.RFC3339)
+		case x509.IncompatibleUsage:
+			return verificationIncompatibleUsage, err.Error()
+		}
+		return verificationInvalidCertificate, err.Error()
+	case x509.UnknownAuthorityError:
+		// issuers that are not valid at now are discarded while building the chain, so they result in an unknown authority
+		for _, candidate := range append(certs[1:], rootCerts...) {
+			if (now.After(candidate.NotAfter) || now.Before(candidate.NotBefore)) && isIssuerOfAny(candidate, certs) {
+				return verificationExpiredIntermediate, "issuer certificate " + candidate.Subject.String() + " is valid from " +
+					candidate.NotBefore.UTC().Format(time.RFC3339) + " to " + candidate.NotAfter.UTC().Format(time.RFC3339)
+			}
+		}
+		if len(certs) == 1 {
+			return verificationMissingIntermediate, "issuer " + leaf.Issuer.String() + " of " + leaf.Subject.String() +
+				" is not a trusted root and " + util.Cert + " does not contain intermediate certificates: " + err.Error()
+		}
+		return verificationUnknownAuthority, err.Error()
+	case x509.HostnameError:
+		return verificationNameMismatch, err.Error()
+	}
+	return verificationInvalidCertificate, err.Error()
+}
+
+// isIssuerOfAny returns whether issuer has the subject of the issuer of one of certs
+func isIssuerOfAny(issuer *x509.Certificate, certs []*x509.Certificate) bool {
+	for _, cert := range certs {
+		if !bytes.Equal(issuer.Raw, cert.Raw) && bytes.Equal(issuer.RawSubject, cert.RawIssuer) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultHostnames returns the DNS and IP SANs of cert, or its common name if it has no SANs, which never verifies
+func defaultHostnames(cert *x509.Certificate) []string {
+	hostnames := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		hostnames = append(hostnames, ip.String())
+	}
+	if len(hostnames) == 0 && cert.Subject.CommonName != "" {
+		hostnames = append(hostnames, cert.Subject.CommonName)
+	}
+	return hostnames
+}
+
+// nextValidityChange returns after how long the validity of one of certs starts or ends, 0 if all of them are expired
+func nextValidityChange(certs []*x509.Certificate, now time.Time) time.Duration {
+	next := time.Duration(0)
+	for _, cert := range certs {
+		for _, change := range []time.Time{cert.NotBefore, cert.NotAfter} {
+			if after := change.Sub(now); after > 0 && (next == 0 || after < next) {
+				next = after
+			}
+		}
+	}
+	return next
+}
+
+// parseCertificates parses all the certificates of the pem bundle
+func parseCertificates(bundle []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for p, rest := pem.Decode(bundle); p != nil; p, rest = pem.Decode(rest) {
+		if p.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// splitList splits a comma separated list, ignoring spaces and empty items
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,279 @@
+package certificateinfo
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	outils "github.com/redhat-cop/operator-utils/pkg/util"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubectl/pkg/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+// newTestChainCertificate creates a certificate signed by issuer, or self signed if issuer is nil
+func newTestChainCertificate(t *testing.T, template *x509.Certificate, issuer *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	parent, parentKey := template, key
+	if issuer != nil {
+		parent, parentKey = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{
+		cert: cert,
+		key:  key,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+func newTestCACertificate(t *testing.T, commonName string, notBefore time.Time, notAfter time.Time, issuer *testCertificate) *testCertificate {
+	return newTestChainCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}, issuer)
+}
+
+func newTestLeafCertificate(t *testing.T, dnsName string, notBefore time.Time, notAfter time.Time, issuer *testCertificate) *testCertificate {
+	return newTestChainCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: dnsName},
+		DNSNames:    []string{dnsName},
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, issuer)
+}
+
+func TestVerifyChain(t *testing.T) {
+	now := time.Now()
+	root := newTestCACertificate(t, "root", now.Add(-time.Hour), now.Add(48*time.Hour), nil)
+	otherRoot := newTestCACertificate(t, "other-root", now.Add(-time.Hour), now.Add(48*time.Hour), nil)
+	intermediate := newTestCACertificate(t, "intermediate", now.Add(-time.Hour), now.Add(24*time.Hour), root)
+	expiredIntermediate := newTestCACertificate(t, "intermediate", now.Add(-2*time.Hour), now.Add(-time.Hour), root)
+	leaf := newTestLeafCertificate(t, "app.example.com", now.Add(-time.Hour), now.Add(12*time.Hour), intermediate)
+	expiredLeaf := newTestLeafCertificate(t, "app.example.com", now.Add(-2*time.Hour), now.Add(-time.Hour), intermediate)
+	leafOfExpired := newTestLeafCertificate(t, "app.example.com", now.Add(-time.Hour), now.Add(12*time.Hour), expiredIntermediate)
+
+	tests := []struct {
+		name      string
+		tlsCrt    string
+		caCrt     string
+		hostnames string
+		usages    string
+		reason    string
+	}{
+		{"verified", leaf.pem + intermediate.pem, root.pem, "", "", verificationVerified},
+		{"missing intermediate", leaf.pem, root.pem, "", "", verificationMissingIntermediate},
+		{"unknown authority", leaf.pem + intermediate.pem, otherRoot.pem, "", "", verificationUnknownAuthority},
+		{"expired leaf", expiredLeaf.pem + intermediate.pem, root.pem, "", "", verificationExpiredCertificate},
+		{"expired intermediate", leafOfExpired.pem + expiredIntermediate.pem, root.pem, "", "", verificationExpiredIntermediate},
+		{"name mismatch", leaf.pem + intermediate.pem, root.pem, "other.example.com", "", verificationNameMismatch},
+		{"incompatible usage", leaf.pem + intermediate.pem, root.pem, "", "serverAuth,clientAuth", verificationIncompatibleUsage},
+		{"invalid certificate", "not a certificate", root.pem, "", "", verificationInvalidCertificate},
+	}
+	for _, test := range tests {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "app",
+				Namespace: "test",
+				Annotations: map[string]string{
+					verifyChainHostnamesAnnotation: test.hostnames,
+					verifyChainUsagesAnnotation:    test.usages,
+				},
+			},
+			Data: map[string][]byte{
+				util.CA: []byte(test.caCrt),
+			},
+		}
+		options, err := getVerifyChainOptions(fake.NewFakeClient(), secret)
+		assert.NoError(t, err, test.name)
+		result := verifyChain([]byte(test.tlsCrt), options, now)
+		assert.Equal(t, test.reason, result.Reason, test.name+": "+result.Message)
+		assert.Equal(t, test.reason == verificationVerified, result.Verified, test.name)
+	}
+
+	options, err := getVerifyChainOptions(fake.NewFakeClient(), &corev1.Secret{Data: map[string][]byte{util.CA: []byte(root.pem)}})
+	assert.NoError(t, err)
+	result := verifyChain([]byte(leaf.pem+intermediate.pem), options, now)
+	assert.Equal(t, []string{"CN=app.example.com", "CN=intermediate", "CN=root"}, result.Chain)
+	assert.Equal(t, []string{"app.example.com"}, result.Hostnames)
+	// the result changes when the leaf expires
+	assert.Equal(t, 12*time.Hour, result.nextChange.Round(time.Hour))
+
+	_, err = getVerifyChainOptions(fake.NewFakeClient(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{verifyChainUsagesAnnotation: "codeSigning"},
+	}})
+	assert.Error(t, err)
+}
+
+func TestCertificateInfoVerifyChain(t *testing.T) {
+	now := time.Now()
+	root := newTestCACertificate(t, "root", now.Add(-time.Hour), now.Add(48*time.Hour), nil)
+	leaf := newTestLeafCertificate(t, "app.example.com", now.Add(-time.Hour), now.Add(12*time.Hour), root)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "test",
+			Annotations: map[string]string{
+				certInfoAnnotation:            "true",
+				verifyChainAnnotation:         "true",
+				verifyChainCABundleAnnotation: "test/cluster-bundle",
+			},
+		},
+		Type: util.TLSSecret,
+		Data: map[string][]byte{
+			util.Cert: []byte(leaf.pem),
+		},
+	}
+	bundle := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster-bundle",
+			Namespace: "test",
+		},
+		Data: map[string]string{
+			util.CABundle: root.pem,
+		},
+	}
+	cl := fake.NewFakeClient([]runtime.Object{secret, bundle}...)
+	recorder := record.NewFakeRecorder(10)
+	r := &CertificateInfoReconciler{
+		ReconcilerBase: outils.NewReconcilerBase(cl, scheme.Scheme, nil, recorder, nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("certificate_info_controller"),
+		controllerName: "certificate_info_controller",
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "app", Namespace: "test"}}
+
+	result, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 12*time.Hour, result.RequeueAfter.Round(time.Hour))
+	instance := &corev1.Secret{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	verification := chainVerification{}
+	err = yaml.Unmarshal(instance.Data[certVerify], &verification)
+	assert.NoError(t, err)
+	assert.True(t, verification.Verified)
+	assert.Equal(t, []string{"CN=app.example.com", "CN=root"}, verification.Chain)
+	assert.Empty(t, recorder.Events)
+
+	// a failure is recorded and reported with a warning event, only once
+	instance.Annotations[verifyChainHostnamesAnnotation] = "other.example.com"
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = r.Reconcile(context.TODO(), req)
+		assert.NoError(t, err)
+	}
+	instance = &corev1.Secret{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	verification = chainVerification{}
+	err = yaml.Unmarshal(instance.Data[certVerify], &verification)
+	assert.NoError(t, err)
+	assert.False(t, verification.Verified)
+	assert.Equal(t, verificationNameMismatch, verification.Reason)
+	assert.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Warning "+verificationNameMismatch)
+
+	// disabling the verification removes the report
+	delete(instance.Annotations, verifyChainAnnotation)
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance = &corev1.Secret{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.NotContains(t, instance.Data, certVerify)
+}
+
+func TestCertificateInfoVerifyChainCABundleDenied(t *testing.T) {
+	previous := util.CrossNamespaceCAInjectionPolicy
+	util.CrossNamespaceCAInjectionPolicy = util.CrossNamespaceCAInjectionShared
+	defer func() {
+		util.CrossNamespaceCAInjectionPolicy = previous
+	}()
+
+	now := time.Now()
+	root := newTestCACertificate(t, "root", now.Add(-time.Hour), now.Add(48*time.Hour), nil)
+	leaf := newTestLeafCertificate(t, "app.example.com", now.Add(-time.Hour), now.Add(12*time.Hour), root)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "test",
+			Annotations: map[string]string{
+				certInfoAnnotation:            "true",
+				verifyChainAnnotation:         "true",
+				verifyChainCABundleAnnotation: "trust/cluster-bundle",
+			},
+		},
+		Type: util.TLSSecret,
+		Data: map[string][]byte{
+			util.Cert: []byte(leaf.pem),
+		},
+	}
+	bundle := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster-bundle",
+			Namespace: "trust",
+		},
+		Data: map[string]string{
+			util.CABundle: root.pem,
+		},
+	}
+	cl := fake.NewFakeClient([]runtime.Object{secret, bundle}...)
+	recorder := record.NewFakeRecorder(10)
+	r := &CertificateInfoReconciler{
+		ReconcilerBase: outils.NewReconcilerBase(cl, scheme.Scheme, nil, recorder, nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("certificate_info_controller"),
+		controllerName: "certificate_info_controller",
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "app", Namespace: "test"}}
+
+	// the bundle is not shared with the namespace of the secret, the verification is skipped until it is
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	instance := &corev1.Secret{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.NotContains(t, instance.Data, certVerify)
+	assert.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Warning "+verificationCABundleDenied+" verification of tls.crt skipped")
+}
@@ -0,0 +1,263 @@
+package certificatepolicy
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	redhatcopv1alpha1 "github.com/redhat-cop/cert-utils-operator/api/v1alpha1"
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	outils "github.com/redhat-cop/operator-utils/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var policyViolation = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Subsystem: "certutils",
+		Name:      "certificate_policy_violation",
+		Help:      "1 for every rule of a certificate policy violated by the certificate of a tls secret",
+	},
+	[]string{"name", "namespace", "policy", "rule"},
+)
+
+func init() {
+	// Register custom metrics with the global prometheus registry
+	metrics.Registry.MustRegister(policyViolation)
+}
+
+// CertificatePolicyReconciler reconciles a CertificatePolicy object, validating it.
+// It also registers the controller that checks every tls secret against the policies.
+type CertificatePolicyReconciler struct {
+	outils.ReconcilerBase
+	Log            logr.Logger
+	controllerName string
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CertificatePolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.controllerName = "certificatepolicy_controller"
+
+	err := (&secretPolicyReconciler{
+		ReconcilerBase: r.ReconcilerBase,
+		Log:            r.Log.WithName("secret"),
+	}).SetupWithManager(mgr)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&redhatcopv1alpha1.CertificatePolicy{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
+
+// +kubebuilder:rbac:groups=redhatcop.redhat.io,resources=certificatepolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=redhatcop.redhat.io,resources=certificatepolicies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
+
+func (r *CertificatePolicyReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
+	log := r.Log.WithValues("certificatepolicy", req.NamespacedName)
+
+	// Fetch the CertificatePolicy instance
+	instance := &redhatcopv1alpha1.CertificatePolicy{}
+	err := r.GetClient().Get(context, req.NamespacedName, instance)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// The secrets are checked again by the secret controller, which watches the policies.
+			// Return and don't requeue
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	if instance.Spec.NamespaceSelector != nil {
+		_, err := metav1.LabelSelectorAsSelector(instance.Spec.NamespaceSelector)
+		if err != nil {
+			log.Error(err, "invalid namespace selector")
+			return r.ManageError(context, instance, err)
+		}
+	}
+
+	return r.ManageSuccess(context, instance)
+}
+
+// secretPolicyReconciler checks the certificates of the tls secrets against the certificate policies, reporting the violations with events and metrics
+type secretPolicyReconciler struct {
+	outils.ReconcilerBase
+	Log            logr.Logger
+	controllerName string
+	lock           sync.Mutex
+	// violations are the last violations reported for every secret, so that events are emitted and metrics removed only when they change
+	violations map[types.NamespacedName][]Violation
+}
+
+func (r *secretPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.controllerName = "certificatepolicy_secret_controller"
+	r.violations = map[types.NamespacedName][]Violation{}
+
+	isTLSSecret := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldSecret, ok := e.ObjectOld.(*corev1.Secret)
+			if !ok {
+				return false
+			}
+			newSecret, ok := e.ObjectNew.(*corev1.Secret)
+			if !ok {
+				return false
+			}
+			return (newSecret.Type == util.TLSSecret || oldSecret.Type == util.TLSSecret) &&
+				(newSecret.Type != oldSecret.Type || !reflect.DeepEqual(newSecret.Data[util.Cert], oldSecret.Data[util.Cert]))
+		},
+		CreateFunc: func(e event.CreateEvent) bool {
+			secret, ok := e.Object.(*corev1.Secret)
+			return ok && secret.Type == util.TLSSecret
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			secret, ok := e.Object.(*corev1.Secret)
+			return ok && secret.Type == util.TLSSecret
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+
+	isNamespaceLabelsChanged := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+		},
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(r.controllerName).
+		For(&corev1.Secret{
+			TypeMeta: metav1.TypeMeta{
+				Kind: "Secret",
+			},
+		}, builder.WithPredicates(isTLSSecret)).
+		Watches(&source.Kind{Type: &redhatcopv1alpha1.CertificatePolicy{}}, handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+			return r.findTLSSecrets("")
+		}), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &corev1.Namespace{
+			TypeMeta: metav1.TypeMeta{
+				Kind: "Namespace",
+			},
+		}}, handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+			return r.findTLSSecrets(obj.GetName())
+		}), builder.WithPredicates(isNamespaceLabelsChanged)).
+		Complete(r)
+}
+
+// findTLSSecrets returns a request for every tls secret of namespace, of all namespaces if namespace is empty
+func (r *secretPolicyReconciler) findTLSSecrets(namespace string) []reconcile.Request {
+	secrets := &corev1.SecretList{}
+	err := r.GetClient().List(context.TODO(), secrets, client.InNamespace(namespace))
+	if err != nil {
+		r.Log.Error(err, "unable to list secrets", "namespace", namespace)
+		return []reconcile.Request{}
+	}
+	requests := []reconcile.Request{}
+	for _, secret := range secrets.Items {
+		if secret.Type == util.TLSSecret {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}})
+		}
+	}
+	return requests
+}
+
+func (r *secretPolicyReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
+	log := r.Log.WithValues("secret", req.NamespacedName)
+
+	// Fetch the Secret instance
+	instance := &corev1.Secret{}
+	err := r.GetClient().Get(context, req.NamespacedName, instance)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// the secret has been deleted, its violations are not reported anymore
+			r.reportViolations(req.NamespacedName, nil, []Violation{})
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	violations, err := EvaluatePolicies(context, r.GetClient(), instance)
+	if err != nil {
+		log.Error(err, "unable to evaluate certificate policies")
+		return r.ManageError(context, instance, err)
+	}
+	r.reportViolations(req.NamespacedName, instance, violations)
+	return r.ManageSuccess(context, instance)
+}
+
+// reportViolations sets the violation metric of the secret name to violations and emits an event on secret for every new violation
+func (r *secretPolicyReconciler) reportViolations(name types.NamespacedName, secret *corev1.Secret, violations []Violation) {
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].String() < violations[j].String()
+	})
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	previous := map[string]bool{}
+	for _, violation := range r.violations[name] {
+		previous[violation.String()] = true
+	}
+	current := map[string]bool{}
+	messages := []string{}
+	for _, violation := range violations {
+		current[violation.String()] = true
+		policyViolation.WithLabelValues(name.Name, name.Namespace, violation.Policy, violation.Rule).Set(1)
+		if !previous[violation.String()] {
+			messages = append(messages, violation.String())
+		}
+	}
+	for _, violation := range r.violations[name] {
+		if !current[violation.String()] && !hasViolation(violations, violation.Policy, violation.Rule) {
+			policyViolation.DeleteLabelValues(name.Name, name.Namespace, violation.Policy, violation.Rule)
+		}
+	}
+	if len(violations) == 0 {
+		delete(r.violations, name)
+	} else {
+		r.violations[name] = violations
+	}
+	if secret != nil && len(messages) > 0 {
+		r.GetRecorder().Event(secret, "Warning", "CertificatePolicyViolation", "the certificate violates certificate policies: "+strings.Join(messages, "; "))
+	}
+}
+
+// hasViolation returns whether violations contains a violation of rule of policy
+func hasViolation(violations []Violation, policy string, rule string) bool {
+	for _, violation := range violations {
+		if violation.Policy == policy && violation.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
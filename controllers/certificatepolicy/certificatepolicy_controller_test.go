@@ -0,0 +1,152 @@
+package certificatepolicy
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	redhatcopv1alpha1 "github.com/redhat-cop/cert-utils-operator/api/v1alpha1"
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	outils "github.com/redhat-cop/operator-utils/pkg/util"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newTestObjects(t *testing.T) (*runtime.Scheme, *corev1.Namespace, *corev1.Secret, *redhatcopv1alpha1.CertificatePolicy) {
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(redhatcopv1alpha1.AddToScheme(s))
+	now := time.Now()
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "public",
+			Labels: map[string]string{
+				"exposure": "public",
+			},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "public",
+		},
+		Type: util.TLSSecret,
+		Data: map[string][]byte{
+			util.Cert: []byte(newTestCertificate(t, &x509.Certificate{
+				Subject:   pkix.Name{CommonName: "app.example.com"},
+				NotBefore: now,
+				NotAfter:  now.Add(90 * 24 * time.Hour),
+			}, 0)),
+		},
+	}
+	policy := &redhatcopv1alpha1.CertificatePolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "public",
+		},
+		Spec: redhatcopv1alpha1.CertificatePolicySpec{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"exposure": "public",
+				},
+			},
+			Rules:             strictRules,
+			EnforcementAction: redhatcopv1alpha1.DenyEnforcementAction,
+		},
+	}
+	return s, namespace, secret, policy
+}
+
+func TestSecretPolicyReconciler(t *testing.T) {
+	s, namespace, secret, policy := newTestObjects(t)
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(namespace, secret, policy).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &secretPolicyReconciler{
+		ReconcilerBase: outils.NewReconcilerBase(cl, s, nil, recorder, nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("certificatepolicy_secret_controller"),
+		violations:     map[types.NamespacedName][]Violation{},
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "app", Namespace: "public"}}
+
+	// the violation is reported by the metric and, only once, by an event
+	for i := 0; i < 2; i++ {
+		_, err := r.Reconcile(context.TODO(), req)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, float64(1), testutil.ToFloat64(policyViolation.WithLabelValues("app", "public", "public", RuleRequireSANs)))
+	assert.Equal(t, 1, testutil.CollectAndCount(policyViolation))
+	assert.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Warning CertificatePolicyViolation")
+
+	// the policy does not apply to namespaces that do not match its selector anymore
+	instance := &corev1.Namespace{}
+	err := cl.Get(context.TODO(), types.NamespacedName{Name: "public"}, instance)
+	assert.NoError(t, err)
+	instance.Labels = map[string]string{}
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, testutil.CollectAndCount(policyViolation))
+	assert.Empty(t, r.violations)
+}
+
+func TestSecretValidator(t *testing.T) {
+	s, namespace, secret, policy := newTestObjects(t)
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(namespace, policy).Build()
+	decoder, err := admission.NewDecoder(s)
+	assert.NoError(t, err)
+	validator := &SecretValidator{Client: cl}
+	err = validator.InjectDecoder(decoder)
+	assert.NoError(t, err)
+
+	request := func(secret *corev1.Secret) admission.Request {
+		raw, err := json.Marshal(secret)
+		assert.NoError(t, err)
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: secret.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+
+	response := validator.Handle(context.TODO(), request(secret))
+	assert.False(t, response.Allowed)
+	assert.Contains(t, string(response.Result.Reason), RuleRequireSANs)
+
+	// certificates that cannot be parsed are rejected, instead of failing the webhook which fails open
+	invalid := secret.DeepCopy()
+	invalid.Data[util.Cert] = []byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n")
+	response = validator.Handle(context.TODO(), request(invalid))
+	assert.False(t, response.Allowed)
+	assert.Contains(t, string(response.Result.Reason), RuleParsableCertificates)
+
+	// violations of policies that only warn are returned as warnings
+	policy.Spec.EnforcementAction = redhatcopv1alpha1.WarnEnforcementAction
+	err = cl.Update(context.TODO(), policy)
+	assert.NoError(t, err)
+	response = validator.Handle(context.TODO(), request(secret))
+	assert.True(t, response.Allowed)
+	assert.Len(t, response.Warnings, 1)
+
+	// other secrets are always allowed
+	opaque := secret.DeepCopy()
+	opaque.Type = corev1.SecretTypeOpaque
+	response = validator.Handle(context.TODO(), request(opaque))
+	assert.True(t, response.Allowed)
+	assert.Empty(t, response.Warnings)
+}
@@ -0,0 +1,136 @@
+package certificatepolicy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	redhatcopv1alpha1 "github.com/redhat-cop/cert-utils-operator/api/v1alpha1"
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// names of the rules of the certificate policies
+const (
+	RuleMinRSAKeySize        = "min-rsa-key-size"
+	RuleForbidSHA1Signatures = "forbid-sha1-signatures"
+	RuleMaxLifetime          = "max-lifetime"
+	RuleRequireSANs          = "require-sans"
+	// RuleParsableCertificates is violated by the certificates that cannot be parsed, whatever the rules of the policy
+	RuleParsableCertificates = "parsable-certificates"
+)
+
+// Violation is a rule of a policy not satisfied by a certificate
+type Violation struct {
+	Policy            string
+	EnforcementAction redhatcopv1alpha1.EnforcementAction
+	Rule              string
+	Message           string
+}
+
+func (v Violation) String() string {
+	return "policy " + v.Policy + ", rule " + v.Rule + ": " + v.Message
+}
+
+// Lint checks the certificates of the pem chain tlsCrt, the first one being the leaf, against rules. The returned violations have no policy.
+// A chain with a certificate that cannot be parsed cannot be checked, so it only violates RuleParsableCertificates.
+func Lint(tlsCrt []byte, rules redhatcopv1alpha1.CertificatePolicyRules) []Violation {
+	certs := []*x509.Certificate{}
+	for p, rest := pem.Decode(tlsCrt); p != nil; p, rest = pem.Decode(rest) {
+		if p.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return []Violation{{
+				Rule:    RuleParsableCertificates,
+				Message: fmt.Sprintf("certificate %d cannot be parsed: %s", len(certs)+1, err),
+			}}
+		}
+		certs = append(certs, cert)
+	}
+	violations := []Violation{}
+	if len(certs) == 0 {
+		return violations
+	}
+	for _, cert := range certs {
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok && rules.MinRSAKeySize > 0 && key.N.BitLen() < rules.MinRSAKeySize {
+			violations = append(violations, Violation{
+				Rule:    RuleMinRSAKeySize,
+				Message: fmt.Sprintf("certificate %s has a %d bits RSA key, the minimum is %d", cert.Subject, key.N.BitLen(), rules.MinRSAKeySize),
+			})
+		}
+		// the signature of self signed roots is not checked by clients
+		if rules.ForbidSHA1Signatures && isSHA1Signature(cert.SignatureAlgorithm) && !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			violations = append(violations, Violation{
+				Rule:    RuleForbidSHA1Signatures,
+				Message: fmt.Sprintf("certificate %s is signed with %s", cert.Subject, cert.SignatureAlgorithm),
+			})
+		}
+	}
+	leaf := certs[0]
+	if rules.MaxLifetime != nil && leaf.NotAfter.Sub(leaf.NotBefore) > rules.MaxLifetime.Duration {
+		violations = append(violations, Violation{
+			Rule:    RuleMaxLifetime,
+			Message: fmt.Sprintf("certificate %s is valid for %s, the maximum is %s", leaf.Subject, leaf.NotAfter.Sub(leaf.NotBefore), rules.MaxLifetime.Duration),
+		})
+	}
+	if rules.RequireSANs && len(leaf.DNSNames) == 0 && len(leaf.IPAddresses) == 0 && len(leaf.URIs) == 0 && len(leaf.EmailAddresses) == 0 {
+		violations = append(violations, Violation{
+			Rule:    RuleRequireSANs,
+			Message: fmt.Sprintf("certificate %s has no subject alternative names", leaf.Subject),
+		})
+	}
+	return violations
+}
+
+func isSHA1Signature(algorithm x509.SignatureAlgorithm) bool {
+	return algorithm == x509.SHA1WithRSA || algorithm == x509.ECDSAWithSHA1 || algorithm == x509.DSAWithSHA1
+}
+
+// EvaluatePolicies checks the tls.crt of secret against all the certificate policies that select its namespace.
+// It returns the violations of all the policies.
+func EvaluatePolicies(ctx context.Context, c client.Client, secret *corev1.Secret) ([]Violation, error) {
+	violations := []Violation{}
+	if secret.Type != util.TLSSecret || len(secret.Data[util.Cert]) == 0 {
+		return violations, nil
+	}
+	policies := &redhatcopv1alpha1.CertificatePolicyList{}
+	err := c.List(ctx, policies)
+	if err != nil {
+		return nil, err
+	}
+	var namespace *corev1.Namespace
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		if policy.Spec.NamespaceSelector != nil {
+			if namespace == nil {
+				namespace = &corev1.Namespace{}
+				err := c.Get(ctx, types.NamespacedName{Name: secret.Namespace}, namespace)
+				if err != nil {
+					return nil, err
+				}
+			}
+			selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector)
+			if err != nil {
+				return nil, err
+			}
+			if !selector.Matches(labels.Set(namespace.Labels)) {
+				continue
+			}
+		}
+		for _, violation := range Lint(secret.Data[util.Cert], policy.Spec.Rules) {
+			violation.Policy = policy.Name
+			violation.EnforcementAction = policy.Spec.EnforcementAction
+			violations = append(violations, violation)
+		}
+	}
+	return violations, nil
+}
@@ -0,0 +1,92 @@
+package certificatepolicy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	redhatcopv1alpha1 "github.com/redhat-cop/cert-utils-operator/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestCertificate creates a self signed certificate from template, with an RSA key of rsaBits or an ECDSA key if rsaBits is 0
+func newTestCertificate(t *testing.T, template *x509.Certificate, rsaBits int) string {
+	var public, private interface{}
+	if rsaBits > 0 {
+		key, err := rsa.GenerateKey(rand.Reader, rsaBits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public, private = &key.PublicKey, key
+	} else {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public, private = &key.PublicKey, key
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	der, err := x509.CreateCertificate(rand.Reader, template, template, public, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+var strictRules = redhatcopv1alpha1.CertificatePolicyRules{
+	MinRSAKeySize:        3072,
+	ForbidSHA1Signatures: true,
+	MaxLifetime:          &metav1.Duration{Duration: 398 * 24 * time.Hour},
+	RequireSANs:          true,
+}
+
+func TestLint(t *testing.T) {
+	now := time.Now()
+	compliant := newTestCertificate(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "app.example.com"},
+		DNSNames:  []string{"app.example.com"},
+		NotBefore: now,
+		NotAfter:  now.Add(90 * 24 * time.Hour),
+	}, 0)
+	violations := Lint([]byte(compliant), strictRules)
+	assert.Empty(t, violations)
+
+	nonCompliant := newTestCertificate(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "app.example.com"},
+		NotBefore: now,
+		NotAfter:  now.Add(2 * 365 * 24 * time.Hour),
+	}, 2048)
+	violations = Lint([]byte(nonCompliant), strictRules)
+	rules := []string{}
+	for _, violation := range violations {
+		rules = append(rules, violation.Rule)
+	}
+	assert.ElementsMatch(t, []string{RuleMinRSAKeySize, RuleMaxLifetime, RuleRequireSANs}, rules)
+
+	// unset rules are not checked
+	violations = Lint([]byte(nonCompliant), redhatcopv1alpha1.CertificatePolicyRules{})
+	assert.Empty(t, violations)
+
+	// certificates that cannot be parsed are violations of every policy, instead of errors that would let the webhook admit them
+	invalid := "-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"
+	for _, rules := range []redhatcopv1alpha1.CertificatePolicyRules{strictRules, {}} {
+		violations = Lint([]byte(compliant+invalid), rules)
+		assert.Len(t, violations, 1)
+		assert.Equal(t, RuleParsableCertificates, violations[0].Rule)
+		assert.Contains(t, violations[0].Message, "certificate 2 cannot be parsed")
+	}
+}
+
+func TestIsSHA1Signature(t *testing.T) {
+	assert.True(t, isSHA1Signature(x509.SHA1WithRSA))
+	assert.True(t, isSHA1Signature(x509.ECDSAWithSHA1))
+	assert.False(t, isSHA1Signature(x509.SHA256WithRSA))
+}
@@ -0,0 +1,58 @@
+package certificatepolicy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	redhatcopv1alpha1 "github.com/redhat-cop/cert-utils-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SecretValidatorPath is the path on which SecretValidator is served
+const SecretValidatorPath = "/validate-certificate-policy"
+
+// +kubebuilder:webhook:path=/validate-certificate-policy,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=secrets,verbs=create;update,versions=v1,name=certificatepolicy.redhatcop.redhat.io,admissionReviewVersions=v1
+
+// SecretValidator is a validating admission webhook that rejects the tls secrets violating certificate policies with the deny enforcement action.
+// Violations of the policies with the warn enforcement action are returned as warnings.
+type SecretValidator struct {
+	Client  client.Client
+	decoder *admission.Decoder
+}
+
+// Handle implements admission.Handler
+func (v *SecretValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	secret := &corev1.Secret{}
+	err := v.decoder.Decode(req, secret)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	// the namespace is not always set in the object of the request
+	secret.Namespace = req.Namespace
+	violations, err := EvaluatePolicies(ctx, v.Client, secret)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	denied := []string{}
+	warnings := []string{}
+	for _, violation := range violations {
+		if violation.EnforcementAction == redhatcopv1alpha1.DenyEnforcementAction {
+			denied = append(denied, violation.String())
+		} else {
+			warnings = append(warnings, violation.String())
+		}
+	}
+	if len(denied) > 0 {
+		return admission.Denied("the certificate violates certificate policies: " + strings.Join(denied, "; ")).WithWarnings(warnings...)
+	}
+	return admission.Allowed("").WithWarnings(warnings...)
+}
+
+// InjectDecoder implements admission.DecoderInjector
+func (v *SecretValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
@@ -1,15 +1,11 @@
 package configmaptokeystore
 
 import (
-	"bytes"
 	"context"
-	"encoding/pem"
 	"errors"
 	"reflect"
-	"strconv"
 
 	"github.com/go-logr/logr"
-	"github.com/pavlo-v-chernykh/keystore-go/v4"
 	"github.com/redhat-cop/cert-utils-operator/controllers/util"
 	outils "github.com/redhat-cop/operator-utils/pkg/util"
 	corev1 "k8s.io/api/core/v1"
@@ -20,6 +16,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const javaTrustStoreAnnotation = util.AnnotationBase + "/generate-java-truststore"
@@ -60,6 +57,11 @@ func (r *ConfigMapToKeystoreReconciler) SetupWithManager(mgr ctrl.Manager) error
 			if !reflect.DeepEqual(newConfigMap.Data[newSourceKey], oldConfigMap.Data[oldSourceKey]) {
 				return new
 			}
+			// if the password has changed we trigger is the annotation is there
+			if e.ObjectOld.GetAnnotations()[keystorepasswordAnnotation] != e.ObjectNew.GetAnnotations()[keystorepasswordAnnotation] ||
+				e.ObjectOld.GetAnnotations()[util.KeystorePasswordSecretAnnotation] != e.ObjectNew.GetAnnotations()[util.KeystorePasswordSecretAnnotation] {
+				return new
+			}
 			// otherwise we trigger if the annotation has changed
 			return old != new
 		},
@@ -75,11 +77,17 @@ func (r *ConfigMapToKeystoreReconciler) SetupWithManager(mgr ctrl.Manager) error
 				Kind: "ConfigMap",
 			},
 		}, builder.WithPredicates(isAnnotatedConfigMap)).
+		Watches(&source.Kind{Type: &corev1.Secret{
+			TypeMeta: v1.TypeMeta{
+				Kind: "Secret",
+			},
+		}}, util.NewEnqueueRequestForReferencingPasswordSecret(mgr.GetClient(), &corev1.ConfigMapList{}), builder.WithPredicates(util.IsPasswordSecretContentChanged)).
 		Complete(r)
 }
 
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
 
 func (r *ConfigMapToKeystoreReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
@@ -98,11 +106,17 @@ func (r *ConfigMapToKeystoreReconciler) Reconcile(context context.Context, req c
 		// Error reading the object - requeue the request.
 		return reconcile.Result{}, err
 	}
+	original := instance.DeepCopy()
 	value, _ := instance.GetAnnotations()[javaTrustStoreAnnotation]
 	if value == "true" {
 		sourceKey := getSourceKey(instance.GetAnnotations())
 		if value, ok := instance.Data[sourceKey]; ok && len(value) != 0 {
-			trustStore, err := r.getTrustStoreFromConfigMap(instance, sourceKey)
+			password, err := r.getPassword(instance)
+			if err != nil {
+				log.Error(err, "unable to retrieve keystore password", "configmap", instance.Namespace+"/"+instance.Name)
+				return r.ManageError(context, instance, err)
+			}
+			trustStore, err := r.getTrustStoreFromConfigMap(instance, sourceKey, password)
 			if err != nil {
 				log.Error(err, "unable to create truststore from configmap", "configmap", instance.Namespace+"/"+instance.Name)
 				return reconcile.Result{}, err
@@ -110,13 +124,15 @@ func (r *ConfigMapToKeystoreReconciler) Reconcile(context context.Context, req c
 			if instance.BinaryData == nil {
 				instance.BinaryData = make(map[string][]byte)
 			}
-			instance.BinaryData[truststoreName] = trustStore
+			if current, ok := instance.BinaryData[truststoreName]; !ok || !util.IsEncodedCABundleEqual(current, trustStore, util.CAFormatJKS, password) {
+				instance.BinaryData[truststoreName] = trustStore
+			}
 		}
 	} else {
 		delete(instance.Data, truststoreName)
 	}
 
-	err = r.GetClient().Update(context, instance)
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, instance, original)
 	if err != nil {
 		log.Error(err, "unable to update configmap", "configmap", instance.GetName())
 		return r.ManageError(context, instance, err)
@@ -125,44 +141,29 @@ func (r *ConfigMapToKeystoreReconciler) Reconcile(context context.Context, req c
 	return r.ManageSuccess(context, instance)
 }
 
-func (r *ConfigMapToKeystoreReconciler) getTrustStoreFromConfigMap(configMap *corev1.ConfigMap, sourceKey string) ([]byte, error) {
-	keyStore := keystore.New(
-		keystore.WithOrderedAliases(),
-	)
+// getTrustStoreFromConfigMap returns a java truststore with the certificates of the sourceKey of the configmap.
+// The truststore only depends on the certificates and on the password, so it does not change unless they change.
+func (r *ConfigMapToKeystoreReconciler) getTrustStoreFromConfigMap(configMap *corev1.ConfigMap, sourceKey string, password string) ([]byte, error) {
 	ca, ok := configMap.Data[sourceKey]
 	if !ok {
 		return nil, errors.New("ca bundle key not found: " + sourceKey)
 	}
-	i := 0
-
-	for p, rest := pem.Decode([]byte(ca)); p != nil; p, rest = pem.Decode(rest) {
-		keyStore.SetTrustedCertificateEntry(
-			"alias"+strconv.Itoa(i),
-			keystore.TrustedCertificateEntry{
-				CreationTime: configMap.GetCreationTimestamp().Time,
-				Certificate: keystore.Certificate{
-					Type:    "X.509",
-					Content: p.Bytes,
-				},
-			},
-		)
-		i++
-	}
-
-	buffer := bytes.Buffer{}
-	err := keyStore.Store(&buffer, []byte(getPassword(configMap)))
+	trustStore, err := util.NewJKSTrustStore([]byte(ca), password)
 	if err != nil {
-		r.Log.Error(err, "unable to encode keystore", "keystore", keyStore)
+		r.Log.Error(err, "unable to encode truststore", "configmap", configMap.Namespace+"/"+configMap.Name)
 		return nil, err
 	}
-	return buffer.Bytes(), nil
+	return trustStore, nil
 }
 
-func getPassword(configMap *corev1.ConfigMap) string {
+func (r *ConfigMapToKeystoreReconciler) getPassword(configMap *corev1.ConfigMap) (string, error) {
+	if _, ok := configMap.GetAnnotations()[util.KeystorePasswordSecretAnnotation]; ok {
+		return util.GetKeystorePasswordFromSecret(r.GetClient(), configMap)
+	}
 	if pwd, ok := configMap.GetAnnotations()[keystorepasswordAnnotation]; ok && pwd != "" {
-		return pwd
+		return pwd, nil
 	}
-	return defaultpassword
+	return defaultpassword, nil
 }
 
 func getSourceKey(annotations map[string]string) string {
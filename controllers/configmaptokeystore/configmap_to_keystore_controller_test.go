@@ -3,11 +3,13 @@ package configmaptokeystore
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
 	"encoding/pem"
 	"io/ioutil"
 	"testing"
 
 	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	certutil "github.com/redhat-cop/cert-utils-operator/controllers/util"
 	"github.com/redhat-cop/operator-utils/pkg/util"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
@@ -124,7 +126,7 @@ pPVWQxaZLPSkVrQ0uGE3ycJYgBugl6H8WY3pEfbRD0tVNEYqi4Y7
 	assert.Equal(t, 1, len(ks.Aliases()))
 
 	// make sure cert data match
-	tce, err := ks.GetTrustedCertificateEntry("alias0")
+	tce, err := ks.GetTrustedCertificateEntry(ks.Aliases()[0])
 	decodePem := pem.EncodeToMemory(&pem.Block{
 		Type:  "CERTIFICATE",
 		Bytes: tce.Certificate.Content,
@@ -132,6 +134,10 @@ pPVWQxaZLPSkVrQ0uGE3ycJYgBugl6H8WY3pEfbRD0tVNEYqi4Y7
 	if err != nil {
 		t.Error(err)
 	}
+	// the alias is the fingerprint of the certificate
+	cert, err := x509.ParseCertificate(tce.Certificate.Content)
+	assert.Nil(t, err, "error parsing truststore certificate")
+	assert.Equal(t, certutil.TrustStoreAlias(cert), ks.Aliases()[0])
 
 	assert.Equal(t, []byte(configMap.Data["ca-bundle.crt"]), decodePem)
 }
@@ -237,7 +243,7 @@ pPVWQxaZLPSkVrQ0uGE3ycJYgBugl6H8WY3pEfbRD0tVNEYqi4Y7
 	assert.Equal(t, 1, len(ks.Aliases()))
 
 	// make sure cert data match
-	tce, err := ks.GetTrustedCertificateEntry("alias0")
+	tce, err := ks.GetTrustedCertificateEntry(ks.Aliases()[0])
 	decodePem := pem.EncodeToMemory(&pem.Block{
 		Type:  "CERTIFICATE",
 		Bytes: tce.Certificate.Content,
@@ -245,6 +251,10 @@ pPVWQxaZLPSkVrQ0uGE3ycJYgBugl6H8WY3pEfbRD0tVNEYqi4Y7
 	if err != nil {
 		t.Error(err)
 	}
+	// the alias is the fingerprint of the certificate
+	cert, err := x509.ParseCertificate(tce.Certificate.Content)
+	assert.Nil(t, err, "error parsing truststore certificate")
+	assert.Equal(t, certutil.TrustStoreAlias(cert), ks.Aliases()[0])
 
 	assert.Equal(t, []byte(configMap.Data["my-custom-key"]), decodePem)
 }
@@ -317,4 +327,81 @@ func TestConfigmapControllerCreateFromConfigMapBinaryEquals(t *testing.T) {
 	cl.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, outConfigMap2)
 
 	assert.Equal(t, outConfigMap.BinaryData["truststore.jks"], outConfigMap2.BinaryData["truststore.jks"])
+	// the second reconcile did not write the configmap
+	assert.Equal(t, outConfigMap.GetResourceVersion(), outConfigMap2.GetResourceVersion())
+}
+
+func TestConfigmapControllerPasswordFromSecret(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	caBundle, err := ioutil.ReadFile("testdata/ca-bundle.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A configmap whose truststore password is stored in a secret
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				"cert-utils-operator.redhat-cop.io/generate-java-truststore":      "true",
+				"cert-utils-operator.redhat-cop.io/java-keystore-password-secret": "keystore-password/password",
+			},
+		},
+		Data: map[string]string{
+			"ca-bundle.crt": string(caBundle),
+		},
+	}
+	passwordSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "keystore-password",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"password": []byte("secret-password"),
+		},
+	}
+
+	objs := []runtime.Object{configMap, passwordSecret}
+
+	cl := fake.NewFakeClient(objs...)
+
+	fakeRecorder := record.NewFakeRecorder(3)
+
+	reconcileBase := util.NewReconcilerBase(cl, scheme.Scheme, nil, fakeRecorder, nil)
+	r := &ConfigMapToKeystoreReconciler{
+		ReconcilerBase: reconcileBase,
+		Log:            ctrl.Log.WithName("controllers").WithName("configmap_to_keystore_controller"),
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	_, err = r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+
+	outConfigMap := &corev1.ConfigMap{}
+	cl.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, outConfigMap)
+
+	// validate truststore.jks is protected by the password stored in the secret
+	ks := keystore.New()
+	err = ks.Load(
+		bytes.NewBuffer(outConfigMap.BinaryData["truststore.jks"]),
+		[]byte("secret-password"),
+	)
+	assert.Nil(t, err, "error reading generated truststore")
+
+	// a missing password secret must fail the reconcile
+	cl.Delete(context.TODO(), passwordSecret)
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NotNil(t, err)
 }
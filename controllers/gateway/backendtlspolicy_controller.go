@@ -0,0 +1,216 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	outils "github.com/redhat-cop/operator-utils/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// BackendTLSPolicyReconciler reconciles a BackendTLSPolicy object
+type BackendTLSPolicyReconciler struct {
+	outils.ReconcilerBase
+	Log            logr.Logger
+	controllerName string
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *BackendTLSPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.controllerName = "backendtlspolicy_ca_controller"
+
+	err := indexReferencingGatewayObjects(mgr, BackendTLSPolicyGVK, destCAAnnotation)
+	if err != nil {
+		return err
+	}
+
+	// this will filter policies that have the annotation and on update only if the annotation or the validation section are changed.
+	isAnnotatedPolicy := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldSecret, _ := e.ObjectOld.GetAnnotations()[destCAAnnotation]
+			newSecret, _ := e.ObjectNew.GetAnnotations()[destCAAnnotation]
+			if oldSecret != newSecret {
+				return true
+			}
+			if newSecret == "" {
+				return false
+			}
+			oldPolicy, ok := e.ObjectOld.(*unstructured.Unstructured)
+			if !ok {
+				return false
+			}
+			newPolicy, ok := e.ObjectNew.(*unstructured.Unstructured)
+			if !ok {
+				return false
+			}
+			oldRefs, _, _ := unstructured.NestedSlice(oldPolicy.Object, "spec", "validation", "caCertificateRefs")
+			newRefs, _, _ := unstructured.NestedSlice(newPolicy.Object, "spec", "validation", "caCertificateRefs")
+			return !reflect.DeepEqual(oldRefs, newRefs)
+		},
+		CreateFunc: func(e event.CreateEvent) bool {
+			_, ok := e.Object.GetAnnotations()[destCAAnnotation]
+			return ok
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+
+	policy := &unstructured.Unstructured{}
+	policy.SetGroupVersionKind(BackendTLSPolicyGVK)
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(policy, builder.WithPredicates(isAnnotatedPolicy)).
+		Owns(&corev1.ConfigMap{
+			TypeMeta: v1.TypeMeta{
+				Kind: "ConfigMap",
+			},
+		}).
+		Watches(&source.Kind{Type: &corev1.Secret{
+			TypeMeta: v1.TypeMeta{
+				Kind: "Secret",
+			},
+		}}, &enqueueRequestForReferecingGatewayObjects{
+			Reader:     mgr.GetCache(),
+			log:        ctrl.Log.WithName("enqueueRequestForReferecingBackendTLSPolicies"),
+			gvk:        BackendTLSPolicyGVK,
+			annotation: destCAAnnotation,
+		}, builder.WithPredicates(util.IsCAContentChanged)).
+		Complete(r)
+}
+
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=backendtlspolicies,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
+
+func (r *BackendTLSPolicyReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
+	log := r.Log.WithValues("backendtlspolicy-ca", req.NamespacedName)
+
+	// Fetch the BackendTLSPolicy instance
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(BackendTLSPolicyGVK)
+	err := r.GetClient().Get(context, req.NamespacedName, instance)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	secretReference, ok := instance.GetAnnotations()[destCAAnnotation]
+	if !ok || secretReference == "" {
+		return reconcile.Result{}, nil
+	}
+	secretName := util.GetNamespacedName(secretReference, instance.GetNamespace())
+
+	secret := &corev1.Secret{}
+	err = r.GetClient().Get(context, secretName, secret)
+	if err != nil {
+		log.Error(err, "unable to find referenced ca secret", "secret", secretName)
+		return r.ManageError(context, instance, err)
+	}
+	err = checkSecretShared(r.GetClient(), secret, instance)
+	if err != nil {
+		if util.RecordSecretNotShared(r.GetRecorder(), instance, err) {
+			// the policy is reconciled again when the secret is shared with its namespace
+			return reconcile.Result{}, nil
+		}
+		log.Error(err, "unable to verify whether secret is shared", "secret", secretName)
+		return r.ManageError(context, instance, err)
+	}
+
+	// BackendTLSPolicy can only reference CA certificates stored in configmaps, so we copy the ca bundle in a configmap owned by the policy
+	configMap := newCAConfigMap(instance, secret)
+
+	// a configmap with the same name that was not created for this policy belongs to someone else and must not be overwritten
+	existing := &corev1.ConfigMap{}
+	err = r.GetClient().Get(context, types.NamespacedName{
+		Namespace: configMap.GetNamespace(),
+		Name:      configMap.GetName(),
+	}, existing)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "unable to read ca configmap", "configmap", configMap.GetName())
+		return r.ManageError(context, instance, err)
+	}
+	if err == nil && !v1.IsControlledBy(existing, instance) {
+		log.Info("ca configmap is not owned by the policy, skipping", "configmap", configMap.GetName())
+		r.GetRecorder().Event(instance, "Warning", "ConfigMapNotOwned", fmt.Sprintf("configmap %s already exists and is not owned by this policy, the ca bundle was not injected", configMap.GetName()))
+		return reconcile.Result{}, nil
+	}
+
+	err = r.CreateOrUpdateResource(context, instance, instance.GetNamespace(), configMap)
+	if err != nil {
+		log.Error(err, "unable to create or update ca configmap", "configmap", configMap.GetName())
+		return r.ManageError(context, instance, err)
+	}
+
+	original := instance.DeepCopy()
+	_, err = populateBackendTLSPolicyCA(instance, configMap.GetName())
+	if err != nil {
+		log.Error(err, "unable to populate ca certificate refs", "backendtlspolicy", req.NamespacedName)
+		return r.ManageError(context, instance, err)
+	}
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, instance, original)
+	if err != nil {
+		log.Error(err, "unable to update backendtlspolicy", "backendtlspolicy", req.NamespacedName)
+		return r.ManageError(context, instance, err)
+	}
+
+	return r.ManageSuccess(context, instance)
+}
+
+func newCAConfigMap(policy *unstructured.Unstructured, secret *corev1.Secret) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta: v1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name:      policy.GetName() + "-ca-bundle",
+			Namespace: policy.GetNamespace(),
+		},
+		Data: map[string]string{
+			util.CA: string(secret.Data[util.CA]),
+		},
+	}
+}
+
+// populateBackendTLSPolicyCA makes the policy validate the backend certificates with the ca bundle stored in the given configmap
+func populateBackendTLSPolicyCA(policy *unstructured.Unstructured, configMapName string) (bool, error) {
+	caCertificateRefs := []interface{}{
+		map[string]interface{}{
+			"group": "",
+			"kind":  "ConfigMap",
+			"name":  configMapName,
+		},
+	}
+	currentRefs, _, err := unstructured.NestedSlice(policy.Object, "spec", "validation", "caCertificateRefs")
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(currentRefs, caCertificateRefs) {
+		return false, nil
+	}
+	return true, unstructured.SetNestedSlice(policy.Object, caCertificateRefs, "spec", "validation", "caCertificateRefs")
+}
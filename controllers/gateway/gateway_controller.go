@@ -0,0 +1,499 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	outils "github.com/redhat-cop/operator-utils/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const certAnnotation = util.AnnotationBase + "/certs-from-secret"
+const destCAAnnotation = util.AnnotationBase + "/destinationCA-from-secret"
+const referenceGrantManagedLabel = util.AnnotationBase + "/managed"
+const referenceGrantGatewayAnnotation = util.AnnotationBase + "/gateway"
+
+// referenceGrantNameHashLength is the number of hexadecimal digits of the hash that ends the name of the reference grants
+const referenceGrantNameHashLength = 8
+
+// referenceGrantNamespaceAnnotation records on a gateway the namespace of the reference grant created for it
+const referenceGrantNamespaceAnnotation = util.AnnotationBase + "/reference-grant-namespace"
+
+var GatewayGVK = schema.GroupVersionKind{
+	Group:   "gateway.networking.k8s.io",
+	Version: "v1",
+	Kind:    "Gateway",
+}
+
+var ReferenceGrantGVK = schema.GroupVersionKind{
+	Group:   "gateway.networking.k8s.io",
+	Version: "v1beta1",
+	Kind:    "ReferenceGrant",
+}
+
+var BackendTLSPolicyGVK = schema.GroupVersionKind{
+	Group:   "gateway.networking.k8s.io",
+	Version: "v1alpha3",
+	Kind:    "BackendTLSPolicy",
+}
+
+// GatewayCertificateReconciler reconciles a Gateway object
+type GatewayCertificateReconciler struct {
+	outils.ReconcilerBase
+	Log            logr.Logger
+	controllerName string
+	// cache is the manager cache, the reference grants are listed from it
+	cache client.Reader
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *GatewayCertificateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.controllerName = "gateway_certificate_controller"
+	r.cache = mgr.GetCache()
+
+	err := indexReferencingGatewayObjects(mgr, GatewayGVK, certAnnotation)
+	if err != nil {
+		return err
+	}
+
+	// this will filter gateways that have the annotation and on update only if the annotation or the listeners are changed.
+	// deletions are let through so that the reference grants created for the gateway can be removed.
+	isAnnotatedGateway := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldSecret, _ := e.ObjectOld.GetAnnotations()[certAnnotation]
+			newSecret, _ := e.ObjectNew.GetAnnotations()[certAnnotation]
+			if oldSecret != newSecret {
+				return true
+			}
+			if newSecret == "" {
+				return false
+			}
+			oldGateway, ok := e.ObjectOld.(*unstructured.Unstructured)
+			if !ok {
+				return false
+			}
+			newGateway, ok := e.ObjectNew.(*unstructured.Unstructured)
+			if !ok {
+				return false
+			}
+			oldListeners, _, _ := unstructured.NestedSlice(oldGateway.Object, "spec", "listeners")
+			newListeners, _, _ := unstructured.NestedSlice(newGateway.Object, "spec", "listeners")
+			return !reflect.DeepEqual(oldListeners, newListeners)
+		},
+		CreateFunc: func(e event.CreateEvent) bool {
+			_, ok := e.Object.GetAnnotations()[certAnnotation]
+			return ok
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			_, ok := e.Object.GetAnnotations()[certAnnotation]
+			return ok
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+
+	// gateway implementations read the certificates from the secret, so we only need to react to secrets that appear after the gateway
+	isTLSSecretCreatedOrShared := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return util.IsSecretSharingChanged(e.ObjectOld, e.ObjectNew)
+		},
+		CreateFunc: func(e event.CreateEvent) bool {
+			secret, ok := e.Object.(*corev1.Secret)
+			if !ok {
+				return false
+			}
+			return secret.Type == util.TLSSecret
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+
+	gateway := &unstructured.Unstructured{}
+	gateway.SetGroupVersionKind(GatewayGVK)
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(gateway, builder.WithPredicates(isAnnotatedGateway)).
+		Watches(&source.Kind{Type: &corev1.Secret{
+			TypeMeta: v1.TypeMeta{
+				Kind: "Secret",
+			},
+		}}, &enqueueRequestForReferecingGatewayObjects{
+			Reader:     mgr.GetCache(),
+			log:        ctrl.Log.WithName("enqueueRequestForReferecingGateways"),
+			gvk:        GatewayGVK,
+			annotation: certAnnotation,
+		}, builder.WithPredicates(isTLSSecretCreatedOrShared)).
+		Complete(r)
+}
+
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=referencegrants,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
+
+func (r *GatewayCertificateReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
+	log := r.Log.WithValues("gateway-certificate", req.NamespacedName)
+
+	// Fetch the Gateway instance
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(GatewayGVK)
+	err := r.GetClient().Get(context, req.NamespacedName, instance)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Reference grants live in other namespaces and cannot be garbage collected, so we remove them here.
+			// The namespace recorded on the gateway is lost with it, so all the namespaces are searched.
+			return reconcile.Result{}, r.deleteReferenceGrants(context, req.NamespacedName, []string{v1.NamespaceAll}, nil)
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	referenceGrantNamespaces := getReferenceGrantNamespaces(instance)
+	secretReference, ok := instance.GetAnnotations()[certAnnotation]
+	if !ok || secretReference == "" {
+		return reconcile.Result{}, r.releaseReferenceGrants(context, instance, referenceGrantNamespaces)
+	}
+	secretName := util.GetNamespacedName(secretReference, instance.GetNamespace())
+
+	secret := &corev1.Secret{}
+	err = r.GetClient().Get(context, secretName, secret)
+	if err != nil {
+		log.Error(err, "unable to find referenced secret", "secret", secretName)
+		return r.ManageError(context, instance, err)
+	}
+	if secret.Type != util.TLSSecret {
+		err = errors.New("secret " + secretName.String() + " is not of type " + util.TLSSecret)
+		log.Error(err, "invalid referenced secret", "secret", secretName)
+		return r.ManageError(context, instance, err)
+	}
+	err = checkSecretShared(r.GetClient(), secret, instance)
+	if err != nil {
+		if releaseErr := r.releaseReferenceGrants(context, instance, referenceGrantNamespaces); releaseErr != nil {
+			log.Error(releaseErr, "unable to delete reference grants", "gateway", req.NamespacedName)
+		}
+		if util.RecordSecretNotShared(r.GetRecorder(), instance, err) {
+			// the gateway is reconciled again when the secret is shared with its namespace
+			return reconcile.Result{}, nil
+		}
+		log.Error(err, "unable to verify whether secret is shared", "secret", secretName)
+		return r.ManageError(context, instance, err)
+	}
+
+	original := instance.DeepCopy()
+	if secretName.Namespace != instance.GetNamespace() {
+		// cross namespace references need to be allowed by a reference grant in the namespace of the secret
+		referenceGrant := newReferenceGrant(instance, secretName)
+		err = r.CreateOrUpdateResource(context, nil, "", referenceGrant)
+		if err != nil {
+			log.Error(err, "unable to create or update reference grant", "referencegrant", referenceGrant)
+			return r.ManageError(context, instance, err)
+		}
+		err = r.deleteReferenceGrants(context, req.NamespacedName, referenceGrantNamespaces, referenceGrant)
+		setReferenceGrantNamespace(instance, secretName.Namespace)
+	} else {
+		err = r.deleteReferenceGrants(context, req.NamespacedName, referenceGrantNamespaces, nil)
+		setReferenceGrantNamespace(instance, "")
+	}
+	if err != nil {
+		return r.ManageError(context, instance, err)
+	}
+
+	_, err = populateGatewayListeners(instance, secretName)
+	if err != nil {
+		log.Error(err, "unable to populate listeners", "gateway", req.NamespacedName)
+		return r.ManageError(context, instance, err)
+	}
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, instance, original)
+	if err != nil {
+		log.Error(err, "unable to update gateway", "gateway", req.NamespacedName)
+		return r.ManageError(context, instance, err)
+	}
+
+	return r.ManageSuccess(context, instance)
+}
+
+// populateGatewayListeners makes all the listeners that terminate TLS use the given secret.
+// Listeners in Passthrough mode and listeners that do not deal with TLS are left untouched.
+func populateGatewayListeners(gateway *unstructured.Unstructured, secretName types.NamespacedName) (bool, error) {
+	listeners, found, err := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+	if err != nil || !found {
+		return false, err
+	}
+	certificateRef := map[string]interface{}{
+		"group": "",
+		"kind":  "Secret",
+		"name":  secretName.Name,
+	}
+	if secretName.Namespace != gateway.GetNamespace() {
+		certificateRef["namespace"] = secretName.Namespace
+	}
+	shouldUpdate := false
+	for i := range listeners {
+		listener, ok := listeners[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		protocol, _, _ := unstructured.NestedString(listener, "protocol")
+		tls, found, _ := unstructured.NestedMap(listener, "tls")
+		if !found {
+			if protocol != "HTTPS" {
+				continue
+			}
+			tls = map[string]interface{}{}
+		}
+		if mode, _, _ := unstructured.NestedString(tls, "mode"); mode == "Passthrough" {
+			continue
+		}
+		certificateRefs, _, _ := unstructured.NestedSlice(tls, "certificateRefs")
+		if reflect.DeepEqual(certificateRefs, []interface{}{certificateRef}) {
+			continue
+		}
+		tls["certificateRefs"] = []interface{}{certificateRef}
+		listener["tls"] = tls
+		listeners[i] = listener
+		shouldUpdate = true
+	}
+	if !shouldUpdate {
+		return false, nil
+	}
+	return true, unstructured.SetNestedSlice(gateway.Object, listeners, "spec", "listeners")
+}
+
+func newReferenceGrant(gateway *unstructured.Unstructured, secretName types.NamespacedName) *unstructured.Unstructured {
+	referenceGrant := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"from": []interface{}{
+					map[string]interface{}{
+						"group":     GatewayGVK.Group,
+						"kind":      GatewayGVK.Kind,
+						"namespace": gateway.GetNamespace(),
+					},
+				},
+				"to": []interface{}{
+					map[string]interface{}{
+						"group": "",
+						"kind":  "Secret",
+						"name":  secretName.Name,
+					},
+				},
+			},
+		},
+	}
+	referenceGrant.SetGroupVersionKind(ReferenceGrantGVK)
+	referenceGrant.SetName(getReferenceGrantName(gateway))
+	referenceGrant.SetNamespace(secretName.Namespace)
+	referenceGrant.SetLabels(map[string]string{
+		referenceGrantManagedLabel: "true",
+	})
+	referenceGrant.SetAnnotations(map[string]string{
+		referenceGrantGatewayAnnotation: gateway.GetNamespace() + "/" + gateway.GetName(),
+	})
+	return referenceGrant
+}
+
+// getReferenceGrantName returns the name of the reference grant created for gateway. The namespace and the name of the gateway are
+// truncated to fit the maximum length of a name and followed by their hash, so that different gateways never share a reference grant.
+func getReferenceGrantName(gateway *unstructured.Unstructured) string {
+	hash := sha256.Sum256([]byte(gateway.GetNamespace() + "/" + gateway.GetName()))
+	suffix := "-" + hex.EncodeToString(hash[:])[:referenceGrantNameHashLength]
+	name := "cert-utils-" + gateway.GetNamespace() + "-" + gateway.GetName()
+	if len(name) > validation.DNS1123SubdomainMaxLength-len(suffix) {
+		name = strings.TrimRight(name[:validation.DNS1123SubdomainMaxLength-len(suffix)], "-.")
+	}
+	return name + suffix
+}
+
+// checkSecretShared returns a SecretNotSharedError if secret is not shared with the namespace of obj.
+// Gateways and backend tls policies, like routes, can only use secrets of other namespaces that are shared with their namespace.
+func checkSecretShared(c client.Client, secret *corev1.Secret, obj client.Object) error {
+	shared, err := util.IsSharedWithNamespace(c, secret, obj.GetNamespace())
+	if err != nil {
+		return err
+	}
+	if !shared {
+		return &util.SecretNotSharedError{
+			Secret: types.NamespacedName{
+				Namespace: secret.GetNamespace(),
+				Name:      secret.GetName(),
+			},
+			Namespace: obj.GetNamespace(),
+		}
+	}
+	return nil
+}
+
+// getReferenceGrantNamespaces returns the namespaces in which a reference grant may have been created for gateway
+func getReferenceGrantNamespaces(gateway *unstructured.Unstructured) []string {
+	if namespace := gateway.GetAnnotations()[referenceGrantNamespaceAnnotation]; namespace != "" {
+		return []string{namespace}
+	}
+	return []string{}
+}
+
+// setReferenceGrantNamespace records on gateway the namespace of its reference grant, an empty namespace removes the record
+func setReferenceGrantNamespace(gateway *unstructured.Unstructured, namespace string) {
+	annotations := gateway.GetAnnotations()
+	if namespace == "" {
+		if _, ok := annotations[referenceGrantNamespaceAnnotation]; !ok {
+			return
+		}
+		delete(annotations, referenceGrantNamespaceAnnotation)
+	} else {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[referenceGrantNamespaceAnnotation] = namespace
+	}
+	gateway.SetAnnotations(annotations)
+}
+
+// releaseReferenceGrants deletes the reference grants created for gateway in the given namespaces and removes their record from gateway
+func (r *GatewayCertificateReconciler) releaseReferenceGrants(context context.Context, gateway *unstructured.Unstructured, namespaces []string) error {
+	err := r.deleteReferenceGrants(context, types.NamespacedName{
+		Namespace: gateway.GetNamespace(),
+		Name:      gateway.GetName(),
+	}, namespaces, nil)
+	if err != nil {
+		return err
+	}
+	original := gateway.DeepCopy()
+	setReferenceGrantNamespace(gateway, "")
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, gateway, original)
+	return err
+}
+
+// deleteReferenceGrants deletes the reference grants created for the given gateway in the given namespaces, except the one to keep.
+// The reference grants are read from the cache, v1.NamespaceAll searches all the namespaces.
+func (r *GatewayCertificateReconciler) deleteReferenceGrants(context context.Context, gateway types.NamespacedName, namespaces []string, keep *unstructured.Unstructured) error {
+	for _, namespace := range namespaces {
+		referenceGrants := &unstructured.UnstructuredList{}
+		referenceGrants.SetGroupVersionKind(ReferenceGrantGVK.GroupVersion().WithKind(ReferenceGrantGVK.Kind + "List"))
+		err := r.cache.List(context, referenceGrants, client.InNamespace(namespace), client.MatchingLabels{
+			referenceGrantManagedLabel: "true",
+		})
+		if err != nil {
+			r.Log.Error(err, "unable to list reference grants", "namespace", namespace)
+			return err
+		}
+		for i := range referenceGrants.Items {
+			referenceGrant := &referenceGrants.Items[i]
+			if referenceGrant.GetAnnotations()[referenceGrantGatewayAnnotation] != gateway.String() {
+				continue
+			}
+			if keep != nil && keep.GetNamespace() == referenceGrant.GetNamespace() && keep.GetName() == referenceGrant.GetName() {
+				continue
+			}
+			err = r.DeleteResourceIfExists(context, referenceGrant)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// referencedSecretIndexField is the field index of the gateway api objects by the secret they reference
+const referencedSecretIndexField = "referencedSecret"
+
+// indexReferencingGatewayObjects indexes the objects of kind gvk by the {namespace}/{name} of the secret they reference with annotation
+func indexReferencingGatewayObjects(mgr ctrl.Manager, gvk schema.GroupVersionKind, annotation string) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	return mgr.GetFieldIndexer().IndexField(context.TODO(), obj, referencedSecretIndexField, func(o client.Object) []string {
+		reference, ok := o.GetAnnotations()[annotation]
+		if !ok || reference == "" {
+			return []string{}
+		}
+		return []string{util.GetNamespacedName(reference, o.GetNamespace()).String()}
+	})
+}
+
+type enqueueRequestForReferecingGatewayObjects struct {
+	client.Reader
+	log        logr.Logger
+	gvk        schema.GroupVersionKind
+	annotation string
+}
+
+func (e *enqueueRequestForReferecingGatewayObjects) matchSecret(secret types.NamespacedName) ([]unstructured.Unstructured, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(e.gvk.GroupVersion().WithKind(e.gvk.Kind + "List"))
+	err := e.List(context.TODO(), list, client.MatchingFields{referencedSecretIndexField: secret.String()})
+	if err != nil {
+		e.log.Error(err, "unable to list objects", "gvk", e.gvk)
+		return []unstructured.Unstructured{}, err
+	}
+	result := []unstructured.Unstructured{}
+	for _, obj := range list.Items {
+		if reference, ok := obj.GetAnnotations()[e.annotation]; ok && util.GetNamespacedName(reference, obj.GetNamespace()) == secret {
+			result = append(result, obj)
+		}
+	}
+	return result, nil
+}
+
+func (e *enqueueRequestForReferecingGatewayObjects) enqueue(secret types.NamespacedName, q workqueue.RateLimitingInterface) {
+	objs, _ := e.matchSecret(secret)
+	for _, obj := range objs {
+		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+		}})
+	}
+}
+
+// Create implements EventHandler
+// trigger a reconcile event for those objects that reference this secret
+func (e *enqueueRequestForReferecingGatewayObjects) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	e.enqueue(types.NamespacedName{
+		Name:      evt.Object.GetName(),
+		Namespace: evt.Object.GetNamespace(),
+	}, q)
+}
+
+// Update implements EventHandler
+// trigger a reconcile event for those objects that reference this secret
+func (e *enqueueRequestForReferecingGatewayObjects) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	e.enqueue(types.NamespacedName{
+		Name:      evt.ObjectNew.GetName(),
+		Namespace: evt.ObjectNew.GetNamespace(),
+	}, q)
+}
+
+// Delete implements EventHandler
+func (e *enqueueRequestForReferecingGatewayObjects) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	return
+}
+
+// Generic implements EventHandler
+func (e *enqueueRequestForReferecingGatewayObjects) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	return
+}
@@ -0,0 +1,571 @@
+package gateway
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	cutil "github.com/redhat-cop/cert-utils-operator/controllers/util"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newScheme registers the gateway api kinds as unstructured types, so that the fake client can serve them
+func newScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	for _, gvk := range []schema.GroupVersionKind{GatewayGVK, ReferenceGrantGVK, BackendTLSPolicyGVK} {
+		s.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+		s.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+	}
+	return s
+}
+
+func newGateway(name string, namespace string, secretReference string) *unstructured.Unstructured {
+	gateway := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"gatewayClassName": "example",
+				"listeners": []interface{}{
+					map[string]interface{}{
+						"name":     "http",
+						"protocol": "HTTP",
+						"port":     int64(80),
+					},
+					map[string]interface{}{
+						"name":     "https",
+						"protocol": "HTTPS",
+						"port":     int64(443),
+					},
+					map[string]interface{}{
+						"name":     "passthrough",
+						"protocol": "TLS",
+						"port":     int64(8443),
+						"tls": map[string]interface{}{
+							"mode": "Passthrough",
+						},
+					},
+				},
+			},
+		},
+	}
+	gateway.SetGroupVersionKind(GatewayGVK)
+	gateway.SetName(name)
+	gateway.SetNamespace(namespace)
+	gateway.SetAnnotations(map[string]string{
+		certAnnotation: secretReference,
+	})
+	return gateway
+}
+
+func TestGatewayControllerCrossNamespaceSecret(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test1",
+			Namespace: "certificates",
+			Annotations: map[string]string{
+				"cert-utils-operator.redhat-cop.io/shared-with-namespaces": namespace,
+			},
+		},
+		Type: "kubernetes.io/tls",
+		Data: map[string][]byte{
+			"tls.crt": []byte("cert"),
+			"tls.key": []byte("key"),
+		},
+	}
+	gateway := newGateway(name, namespace, "certificates/test1")
+
+	objs := []runtime.Object{secret, gateway}
+
+	s := newScheme()
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objs...).Build()
+
+	r := &GatewayCertificateReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("gateway_certificate_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, record.NewFakeRecorder(3), nil),
+		cache:          cl,
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(GatewayGVK)
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+
+	listeners, _, _ := unstructured.NestedSlice(instance.Object, "spec", "listeners")
+	assert.Len(t, listeners, 3)
+	_, found, _ := unstructured.NestedMap(listeners[0].(map[string]interface{}), "tls")
+	assert.False(t, found)
+	certificateRefs, _, _ := unstructured.NestedSlice(listeners[1].(map[string]interface{}), "tls", "certificateRefs")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"group":     "",
+			"kind":      "Secret",
+			"name":      "test1",
+			"namespace": "certificates",
+		},
+	}, certificateRefs)
+	_, found, _ = unstructured.NestedSlice(listeners[2].(map[string]interface{}), "tls", "certificateRefs")
+	assert.False(t, found)
+
+	referenceGrant := &unstructured.Unstructured{}
+	referenceGrant.SetGroupVersionKind(ReferenceGrantGVK)
+	err = cl.Get(context.TODO(), types.NamespacedName{
+		Name:      getReferenceGrantName(gateway),
+		Namespace: "certificates",
+	}, referenceGrant)
+	assert.NoError(t, err)
+	from, _, _ := unstructured.NestedSlice(referenceGrant.Object, "spec", "from")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"group":     "gateway.networking.k8s.io",
+			"kind":      "Gateway",
+			"namespace": namespace,
+		},
+	}, from)
+}
+
+func TestGatewayControllerDeletesPreviousReferenceGrants(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test1",
+			Namespace: "certificates",
+			Annotations: map[string]string{
+				"cert-utils-operator.redhat-cop.io/shared-with-namespaces": namespace,
+			},
+		},
+		Type: "kubernetes.io/tls",
+		Data: map[string][]byte{
+			"tls.crt": []byte("cert"),
+			"tls.key": []byte("key"),
+		},
+	}
+	gateway := newGateway(name, namespace, "certificates/test1")
+	gateway.SetAnnotations(map[string]string{
+		certAnnotation:                    "certificates/test1",
+		referenceGrantNamespaceAnnotation: "old-certificates",
+	})
+	// the reference grant created for the previous secret of the gateway
+	previous := newReferenceGrant(gateway, types.NamespacedName{Name: "test1", Namespace: "old-certificates"})
+	// a reference grant created for another gateway
+	otherGateway := newGateway("other", namespace, "old-certificates/test1")
+	other := newReferenceGrant(otherGateway, types.NamespacedName{Name: "test1", Namespace: "old-certificates"})
+
+	objs := []runtime.Object{secret, gateway, previous, other}
+
+	s := newScheme()
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objs...).Build()
+
+	r := &GatewayCertificateReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("gateway_certificate_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, record.NewFakeRecorder(3), nil),
+		cache:          cl,
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+
+	referenceGrants := &unstructured.UnstructuredList{}
+	referenceGrants.SetGroupVersionKind(ReferenceGrantGVK.GroupVersion().WithKind(ReferenceGrantGVK.Kind + "List"))
+	err = cl.List(context.TODO(), referenceGrants)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, referenceGrant := range referenceGrants.Items {
+		names = append(names, referenceGrant.GetNamespace()+"/"+referenceGrant.GetName())
+	}
+	assert.ElementsMatch(t, []string{
+		"certificates/" + getReferenceGrantName(gateway),
+		"old-certificates/" + getReferenceGrantName(otherGateway),
+	}, names)
+
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(GatewayGVK)
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, "certificates", instance.GetAnnotations()[referenceGrantNamespaceAnnotation])
+
+	// removing the annotation deletes the reference grant and its record
+	instance.SetAnnotations(map[string]string{
+		referenceGrantNamespaceAnnotation: "certificates",
+	})
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+
+	err = cl.List(context.TODO(), referenceGrants)
+	assert.NoError(t, err)
+	assert.Len(t, referenceGrants.Items, 1)
+	assert.Equal(t, getReferenceGrantName(otherGateway), referenceGrants.Items[0].GetName())
+
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	_, found := instance.GetAnnotations()[referenceGrantNamespaceAnnotation]
+	assert.False(t, found)
+}
+
+func TestGatewayControllerSecretNotShared(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test1",
+			Namespace: "certificates",
+			Annotations: map[string]string{
+				"cert-utils-operator.redhat-cop.io/shared-with-namespaces": "app1, app2",
+			},
+		},
+		Type: "kubernetes.io/tls",
+		Data: map[string][]byte{
+			"tls.crt": []byte("cert"),
+			"tls.key": []byte("key"),
+		},
+	}
+	gateway := newGateway(name, namespace, "certificates/test1")
+
+	objs := []runtime.Object{secret, gateway}
+
+	s := newScheme()
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objs...).Build()
+	recorder := record.NewFakeRecorder(3)
+
+	r := &GatewayCertificateReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("gateway_certificate_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, recorder, nil),
+		cache:          cl,
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	// the gateway is not requeued, it is reconciled again when the secret is shared with its namespace
+	result, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, reconcile.Result{}, result)
+	assert.Equal(t, "Warning "+cutil.SecretNotSharedReason+" secret certificates/test1 is not shared with namespace cert-utils-operator", <-recorder.Events)
+
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(GatewayGVK)
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	listeners, _, _ := unstructured.NestedSlice(instance.Object, "spec", "listeners")
+	_, found, _ := unstructured.NestedSlice(listeners[1].(map[string]interface{}), "tls", "certificateRefs")
+	assert.False(t, found)
+
+	referenceGrants := &unstructured.UnstructuredList{}
+	referenceGrants.SetGroupVersionKind(ReferenceGrantGVK.GroupVersion().WithKind(ReferenceGrantGVK.Kind + "List"))
+	err = cl.List(context.TODO(), referenceGrants)
+	assert.NoError(t, err)
+	assert.Empty(t, referenceGrants.Items)
+}
+
+func TestGetReferenceGrantName(t *testing.T) {
+	gateway := newGateway("gateway", "app", "certificates/test1")
+	assert.Regexp(t, "^cert-utils-app-gateway-[0-9a-f]{8}$", getReferenceGrantName(gateway))
+
+	// names that would be equal without the hash are different
+	assert.NotEqual(t, getReferenceGrantName(newGateway("b-c", "a", "")), getReferenceGrantName(newGateway("c", "a-b", "")))
+
+	// long names are truncated to the maximum length of a name
+	longName := strings.Repeat("a", validation.DNS1123SubdomainMaxLength)
+	name := getReferenceGrantName(newGateway(longName, "app", ""))
+	assert.Len(t, name, validation.DNS1123SubdomainMaxLength)
+	assert.Empty(t, validation.IsDNS1123Subdomain(name))
+	assert.NotEqual(t, name, getReferenceGrantName(newGateway(longName[1:], "app", "")))
+}
+
+func TestBackendTLSPolicyControllerPopulatesCA(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test1",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"ca.crt": []byte("ca"),
+		},
+	}
+	policy := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"validation": map[string]interface{}{
+					"hostname": "www.example.com",
+				},
+			},
+		},
+	}
+	policy.SetGroupVersionKind(BackendTLSPolicyGVK)
+	policy.SetName(name)
+	policy.SetNamespace(namespace)
+	policy.SetAnnotations(map[string]string{
+		destCAAnnotation: "test1",
+	})
+
+	objs := []runtime.Object{secret, policy}
+
+	s := newScheme()
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objs...).Build()
+
+	r := &BackendTLSPolicyReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("backendtlspolicy_ca_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, record.NewFakeRecorder(3), nil),
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+
+	configMap := &corev1.ConfigMap{}
+	err = cl.Get(context.TODO(), types.NamespacedName{
+		Name:      name + "-ca-bundle",
+		Namespace: namespace,
+	}, configMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "ca", configMap.Data["ca.crt"])
+
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(BackendTLSPolicyGVK)
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	caCertificateRefs, _, _ := unstructured.NestedSlice(instance.Object, "spec", "validation", "caCertificateRefs")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"group": "",
+			"kind":  "ConfigMap",
+			"name":  name + "-ca-bundle",
+		},
+	}, caCertificateRefs)
+}
+
+func TestBackendTLSPolicyControllerSkipsConfigMapNotOwned(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test1",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"ca.crt": []byte("ca"),
+		},
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-ca-bundle",
+			Namespace: namespace,
+		},
+		Data: map[string]string{
+			"ca.crt": "user ca",
+		},
+	}
+	policy := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"validation": map[string]interface{}{
+					"hostname": "www.example.com",
+				},
+			},
+		},
+	}
+	policy.SetGroupVersionKind(BackendTLSPolicyGVK)
+	policy.SetName(name)
+	policy.SetNamespace(namespace)
+	policy.SetUID("policy-uid")
+	policy.SetAnnotations(map[string]string{
+		destCAAnnotation: "test1",
+	})
+
+	objs := []runtime.Object{secret, configMap, policy}
+
+	s := newScheme()
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objs...).Build()
+
+	recorder := record.NewFakeRecorder(3)
+	r := &BackendTLSPolicyReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("backendtlspolicy_ca_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, recorder, nil),
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+
+	outConfigMap := &corev1.ConfigMap{}
+	err = cl.Get(context.TODO(), types.NamespacedName{
+		Name:      name + "-ca-bundle",
+		Namespace: namespace,
+	}, outConfigMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "user ca", outConfigMap.Data["ca.crt"])
+	assert.Empty(t, outConfigMap.GetOwnerReferences())
+
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(BackendTLSPolicyGVK)
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	_, found, _ := unstructured.NestedSlice(instance.Object, "spec", "validation", "caCertificateRefs")
+	assert.False(t, found)
+
+	event := <-recorder.Events
+	assert.Contains(t, event, "ConfigMapNotOwned")
+}
+
+func TestBackendTLSPolicyControllerCrossNamespaceSecret(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	shared := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "shared",
+			Namespace: "certificates",
+			Annotations: map[string]string{
+				"cert-utils-operator.redhat-cop.io/shared-with-namespaces": namespace,
+			},
+		},
+		Data: map[string][]byte{
+			"ca.crt": []byte("shared ca"),
+		},
+	}
+	private := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "private",
+			Namespace: "certificates",
+		},
+		Data: map[string][]byte{
+			"ca.crt": []byte("private ca"),
+		},
+	}
+	policy := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"validation": map[string]interface{}{
+					"hostname": "www.example.com",
+				},
+			},
+		},
+	}
+	policy.SetGroupVersionKind(BackendTLSPolicyGVK)
+	policy.SetName(name)
+	policy.SetNamespace(namespace)
+	policy.SetAnnotations(map[string]string{
+		destCAAnnotation: "certificates/private",
+	})
+
+	objs := []runtime.Object{shared, private, policy}
+
+	s := newScheme()
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objs...).Build()
+	recorder := record.NewFakeRecorder(3)
+
+	r := &BackendTLSPolicyReconciler{
+		Log:            ctrl.Log.WithName("controllers").WithName("backendtlspolicy_ca_controller"),
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, recorder, nil),
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	configMapName := types.NamespacedName{
+		Name:      name + "-ca-bundle",
+		Namespace: namespace,
+	}
+
+	// the private secret is not shared with the namespace of the policy
+	result, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, reconcile.Result{}, result)
+	err = cl.Get(context.TODO(), configMapName, &corev1.ConfigMap{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.Len(t, recorder.Events, 1)
+	event := <-recorder.Events
+	assert.Equal(t, "Warning "+cutil.SecretNotSharedReason+" secret certificates/private is not shared with namespace cert-utils-operator", event)
+
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(BackendTLSPolicyGVK)
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	instance.SetAnnotations(map[string]string{
+		destCAAnnotation: "certificates/shared",
+	})
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	configMap := &corev1.ConfigMap{}
+	err = cl.Get(context.TODO(), configMapName, configMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "shared ca", configMap.Data["ca.crt"])
+}
@@ -0,0 +1,324 @@
+package ingress
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
+	outils "github.com/redhat-cop/operator-utils/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const certAnnotation = util.AnnotationBase + "/certs-from-secret"
+
+// referencedSecretsIndexField is the field index of the ingresses by the secret they reference
+const referencedSecretsIndexField = "referencedSecrets"
+
+// IngressCertificateReconciler reconciles a Ingress object
+type IngressCertificateReconciler struct {
+	outils.ReconcilerBase
+	Log            logr.Logger
+	controllerName string
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IngressCertificateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.controllerName = "ingress_certificate_controller"
+
+	err := mgr.GetFieldIndexer().IndexField(context.TODO(), &networkingv1.Ingress{}, referencedSecretsIndexField, getReferencedSecrets)
+	if err != nil {
+		return err
+	}
+
+	// this will filter ingresses that have the annotation and on update only if the annotation or the tls section are changed.
+	isAnnotatedIngress := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldSecret, _ := e.ObjectOld.GetAnnotations()[certAnnotation]
+			newSecret, _ := e.ObjectNew.GetAnnotations()[certAnnotation]
+			if oldSecret != newSecret {
+				return true
+			}
+			if newSecret == "" {
+				return false
+			}
+			oldIngress, ok := e.ObjectOld.(*networkingv1.Ingress)
+			if !ok {
+				return false
+			}
+			newIngress, ok := e.ObjectNew.(*networkingv1.Ingress)
+			if !ok {
+				return false
+			}
+			return !reflect.DeepEqual(oldIngress.Spec.TLS, newIngress.Spec.TLS) || !reflect.DeepEqual(oldIngress.Spec.Rules, newIngress.Spec.Rules)
+		},
+		CreateFunc: func(e event.CreateEvent) bool {
+			_, ok := e.Object.GetAnnotations()[certAnnotation]
+			return ok
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+
+	isContentChanged := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldSecret, ok := e.ObjectOld.(*corev1.Secret)
+			if !ok {
+				return false
+			}
+			newSecret, ok := e.ObjectNew.(*corev1.Secret)
+			if !ok {
+				return false
+			}
+			if newSecret.Type != util.TLSSecret {
+				return false
+			}
+			return !reflect.DeepEqual(newSecret.Data[util.Cert], oldSecret.Data[util.Cert])
+		},
+		CreateFunc: func(e event.CreateEvent) bool {
+			secret, ok := e.Object.(*corev1.Secret)
+			if !ok {
+				return false
+			}
+			return secret.Type == util.TLSSecret
+		},
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&networkingv1.Ingress{
+			TypeMeta: v1.TypeMeta{
+				Kind: "Ingress",
+			},
+		}, builder.WithPredicates(isAnnotatedIngress)).
+		Watches(&source.Kind{Type: &corev1.Secret{
+			TypeMeta: v1.TypeMeta{
+				Kind: "Secret",
+			},
+		}}, &enqueueRequestForReferecingIngresses{
+			Client: mgr.GetClient(),
+			log:    ctrl.Log.WithName("enqueueRequestForReferecingIngresses"),
+		}, builder.WithPredicates(isContentChanged)).
+		Complete(r)
+}
+
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
+
+func (r *IngressCertificateReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
+	log := r.Log.WithValues("ingress-certificate", req.NamespacedName)
+
+	// Fetch the Ingress instance
+	instance := &networkingv1.Ingress{}
+	err := r.GetClient().Get(context, req.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+	secretName, ok := instance.GetAnnotations()[certAnnotation]
+	if !ok || secretName == "" {
+		return reconcile.Result{}, nil
+	}
+
+	secret := &corev1.Secret{}
+	err = r.GetClient().Get(context, types.NamespacedName{
+		Namespace: instance.GetNamespace(),
+		Name:      secretName,
+	}, secret)
+	if err != nil {
+		log.Error(err, "unable to find referenced secret", "secret", secretName)
+		return r.ManageError(context, instance, err)
+	}
+
+	// an invalid certificate does not prevent pointing the empty tls sections to the secret
+	cert, certErr := parseLeafCertificate(secret.Data[util.Cert])
+
+	original := instance.DeepCopy()
+	populateIngressWithSecretName(instance, secretName, cert)
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, instance, original)
+	if err != nil {
+		log.Error(err, "unable to update ingress", "ingress", instance)
+		return r.ManageError(context, instance, err)
+	}
+
+	if certErr != nil {
+		log.Error(certErr, "unable to parse certificate", "secret", secretName)
+		r.GetRecorder().Event(instance, "Warning", "InvalidCertificate", fmt.Sprintf("unable to parse certificate in secret %s: %s", secretName, certErr.Error()))
+		return r.ManageSuccess(context, instance)
+	}
+	uncoveredHosts := getHostsNotCoveredByCertificate(instance, secretName, cert)
+	if len(uncoveredHosts) > 0 {
+		r.GetRecorder().Event(instance, "Warning", "HostsNotCovered", fmt.Sprintf("the certificate in secret %s does not cover the following hosts: %s", secretName, strings.Join(uncoveredHosts, ", ")))
+	}
+
+	return r.ManageSuccess(context, instance)
+}
+
+// populateIngressWithSecretName points the tls sections of the ingress without a secret to the given secret.
+// Sections referencing another secret are changed only if cert, when not nil, covers all their hosts.
+// If the ingress has no tls section, one covering all the hosts of the ingress rules is created.
+func populateIngressWithSecretName(ingress *networkingv1.Ingress, secretName string, cert *x509.Certificate) bool {
+	shouldUpdate := false
+	if len(ingress.Spec.TLS) == 0 {
+		hosts := []string{}
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host != "" {
+				hosts = append(hosts, rule.Host)
+			}
+		}
+		ingress.Spec.TLS = []networkingv1.IngressTLS{
+			{
+				Hosts: hosts,
+			},
+		}
+	}
+	for i := range ingress.Spec.TLS {
+		tls := &ingress.Spec.TLS[i]
+		if tls.SecretName == secretName {
+			continue
+		}
+		if tls.SecretName == "" || (cert != nil && coversHosts(cert, tls.Hosts)) {
+			tls.SecretName = secretName
+			shouldUpdate = true
+		}
+	}
+	return shouldUpdate
+}
+
+// coversHosts returns whether hosts is not empty and cert is valid for all of them
+func coversHosts(cert *x509.Certificate, hosts []string) bool {
+	if len(hosts) == 0 {
+		return false
+	}
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLeafCertificate returns the first certificate of the given chain
+func parseLeafCertificate(pemCert []byte) (*x509.Certificate, error) {
+	p, _ := pem.Decode(pemCert)
+	if p == nil {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return x509.ParseCertificate(p.Bytes)
+}
+
+// getHostsNotCoveredByCertificate returns the hosts of the tls sections of the ingress pointing to secretName that are not valid for cert.
+func getHostsNotCoveredByCertificate(ingress *networkingv1.Ingress, secretName string, cert *x509.Certificate) []string {
+	hosts := []string{}
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName == secretName {
+			hosts = append(hosts, tls.Hosts...)
+		}
+	}
+	result := []string{}
+	seen := map[string]bool{}
+	for _, host := range hosts {
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		if cert.VerifyHostname(host) != nil {
+			result = append(result, host)
+		}
+	}
+	return result
+}
+
+// getReferencedSecrets returns the {namespace}/{name} of the secret referenced by the ingress
+func getReferencedSecrets(obj client.Object) []string {
+	if secretName := obj.GetAnnotations()[certAnnotation]; secretName != "" {
+		return []string{types.NamespacedName{Namespace: obj.GetNamespace(), Name: secretName}.String()}
+	}
+	return []string{}
+}
+
+func (e *enqueueRequestForReferecingIngresses) matchSecret(c client.Reader, secret types.NamespacedName) ([]networkingv1.Ingress, error) {
+	ingressList := &networkingv1.IngressList{}
+	err := c.List(context.TODO(), ingressList, client.InNamespace(secret.Namespace), client.MatchingFields{referencedSecretsIndexField: secret.String()})
+	if err != nil {
+		e.log.Error(err, "unable to list ingresses for this namespace: ", "namespace", secret.Namespace)
+		return []networkingv1.Ingress{}, err
+	}
+	result := []networkingv1.Ingress{}
+	for _, ingress := range ingressList.Items {
+		if secretName := ingress.GetAnnotations()[certAnnotation]; secretName == secret.Name {
+			result = append(result, ingress)
+		}
+	}
+	return result, nil
+}
+
+type enqueueRequestForReferecingIngresses struct {
+	client.Client
+	log logr.Logger
+}
+
+// trigger a ingress reconcile event for those ingresses that reference this secret
+func (e *enqueueRequestForReferecingIngresses) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	ingresses, _ := e.matchSecret(e.Client, types.NamespacedName{
+		Name:      evt.Object.GetName(),
+		Namespace: evt.Object.GetNamespace(),
+	})
+	for _, ingress := range ingresses {
+		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: ingress.GetNamespace(),
+			Name:      ingress.GetName(),
+		}})
+	}
+}
+
+// Update implements EventHandler
+// trigger a ingress reconcile event for those ingresses that reference this secret
+func (e *enqueueRequestForReferecingIngresses) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	ingresses, _ := e.matchSecret(e.Client, types.NamespacedName{
+		Name:      evt.ObjectNew.GetName(),
+		Namespace: evt.ObjectNew.GetNamespace(),
+	})
+	for _, ingress := range ingresses {
+		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: ingress.GetNamespace(),
+			Name:      ingress.GetName(),
+		}})
+	}
+}
+
+// Delete implements EventHandler
+func (e *enqueueRequestForReferecingIngresses) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	return
+}
+
+// Generic implements EventHandler
+func (e *enqueueRequestForReferecingIngresses) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	return
+}
@@ -0,0 +1,271 @@
+package ingress
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubectl/pkg/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestIngressControllerPopulatesSecretName(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	cert, err := ioutil.ReadFile("testdata/tls.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test1",
+			Namespace: namespace,
+		},
+		Type: "kubernetes.io/tls",
+		Data: map[string][]byte{
+			"tls.crt": cert,
+		},
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				"cert-utils-operator.redhat-cop.io/certs-from-secret": "test1",
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{Host: "www.example.com"},
+				{Host: "app1.apps.example.com"},
+				{Host: "www.example.org"},
+			},
+		},
+	}
+
+	objs := []runtime.Object{secret, ingress}
+
+	cl := fake.NewFakeClient(objs...)
+
+	fakeRecorder := record.NewFakeRecorder(3)
+
+	reconcileBase := util.NewReconcilerBase(cl, scheme.Scheme, nil, fakeRecorder, nil)
+	r := &IngressCertificateReconciler{
+		ReconcilerBase: reconcileBase,
+		Log:            ctrl.Log.WithName("controllers").WithName("ingress_certificate_controller"),
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	_, err = r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+
+	outIngress := &networkingv1.Ingress{}
+	cl.Get(context.TODO(), req.NamespacedName, outIngress)
+
+	assert.Equal(t, 1, len(outIngress.Spec.TLS))
+	assert.Equal(t, "test1", outIngress.Spec.TLS[0].SecretName)
+	assert.Equal(t, []string{"www.example.com", "app1.apps.example.com", "www.example.org"}, outIngress.Spec.TLS[0].Hosts)
+
+	// only www.example.org is not covered by the certificate SANs
+	event := <-fakeRecorder.Events
+	assert.Contains(t, event, "HostsNotCovered")
+	assert.Contains(t, event, "www.example.org")
+	assert.NotContains(t, event, "www.example.com")
+}
+
+func TestIngressControllerKeepsOtherSecretNames(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	cert, err := ioutil.ReadFile("testdata/tls.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test1",
+			Namespace: namespace,
+		},
+		Type: "kubernetes.io/tls",
+		Data: map[string][]byte{
+			"tls.crt": cert,
+		},
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				"cert-utils-operator.redhat-cop.io/certs-from-secret": "test1",
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{
+				// not covered by the certificate, the user secret is kept
+				{Hosts: []string{"www.example.org"}, SecretName: "example-org"},
+				// without a secret, it is pointed to the referenced secret
+				{Hosts: []string{"app1.apps.example.com"}},
+				// covered by the certificate, it is pointed to the referenced secret
+				{Hosts: []string{"www.example.com"}, SecretName: "previous"},
+			},
+		},
+	}
+
+	objs := []runtime.Object{secret, ingress}
+
+	cl := fake.NewFakeClient(objs...)
+
+	fakeRecorder := record.NewFakeRecorder(3)
+
+	reconcileBase := util.NewReconcilerBase(cl, scheme.Scheme, nil, fakeRecorder, nil)
+	r := &IngressCertificateReconciler{
+		ReconcilerBase: reconcileBase,
+		Log:            ctrl.Log.WithName("controllers").WithName("ingress_certificate_controller"),
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	_, err = r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+
+	outIngress := &networkingv1.Ingress{}
+	cl.Get(context.TODO(), req.NamespacedName, outIngress)
+
+	assert.Equal(t, 3, len(outIngress.Spec.TLS))
+	assert.Equal(t, "example-org", outIngress.Spec.TLS[0].SecretName)
+	assert.Equal(t, "test1", outIngress.Spec.TLS[1].SecretName)
+	assert.Equal(t, "test1", outIngress.Spec.TLS[2].SecretName)
+
+	// www.example.org is served by another secret, so it is not reported
+	assert.Empty(t, fakeRecorder.Events)
+}
+
+func TestIngressControllerInvalidCertificate(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test1",
+			Namespace: namespace,
+		},
+		Type: "kubernetes.io/tls",
+		Data: map[string][]byte{
+			"tls.crt": []byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"),
+		},
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				"cert-utils-operator.redhat-cop.io/certs-from-secret": "test1",
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{
+				{Hosts: []string{"www.example.com"}, SecretName: "previous"},
+				{Hosts: []string{"app1.apps.example.com"}},
+			},
+		},
+	}
+
+	objs := []runtime.Object{secret, ingress}
+
+	cl := fake.NewFakeClient(objs...)
+
+	fakeRecorder := record.NewFakeRecorder(3)
+
+	reconcileBase := util.NewReconcilerBase(cl, scheme.Scheme, nil, fakeRecorder, nil)
+	r := &IngressCertificateReconciler{
+		ReconcilerBase: reconcileBase,
+		Log:            ctrl.Log.WithName("controllers").WithName("ingress_certificate_controller"),
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	_, err := r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+
+	outIngress := &networkingv1.Ingress{}
+	cl.Get(context.TODO(), req.NamespacedName, outIngress)
+
+	// the hosts covered by the certificate are unknown, so only the entry without a secret is filled
+	assert.Equal(t, "previous", outIngress.Spec.TLS[0].SecretName)
+	assert.Equal(t, "test1", outIngress.Spec.TLS[1].SecretName)
+
+	event := <-fakeRecorder.Events
+	assert.Contains(t, event, "InvalidCertificate")
+}
+
+func TestIngressControllerMatchSecret(t *testing.T) {
+	namespace := "cert-utils-operator"
+	newIngress := func(name string, secretName string) *networkingv1.Ingress {
+		return &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Annotations: map[string]string{
+					"cert-utils-operator.redhat-cop.io/certs-from-secret": secretName,
+				},
+			},
+		}
+	}
+
+	cl := fake.NewFakeClient(newIngress("ingress1", "test1"), newIngress("ingress2", "test2"), &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ingress3",
+			Namespace: namespace,
+		},
+	})
+
+	e := &enqueueRequestForReferecingIngresses{
+		Client: cl,
+		log:    ctrl.Log.WithName("enqueueRequestForReferecingIngresses"),
+	}
+	ingresses, err := e.matchSecret(cl, types.NamespacedName{Name: "test1", Namespace: namespace})
+	if err != nil {
+		t.Fatalf("matchSecret: (%v)", err)
+	}
+	assert.Equal(t, 1, len(ingresses))
+	assert.Equal(t, "ingress1", ingresses[0].Name)
+
+	assert.Equal(t, []string{namespace + "/test1"}, getReferencedSecrets(newIngress("ingress1", "test1")))
+	assert.Empty(t, getReferencedSecrets(&networkingv1.Ingress{}))
+}
@@ -1,8 +1,15 @@
 package route
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	routev1 "github.com/openshift/api/route/v1"
@@ -25,6 +32,31 @@ import (
 const certAnnotation = util.AnnotationBase + "/certs-from-secret"
 const destCAAnnotation = util.AnnotationBase + "/destinationCA-from-secret"
 const injectCAAnnotation = util.AnnotationBase + "/inject-CA"
+const certsValidationErrorAnnotation = util.AnnotationBase + "/certs-validation-error"
+const strictCertsValidationAnnotation = util.AnnotationBase + "/strict-certs-validation"
+const chainModeAnnotation = util.AnnotationBase + "/chain-mode"
+const onSecretDeleteAnnotation = util.AnnotationBase + "/on-secret-delete"
+
+// referencedSecretsIndexField is the field index of the routes by the secrets they reference
+const referencedSecretsIndexField = "referencedSecrets"
+
+const (
+	// chainModeAsIs copies tls.crt and ca.crt in the route without changes
+	chainModeAsIs = "as-is"
+	// chainModeNormalize orders the certificates of tls.crt from the leaf to the last intermediate, dropping duplicates and roots
+	chainModeNormalize = "normalize"
+	// chainModeFull is like chainModeNormalize, but also completes the chain with the intermediates found in ca.crt
+	chainModeFull = "full"
+)
+
+const (
+	// onSecretDeleteKeep leaves the route untouched when a referenced secret is deleted
+	onSecretDeleteKeep = "keep"
+	// onSecretDeleteClear clears all the tls fields managed by the operator when a referenced secret is deleted
+	onSecretDeleteClear = "clear"
+	// onSecretDeleteFallbackToDefault clears only the tls fields populated from the deleted secret, so that the router uses its defaults for them
+	onSecretDeleteFallbackToDefault = "fallback-to-default"
+)
 
 // RouteCertificateReconciler reconciles a Namespace object
 type RouteCertificateReconciler struct {
@@ -37,6 +69,17 @@ type RouteCertificateReconciler struct {
 func (r *RouteCertificateReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.controllerName = "route_certificate_controller"
 
+	err := mgr.GetFieldIndexer().IndexField(context.TODO(), &routev1.Route{}, referencedSecretsIndexField, func(obj client.Object) []string {
+		route, ok := obj.(*routev1.Route)
+		if !ok {
+			return []string{}
+		}
+		return getReferencedSecrets(route)
+	})
+	if err != nil {
+		return err
+	}
+
 	// this will filter routes that have the annotation and on update only if the annotation is changed.
 	isAnnotatedAndSecureRoute := predicate.Funcs{
 		UpdateFunc: func(e event.UpdateEvent) bool {
@@ -49,8 +92,16 @@ func (r *RouteCertificateReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			if oldSecret != newSecret {
 				return true
 			}
+			for _, annotation := range []string{injectCAAnnotation, strictCertsValidationAnnotation, chainModeAnnotation, onSecretDeleteAnnotation} {
+				if e.ObjectOld.GetAnnotations()[annotation] != e.ObjectNew.GetAnnotations()[annotation] {
+					return true
+				}
+			}
 			oldRoute, _ := e.ObjectOld.DeepCopyObject().(*routev1.Route)
 			if newSecret != "" {
+				if newRoute.Spec.Host != oldRoute.Spec.Host {
+					return true
+				}
 				if newRoute.Spec.TLS.Key != oldRoute.Spec.TLS.Key {
 					return true
 				}
@@ -104,7 +155,8 @@ func (r *RouteCertificateReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			if newSecret.Type != util.TLSSecret {
 				return false
 			}
-			return !reflect.DeepEqual(newSecret.Data[util.Cert], oldSecret.Data[util.Cert]) ||
+			return util.IsSecretSharingChanged(oldSecret, newSecret) ||
+				!reflect.DeepEqual(newSecret.Data[util.Cert], oldSecret.Data[util.Cert]) ||
 				!reflect.DeepEqual(newSecret.Data[util.Key], oldSecret.Data[util.Key]) ||
 				!reflect.DeepEqual(newSecret.Data[util.CA], oldSecret.Data[util.CA])
 		},
@@ -118,6 +170,9 @@ func (r *RouteCertificateReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			}
 			return true
 		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return true
+		},
 	}
 
 	return ctrl.NewControllerManagedBy(mgr).
@@ -131,7 +186,7 @@ func (r *RouteCertificateReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				Kind: "Secret",
 			},
 		}}, &enqueueRequestForReferecingRoutes{
-			Client: mgr.GetClient(),
+			Reader: mgr.GetCache(),
 			log:    ctrl.Log.WithName("enqueueRequestForReferecingRoutes"),
 		}, builder.WithPredicates(isContentChanged)).
 		Complete(r)
@@ -139,6 +194,7 @@ func (r *RouteCertificateReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
 // +kubebuilder:rbac:groups=route.openshift.io,resources=*,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch
 
 func (r *RouteCertificateReconciler) Reconcile(context context.Context, req ctrl.Request) (reconcile.Result, error) {
@@ -162,96 +218,173 @@ func (r *RouteCertificateReconciler) Reconcile(context context.Context, req ctrl
 	}
 	secretName, ok := instance.GetAnnotations()[certAnnotation]
 	caSecretName, okca := instance.GetAnnotations()[destCAAnnotation]
-	shouldUpdate := false
+	onSecretDelete, err := getOnSecretDeletePolicy(instance)
+	if err != nil {
+		log.Error(err, "invalid annotation", "annotation", onSecretDeleteAnnotation)
+		return r.ManageError(context, instance, err)
+	}
+	original := instance.DeepCopy()
+	var requeueAfter time.Duration
+	deletedSecrets := []string{}
+	certSecretDeleted, caSecretDeleted := false, false
 	if !ok {
-		if instance.Spec.TLS.Key != "" {
-			instance.Spec.TLS.Key = ""
-			shouldUpdate = true
-		}
-		if instance.Spec.TLS.Certificate != "" {
-			instance.Spec.TLS.Certificate = ""
-			shouldUpdate = true
+		clearRouteCertificates(instance)
+		setCertsValidationError(instance, nil)
+	} else if secret, err := r.getReferencedSecret(context, instance, secretName); err != nil {
+		if util.RecordSecretNotShared(r.GetRecorder(), instance, err) {
+			// the route is reconciled again when the secret is shared with its namespace
+			return reconcile.Result{}, nil
 		}
-		if instance.Spec.TLS.CACertificate != "" {
-			instance.Spec.TLS.CACertificate = ""
-			shouldUpdate = true
+		if !errors.IsNotFound(err) {
+			log.Error(err, "unable to get referenced secret", "secret", secretName)
+			return r.ManageError(context, instance, err)
 		}
-
+		deletedSecrets = append(deletedSecrets, secretName)
+		certSecretDeleted = true
 	} else {
-		secret := &corev1.Secret{}
-		err = r.GetClient().Get(context, types.NamespacedName{
-			Namespace: instance.GetNamespace(),
-			Name:      secretName,
-		}, secret)
-		if err != nil {
-			log.Error(err, "unable to find referenced secret", "secret", secretName)
-			return r.ManageError(context, instance, err)
+		now := time.Now()
+		cert, validationErr := validateRouteCertificate(instance, secret, now)
+		requeueAfter = getValidityRecheckInterval(cert, now)
+		if validationErr != nil {
+			log.Info("invalid certificate", "secret", secretName, "reason", validationErr.Error())
+			r.GetRecorder().Event(instance, "Warning", "InvalidCertificate", fmt.Sprintf("the certificate in secret %s is not valid for this route: %s", secretName, validationErr.Error()))
+		}
+		setCertsValidationError(instance, validationErr)
+		if validationErr == nil || instance.GetAnnotations()[strictCertsValidationAnnotation] != "true" {
+			_, err := populateRouteWithCertifcates(instance, secret)
+			if err != nil {
+				log.Error(err, "unable to build certificate chain", "secret", secretName)
+				return r.ManageError(context, instance, err)
+			}
 		}
-		shouldUpdate = shouldUpdate || populateRouteWithCertifcates(instance, secret)
 	}
 	if !okca {
-		if instance.Spec.TLS.DestinationCACertificate != "" {
-			instance.Spec.TLS.DestinationCACertificate = ""
-			shouldUpdate = true
+		clearRouteDestCA(instance)
+	} else if secret, err := r.getReferencedSecret(context, instance, caSecretName); err != nil {
+		if util.RecordSecretNotShared(r.GetRecorder(), instance, err) {
+			// the route is reconciled again when the secret is shared with its namespace
+			return reconcile.Result{}, nil
 		}
-	} else {
-		secret := &corev1.Secret{}
-		err = r.GetClient().Get(context, types.NamespacedName{
-			Namespace: instance.GetNamespace(),
-			Name:      caSecretName,
-		}, secret)
-		if err != nil {
-			log.Error(err, "unable to find referenced ca secret", "secret", secretName)
+		if !errors.IsNotFound(err) {
+			log.Error(err, "unable to get referenced ca secret", "secret", caSecretName)
 			return r.ManageError(context, instance, err)
 		}
-		shouldUpdate = shouldUpdate || populateRouteDestCA(instance, secret)
+		if !certSecretDeleted || caSecretName != secretName {
+			deletedSecrets = append(deletedSecrets, caSecretName)
+		}
+		caSecretDeleted = true
+	} else {
+		populateRouteDestCA(instance, secret)
 	}
 
-	if shouldUpdate {
-		err = r.GetClient().Update(context, instance)
-		if err != nil {
-			log.Error(err, "unable to update route", "route", instance)
-			return r.ManageError(context, instance, err)
+	if (certSecretDeleted || caSecretDeleted) && onSecretDelete == onSecretDeleteKeep {
+		// the route keeps serving the last certificates, the missing secrets are recorded in the validation error annotation
+		// so that they are reported only once, until the secrets are created again
+		message := fmt.Sprintf("referenced secrets %s not found", strings.Join(deletedSecrets, ", "))
+		if validationErr := instance.GetAnnotations()[certsValidationErrorAnnotation]; !certSecretDeleted && validationErr != "" {
+			message = validationErr + "; " + message
+		}
+		setCertsValidationError(instance, fmt.Errorf("%s", message))
+		if original.GetAnnotations()[certsValidationErrorAnnotation] != message {
+			log.Info("referenced secrets not found, keeping route tls fields", "secrets", deletedSecrets, "policy", onSecretDelete)
+			r.GetRecorder().Event(instance, "Warning", "SecretDeleted", fmt.Sprintf("referenced secrets %s not found, tls fields kept according to the %s policy", strings.Join(deletedSecrets, ", "), onSecretDelete))
+		}
+	} else if certSecretDeleted || caSecretDeleted {
+		// the referenced secrets have been deleted, the route is cleaned up according to the on-secret-delete policy
+		cleared := false
+		if certSecretDeleted || onSecretDelete == onSecretDeleteClear {
+			cleared = clearRouteCertificates(instance) || cleared
+			cleared = setCertsValidationError(instance, nil) || cleared
+		}
+		if caSecretDeleted || onSecretDelete == onSecretDeleteClear {
+			cleared = clearRouteDestCA(instance) || cleared
+		}
+		if cleared && !reflect.DeepEqual(original.Spec.TLS, instance.Spec.TLS) {
+			log.Info("referenced secrets not found, clearing route tls fields", "secrets", deletedSecrets, "policy", onSecretDelete)
+			r.GetRecorder().Event(instance, "Warning", "SecretDeleted", fmt.Sprintf("referenced secrets %s not found, tls fields cleared according to the %s policy", strings.Join(deletedSecrets, ", "), onSecretDelete))
 		}
 	}
 
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, instance, original)
+	if err != nil {
+		log.Error(err, "unable to update route", "route", instance)
+		return r.ManageError(context, instance, err)
+	}
+
 	// if we are here we know it's because a route was create/modified or its referenced secret was created/modified
 	// therefore the only think we need to do is to update the route certificates
 
-	return r.ManageSuccess(context, instance)
+	result, err := r.ManageSuccess(context, instance)
+	if err == nil && requeueAfter > 0 {
+		// the validity of the certificate will change, we need to validate it again at that time
+		result.RequeueAfter = requeueAfter
+	}
+	return result, err
+}
+
+// getReferencedSecret returns the secret referenced by the route, reference can be in format {namespace}/{secret-name} or {secret-name}.
+// Secrets from other namespaces are returned only if they are shared with the namespace of the route, otherwise a SecretNotSharedError is returned.
+func (r *RouteCertificateReconciler) getReferencedSecret(context context.Context, route *routev1.Route, reference string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := r.GetClient().Get(context, util.GetNamespacedName(reference, route.GetNamespace()), secret)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := util.IsSharedWithNamespace(r.GetClient(), secret, route.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	if !shared {
+		return nil, &util.SecretNotSharedError{
+			Secret:    util.GetNamespacedName(reference, route.GetNamespace()),
+			Namespace: route.GetNamespace(),
+		}
+	}
+	return secret, nil
+}
+
+// getReferencedSecrets returns the {namespace}/{name} of the secrets referenced by the route
+func getReferencedSecrets(route *routev1.Route) []string {
+	result := []string{}
+	if route.Spec.TLS == nil {
+		return result
+	}
+	for _, annotation := range []string{certAnnotation, destCAAnnotation} {
+		if secretName, ok := route.GetAnnotations()[annotation]; ok {
+			result = append(result, util.GetNamespacedName(secretName, route.GetNamespace()).String())
+		}
+	}
+	return result
 }
 
-func (e *enqueueRequestForReferecingRoutes) matchSecret(c client.Client, secret types.NamespacedName) ([]routev1.Route, error) {
+func (e *enqueueRequestForReferecingRoutes) matchSecret(c client.Reader, secret types.NamespacedName) ([]routev1.Route, error) {
+	// routes can reference secrets from other namespaces, so we look them up in the index of all the routes
 	routeList := &routev1.RouteList{}
-	err := c.List(context.TODO(), routeList, &client.ListOptions{
-		Namespace: secret.Namespace,
-	})
+	err := c.List(context.TODO(), routeList, client.MatchingFields{referencedSecretsIndexField: secret.String()})
 	if err != nil {
-		e.log.Error(err, "unable to list routes for this namespace: ", "namespace", secret.Namespace)
+		e.log.Error(err, "unable to list routes")
 		return []routev1.Route{}, err
 	}
 	result := []routev1.Route{}
 	for _, route := range routeList.Items {
-		if secretName := route.GetAnnotations()[certAnnotation]; secretName == secret.Name && route.Spec.TLS != nil {
-			result = append(result, route)
-			continue
-		}
-		if secretName := route.GetAnnotations()[destCAAnnotation]; secretName == secret.Name && route.Spec.TLS != nil {
-			result = append(result, route)
-			continue
+		for _, secretName := range getReferencedSecrets(&route) {
+			if secretName == secret.String() {
+				result = append(result, route)
+				break
+			}
 		}
 	}
 	return result, nil
 }
 
 type enqueueRequestForReferecingRoutes struct {
-	client.Client
+	client.Reader
 	log logr.Logger
 }
 
 // trigger a router reconcile event for those routes that reference this secret
 func (e *enqueueRequestForReferecingRoutes) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
-	routes, _ := e.matchSecret(e.Client, types.NamespacedName{
+	routes, _ := e.matchSecret(e.Reader, types.NamespacedName{
 		Name:      evt.Object.GetName(),
 		Namespace: evt.Object.GetNamespace(),
 	})
@@ -266,7 +399,7 @@ func (e *enqueueRequestForReferecingRoutes) Create(evt event.CreateEvent, q work
 // Update implements EventHandler
 // trigger a router reconcile event for those routes that reference this secret
 func (e *enqueueRequestForReferecingRoutes) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
-	routes, _ := e.matchSecret(e.Client, types.NamespacedName{
+	routes, _ := e.matchSecret(e.Reader, types.NamespacedName{
 		Name:      evt.ObjectNew.GetName(),
 		Namespace: evt.ObjectNew.GetNamespace(),
 	})
@@ -279,8 +412,18 @@ func (e *enqueueRequestForReferecingRoutes) Update(evt event.UpdateEvent, q work
 }
 
 // Delete implements EventHandler
+// trigger a router reconcile event for those routes that reference this secret, so that they can be cleaned up
 func (e *enqueueRequestForReferecingRoutes) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
-	return
+	routes, _ := e.matchSecret(e.Reader, types.NamespacedName{
+		Name:      evt.Object.GetName(),
+		Namespace: evt.Object.GetNamespace(),
+	})
+	for _, route := range routes {
+		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: route.GetNamespace(),
+			Name:      route.GetName(),
+		}})
+	}
 }
 
 // Generic implements EventHandler
@@ -288,7 +431,7 @@ func (e *enqueueRequestForReferecingRoutes) Generic(evt event.GenericEvent, q wo
 	return
 }
 
-func populateRouteWithCertifcates(route *routev1.Route, secret *corev1.Secret) bool {
+func populateRouteWithCertifcates(route *routev1.Route, secret *corev1.Secret) (bool, error) {
 	shouldUpdate := false
 	if route.Spec.TLS.Termination == "edge" || route.Spec.TLS.Termination == "reencrypt" {
 		// here we need to replace the terminating certifciate
@@ -298,24 +441,279 @@ func populateRouteWithCertifcates(route *routev1.Route, secret *corev1.Secret) b
 				shouldUpdate = true
 			}
 		}
-		if value, ok := secret.Data[util.Cert]; ok && len(value) != 0 {
-			if route.Spec.TLS.Certificate != string(value) {
-				route.Spec.TLS.Certificate = string(value)
-				shouldUpdate = true
-			}
+		chainMode, ok := route.Annotations[chainModeAnnotation]
+		if !ok {
+			chainMode = chainModeAsIs
 		}
-		if value, ok := route.Annotations[injectCAAnnotation]; ok && value != "false" {
-			if value, ok := secret.Data[util.CA]; ok && len(value) != 0 {
-				if route.Spec.TLS.CACertificate != string(value) {
-					route.Spec.TLS.CACertificate = string(value)
+		if chainMode == chainModeAsIs {
+			if value, ok := secret.Data[util.Cert]; ok && len(value) != 0 {
+				if route.Spec.TLS.Certificate != string(value) {
+					route.Spec.TLS.Certificate = string(value)
 					shouldUpdate = true
 				}
 			}
+			if value, ok := route.Annotations[injectCAAnnotation]; ok && value != "false" {
+				if value, ok := secret.Data[util.CA]; ok && len(value) != 0 {
+					if route.Spec.TLS.CACertificate != string(value) {
+						route.Spec.TLS.CACertificate = string(value)
+						shouldUpdate = true
+					}
+				}
+			}
+			return shouldUpdate, nil
+		}
+		if value, ok := secret.Data[util.Cert]; !ok || len(value) == 0 {
+			return shouldUpdate, nil
 		}
+		certificate, caCertificate, err := buildRouteChain(secret.Data[util.Cert], secret.Data[util.CA], secret.Data[util.Key], chainMode)
+		if err != nil {
+			return false, err
+		}
+		if route.Spec.TLS.Certificate != certificate {
+			route.Spec.TLS.Certificate = certificate
+			shouldUpdate = true
+		}
+		if value, ok := route.Annotations[injectCAAnnotation]; ok && value != "false" {
+			// only the certificates that are not already served in the chain are injected
+			if route.Spec.TLS.CACertificate != caCertificate {
+				route.Spec.TLS.CACertificate = caCertificate
+				shouldUpdate = true
+			}
+		}
+	}
+	return shouldUpdate, nil
+}
+
+// buildRouteChain builds the certificate chain served by the route and the ca certificates that are not part of it, according to chainMode.
+// The chain starts with the leaf certificate of certPEM, as chosen by selectLeaf, followed by its intermediates, duplicates and roots are dropped.
+func buildRouteChain(certPEM []byte, caPEM []byte, keyPEM []byte, chainMode string) (string, string, error) {
+	if chainMode != chainModeNormalize && chainMode != chainModeFull {
+		return "", "", fmt.Errorf("unsupported chain mode %s, supported values are %s, %s and %s", chainMode, chainModeAsIs, chainModeNormalize, chainModeFull)
+	}
+	certs, err := parseCertificates(certPEM)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to parse %s: %s", util.Cert, err.Error())
+	}
+	cas, err := parseCertificates(caPEM)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to parse %s: %s", util.CA, err.Error())
+	}
+	if len(certs) == 0 {
+		return "", "", fmt.Errorf("no certificate found in %s", util.Cert)
+	}
+	leaf := selectLeaf(certs, keyPEM)
+	candidates := certs
+	if chainMode == chainModeFull {
+		candidates = append(append([]*x509.Certificate{}, certs...), cas...)
+	}
+	chain := []*x509.Certificate{leaf}
+	for current := leaf; ; {
+		issuer := findIssuer(current, candidates)
+		if issuer == nil || isSelfSigned(issuer) || containsCertificate(chain, issuer) {
+			break
+		}
+		chain = append(chain, issuer)
+		current = issuer
+	}
+	missing := []*x509.Certificate{}
+	for _, ca := range cas {
+		if !containsCertificate(chain, ca) && !containsCertificate(missing, ca) {
+			missing = append(missing, ca)
+		}
+	}
+	return encodeCertificates(chain), encodeCertificates(missing), nil
+}
+
+// selectLeaf returns the leaf certificate among certs: the one matching the private key in keyPEM, otherwise the first certificate
+// that is not a ca, otherwise the first certificate
+func selectLeaf(certs []*x509.Certificate, keyPEM []byte) *x509.Certificate {
+	if len(keyPEM) != 0 {
+		for _, cert := range certs {
+			if _, err := tls.X509KeyPair([]byte(encodeCertificates([]*x509.Certificate{cert})), keyPEM); err == nil {
+				return cert
+			}
+		}
+	}
+	for _, cert := range certs {
+		if !cert.IsCA {
+			return cert
+		}
+	}
+	return certs[0]
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for {
+		p, rest := pem.Decode(data)
+		if p == nil {
+			break
+		}
+		data = rest
+		if p.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func encodeCertificates(certs []*x509.Certificate) string {
+	var buffer bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buffer, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buffer.String()
+}
+
+// findIssuer returns the certificate among candidates that signed cert, nil if none
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if candidate.Equal(cert) {
+			continue
+		}
+		if bytes.Equal(cert.RawIssuer, candidate.RawSubject) && cert.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+func containsCertificate(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRouteCertificate verifies that the leaf certificate served by the route covers the host of the route, is currently valid and
+// matches the key in the secret. The leaf is the first certificate of the secret in as-is chain mode, and the one picked by selectLeaf
+// in the other chain modes. Secrets without a certificate are not validated.
+// The parsed certificate is returned when available.
+func validateRouteCertificate(route *routev1.Route, secret *corev1.Secret, now time.Time) (*x509.Certificate, error) {
+	certPEM, ok := secret.Data[util.Cert]
+	if !ok || len(certPEM) == 0 {
+		return nil, nil
+	}
+	certs, err := parseCertificates(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse certificate: %s", err.Error())
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	cert := certs[0]
+	if chainMode := route.GetAnnotations()[chainModeAnnotation]; chainMode == chainModeNormalize || chainMode == chainModeFull {
+		cert = selectLeaf(certs, secret.Data[util.Key])
+	}
+	failures := []string{}
+	if route.Spec.Host != "" {
+		if err := cert.VerifyHostname(route.Spec.Host); err != nil {
+			failures = append(failures, fmt.Sprintf("host %s is not covered by the certificate", route.Spec.Host))
+		}
+	}
+	if now.Before(cert.NotBefore) {
+		failures = append(failures, fmt.Sprintf("certificate is not valid before %s", cert.NotBefore.Format(time.RFC3339)))
+	}
+	if now.After(cert.NotAfter) {
+		failures = append(failures, fmt.Sprintf("certificate expired on %s", cert.NotAfter.Format(time.RFC3339)))
+	}
+	if keyPEM, ok := secret.Data[util.Key]; ok && len(keyPEM) != 0 {
+		if _, err := tls.X509KeyPair([]byte(encodeCertificates([]*x509.Certificate{cert})), keyPEM); err != nil {
+			failures = append(failures, fmt.Sprintf("key does not match the certificate: %s", err.Error()))
+		}
+	}
+	if len(failures) > 0 {
+		return cert, fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return cert, nil
+}
+
+// getValidityRecheckInterval returns after how long the validity of the certificate must be checked again, 0 if it will not change anymore
+func getValidityRecheckInterval(cert *x509.Certificate, now time.Time) time.Duration {
+	if cert == nil {
+		return 0
+	}
+	if now.Before(cert.NotBefore) {
+		return cert.NotBefore.Sub(now)
+	}
+	if now.Before(cert.NotAfter) {
+		return cert.NotAfter.Sub(now)
+	}
+	return 0
+}
+
+// setCertsValidationError records the result of the certificate validation in an annotation of the route, a nil error removes the annotation
+func setCertsValidationError(route *routev1.Route, validationErr error) bool {
+	current, found := route.GetAnnotations()[certsValidationErrorAnnotation]
+	if validationErr == nil {
+		if !found {
+			return false
+		}
+		delete(route.Annotations, certsValidationErrorAnnotation)
+		return true
+	}
+	if found && current == validationErr.Error() {
+		return false
+	}
+	if route.Annotations == nil {
+		route.Annotations = map[string]string{}
+	}
+	route.Annotations[certsValidationErrorAnnotation] = validationErr.Error()
+	return true
+}
+
+// getOnSecretDeletePolicy returns the on-secret-delete policy of the route, keep by default
+func getOnSecretDeletePolicy(route *routev1.Route) (string, error) {
+	policy, ok := route.GetAnnotations()[onSecretDeleteAnnotation]
+	if !ok {
+		return onSecretDeleteKeep, nil
+	}
+	switch policy {
+	case onSecretDeleteKeep, onSecretDeleteClear, onSecretDeleteFallbackToDefault:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unsupported on-secret-delete policy %s, supported values are %s, %s and %s", policy, onSecretDeleteKeep, onSecretDeleteClear, onSecretDeleteFallbackToDefault)
+	}
+}
+
+// clearRouteCertificates removes the fields populated from the certs-from-secret secret
+func clearRouteCertificates(route *routev1.Route) bool {
+	shouldUpdate := false
+	if route.Spec.TLS.Key != "" {
+		route.Spec.TLS.Key = ""
+		shouldUpdate = true
+	}
+	if route.Spec.TLS.Certificate != "" {
+		route.Spec.TLS.Certificate = ""
+		shouldUpdate = true
+	}
+	if route.Spec.TLS.CACertificate != "" {
+		route.Spec.TLS.CACertificate = ""
+		shouldUpdate = true
 	}
 	return shouldUpdate
 }
 
+// clearRouteDestCA removes the field populated from the destinationCA-from-secret secret
+func clearRouteDestCA(route *routev1.Route) bool {
+	if route.Spec.TLS.DestinationCACertificate == "" {
+		return false
+	}
+	route.Spec.TLS.DestinationCACertificate = ""
+	return true
+}
+
 func populateRouteDestCA(route *routev1.Route, secret *corev1.Secret) bool {
 	shouldUpdate := false
 	if value, ok := secret.Data[util.CA]; ok && len(value) != 0 {
@@ -0,0 +1,524 @@
+package route
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	routev1 "github.com/openshift/api/route/v1"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newTLSSecret(t *testing.T, name string, namespace string, annotations map[string]string) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		Type: "kubernetes.io/tls",
+		Data: map[string][]byte{},
+	}
+	for _, key := range []string{"tls.crt", "tls.key", "ca.crt"} {
+		data, err := ioutil.ReadFile("testdata/" + key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret.Data[key] = data
+	}
+	return secret
+}
+
+func TestRouteControllerCrossNamespaceSharedSecret(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: namespace,
+			Labels: map[string]string{
+				"team": "platform",
+			},
+		},
+	}
+	secret := newTLSSecret(t, "wildcard", "certificates", map[string]string{
+		"cert-utils-operator.redhat-cop.io/shared-with-namespace-selector": "team=platform",
+	})
+	route := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				"cert-utils-operator.redhat-cop.io/certs-from-secret":         "certificates/wildcard",
+				"cert-utils-operator.redhat-cop.io/destinationCA-from-secret": "certificates/wildcard",
+			},
+		},
+		Spec: routev1.RouteSpec{
+			Host: "www.example.com",
+			TLS: &routev1.TLSConfig{
+				Termination: routev1.TLSTerminationReencrypt,
+			},
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(routev1.AddToScheme(s))
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(ns, secret, route).Build()
+	r := &RouteCertificateReconciler{
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, record.NewFakeRecorder(3), nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("route_certificate_controller"),
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+
+	instance := &routev1.Route{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, string(secret.Data["tls.crt"]), instance.Spec.TLS.Certificate)
+	assert.Equal(t, string(secret.Data["tls.key"]), instance.Spec.TLS.Key)
+	assert.Equal(t, string(secret.Data["ca.crt"]), instance.Spec.TLS.DestinationCACertificate)
+
+	routes, err := (&enqueueRequestForReferecingRoutes{
+		Reader: cl,
+		log:    ctrl.Log.WithName("enqueueRequestForReferecingRoutes"),
+	}).matchSecret(cl, types.NamespacedName{
+		Name:      "wildcard",
+		Namespace: "certificates",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, routes, 1)
+}
+
+func TestRouteControllerCrossNamespaceSecretNotShared(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := newTLSSecret(t, "wildcard", "certificates", map[string]string{
+		"cert-utils-operator.redhat-cop.io/shared-with-namespaces": "app1, app2",
+	})
+	route := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				"cert-utils-operator.redhat-cop.io/certs-from-secret": "certificates/wildcard",
+			},
+		},
+		Spec: routev1.RouteSpec{
+			Host: "www.example.com",
+			TLS: &routev1.TLSConfig{
+				Termination: routev1.TLSTerminationReencrypt,
+			},
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(routev1.AddToScheme(s))
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(secret, route).Build()
+	recorder := record.NewFakeRecorder(3)
+	r := &RouteCertificateReconciler{
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, recorder, nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("route_certificate_controller"),
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	result, err := r.Reconcile(context.TODO(), req)
+	// the route is not requeued, it is reconciled again when the secret is shared with its namespace
+	assert.NoError(t, err)
+	assert.Equal(t, reconcile.Result{}, result)
+	assert.Equal(t, "Warning SecretNotShared secret certificates/wildcard is not shared with namespace cert-utils-operator", <-recorder.Events)
+
+	instance := &routev1.Route{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Empty(t, instance.Spec.TLS.Certificate)
+	assert.Empty(t, instance.Spec.TLS.Key)
+}
+
+func TestRouteControllerCertificateNotCoveringHost(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := newTLSSecret(t, "test1", namespace, nil)
+	route := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				"cert-utils-operator.redhat-cop.io/certs-from-secret": "test1",
+			},
+		},
+		Spec: routev1.RouteSpec{
+			Host: "www.example.com",
+			TLS: &routev1.TLSConfig{
+				Termination: routev1.TLSTerminationReencrypt,
+			},
+		},
+	}
+	route.Spec.Host = "www.example.org"
+
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(routev1.AddToScheme(s))
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(secret, route).Build()
+	r := &RouteCertificateReconciler{
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, record.NewFakeRecorder(3), nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("route_certificate_controller"),
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+
+	instance := &routev1.Route{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, string(secret.Data["tls.crt"]), instance.Spec.TLS.Certificate)
+	assert.Equal(t, "host www.example.org is not covered by the certificate", instance.GetAnnotations()["cert-utils-operator.redhat-cop.io/certs-validation-error"])
+	assert.Contains(t, <-r.GetRecorder().(*record.FakeRecorder).Events, "InvalidCertificate")
+
+	// once the host is fixed the validation error is removed
+	instance.Spec.Host = "app1.apps.example.com"
+	err = cl.Update(context.TODO(), instance)
+	assert.NoError(t, err)
+
+	result, err := r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.True(t, result.RequeueAfter > 0)
+
+	instance = &routev1.Route{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.NotContains(t, instance.GetAnnotations(), "cert-utils-operator.redhat-cop.io/certs-validation-error")
+}
+
+func TestRouteControllerStrictCertificateValidation(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := newTLSSecret(t, "test1", namespace, nil)
+	secret.Data["tls.key"] = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	route := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				"cert-utils-operator.redhat-cop.io/certs-from-secret":       "test1",
+				"cert-utils-operator.redhat-cop.io/strict-certs-validation": "true",
+			},
+		},
+		Spec: routev1.RouteSpec{
+			Host: "www.example.com",
+			TLS: &routev1.TLSConfig{
+				Termination: routev1.TLSTerminationReencrypt,
+			},
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(routev1.AddToScheme(s))
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(secret, route).Build()
+	r := &RouteCertificateReconciler{
+		ReconcilerBase: util.NewReconcilerBase(cl, s, nil, record.NewFakeRecorder(3), nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("route_certificate_controller"),
+	}
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err = r.Reconcile(context.TODO(), req)
+	assert.NoError(t, err)
+
+	instance := &routev1.Route{}
+	err = cl.Get(context.TODO(), req.NamespacedName, instance)
+	assert.NoError(t, err)
+	assert.Empty(t, instance.Spec.TLS.Certificate)
+	assert.Empty(t, instance.Spec.TLS.Key)
+	assert.Contains(t, instance.GetAnnotations()["cert-utils-operator.redhat-cop.io/certs-validation-error"], "key does not match the certificate")
+}
+
+func TestValidateRouteCertificateValidityWindow(t *testing.T) {
+	secret := newTLSSecret(t, "test1", "cert-utils-operator", nil)
+	route := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test1",
+			Namespace:   "cert-utils-operator",
+			Annotations: nil,
+		},
+		Spec: routev1.RouteSpec{
+			Host: "www.example.com",
+			TLS: &routev1.TLSConfig{
+				Termination: routev1.TLSTerminationReencrypt,
+			},
+		},
+	}
+
+	cert, err := validateRouteCertificate(route, secret, time.Now())
+	assert.NoError(t, err)
+
+	_, err = validateRouteCertificate(route, secret, cert.NotAfter.Add(time.Hour))
+	assert.EqualError(t, err, "certificate expired on "+cert.NotAfter.Format(time.RFC3339))
+
+	_, err = validateRouteCertificate(route, secret, cert.NotBefore.Add(-time.Hour))
+	assert.EqualError(t, err, "certificate is not valid before "+cert.NotBefore.Format(time.RFC3339))
+}
+
+func newCertificate(t *testing.T, commonName string, isCA bool, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if !isCA {
+		template.DNSNames = []string{commonName}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestBuildRouteChain(t *testing.T) {
+	root, rootKey := newCertificate(t, "root", true, nil, nil)
+	intermediate, intermediateKey := newCertificate(t, "intermediate", true, root, rootKey)
+	leaf, _ := newCertificate(t, "www.example.com", false, intermediate, intermediateKey)
+
+	// tls.crt in the wrong order, with duplicates and the root
+	certPEM := []byte(encodeCertificates([]*x509.Certificate{intermediate, leaf, leaf, root}))
+	caPEM := []byte(encodeCertificates([]*x509.Certificate{root, intermediate, root}))
+
+	certificate, caCertificate, err := buildRouteChain(certPEM, caPEM, nil, chainModeNormalize)
+	assert.NoError(t, err)
+	assert.Equal(t, encodeCertificates([]*x509.Certificate{leaf, intermediate}), certificate)
+	assert.Equal(t, encodeCertificates([]*x509.Certificate{root}), caCertificate)
+
+	// only the leaf in tls.crt, the intermediate is taken from ca.crt
+	certPEM = []byte(encodeCertificates([]*x509.Certificate{leaf}))
+
+	certificate, caCertificate, err = buildRouteChain(certPEM, caPEM, nil, chainModeNormalize)
+	assert.NoError(t, err)
+	assert.Equal(t, encodeCertificates([]*x509.Certificate{leaf}), certificate)
+	assert.Equal(t, encodeCertificates([]*x509.Certificate{root, intermediate}), caCertificate)
+
+	certificate, caCertificate, err = buildRouteChain(certPEM, caPEM, nil, chainModeFull)
+	assert.NoError(t, err)
+	assert.Equal(t, encodeCertificates([]*x509.Certificate{leaf, intermediate}), certificate)
+	assert.Equal(t, encodeCertificates([]*x509.Certificate{root}), caCertificate)
+
+	_, _, err = buildRouteChain(certPEM, caPEM, nil, "unknown")
+	assert.Error(t, err)
+}
+
+func TestValidateRouteCertificateChainMode(t *testing.T) {
+	now := time.Now()
+	root, rootKey := newCertificate(t, "root", true, nil, nil)
+	intermediate, intermediateKey := newCertificate(t, "intermediate", true, root, rootKey)
+	other, _ := newCertificate(t, "www.example.org", false, intermediate, intermediateKey)
+	leaf, leafKey := newCertificate(t, "www.example.com", false, intermediate, intermediateKey)
+
+	// tls.crt starts with the intermediate and another leaf, the leaf matching the key comes last
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "unordered",
+			Namespace: "cert-utils-operator",
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt": []byte(encodeCertificates([]*x509.Certificate{intermediate, other, leaf})),
+			"tls.key": pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(leafKey)}),
+		},
+	}
+	route := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cert-utils-operator",
+			Namespace: "cert-utils-operator",
+			Annotations: map[string]string{
+				chainModeAnnotation: chainModeNormalize,
+			},
+		},
+		Spec: routev1.RouteSpec{
+			Host: "www.example.com",
+			TLS: &routev1.TLSConfig{
+				Termination: routev1.TLSTerminationEdge,
+			},
+		},
+	}
+
+	// the leaf validated is the one served in the normalized chain
+	cert, err := validateRouteCertificate(route, secret, now)
+	assert.NoError(t, err)
+	assert.True(t, leaf.Equal(cert))
+	certificate, _, err := buildRouteChain(secret.Data["tls.crt"], nil, secret.Data["tls.key"], chainModeNormalize)
+	assert.NoError(t, err)
+	assert.Equal(t, encodeCertificates([]*x509.Certificate{leaf, intermediate}), certificate)
+
+	// in as-is mode the first certificate is served as the leaf
+	route.Annotations[chainModeAnnotation] = chainModeAsIs
+	cert, err = validateRouteCertificate(route, secret, now)
+	assert.Error(t, err)
+	assert.True(t, intermediate.Equal(cert))
+}
+
+func TestRouteControllerOnSecretDelete(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	tests := []struct {
+		policy                    string
+		expectedCertificate       bool
+		expectedDestinationCA     bool
+		expectedSecretDeleteEvent bool
+	}{
+		{policy: "keep", expectedCertificate: true, expectedDestinationCA: true, expectedSecretDeleteEvent: true},
+		{policy: "fallback-to-default", expectedDestinationCA: true, expectedSecretDeleteEvent: true},
+		{policy: "clear", expectedSecretDeleteEvent: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			secret := newTLSSecret(t, "test1", namespace, nil)
+			caSecret := newTLSSecret(t, "test2", namespace, nil)
+			route := &routev1.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					Annotations: map[string]string{
+						"cert-utils-operator.redhat-cop.io/certs-from-secret":         "test1",
+						"cert-utils-operator.redhat-cop.io/destinationCA-from-secret": "test2",
+						"cert-utils-operator.redhat-cop.io/on-secret-delete":          test.policy,
+					},
+				},
+				Spec: routev1.RouteSpec{
+					Host: "www.example.com",
+					TLS: &routev1.TLSConfig{
+						Termination: routev1.TLSTerminationReencrypt,
+					},
+				},
+			}
+
+			s := runtime.NewScheme()
+			utilruntime.Must(clientgoscheme.AddToScheme(s))
+			utilruntime.Must(routev1.AddToScheme(s))
+			cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(secret, caSecret, route).Build()
+			r := &RouteCertificateReconciler{
+				ReconcilerBase: util.NewReconcilerBase(cl, s, nil, record.NewFakeRecorder(3), nil),
+				Log:            ctrl.Log.WithName("controllers").WithName("route_certificate_controller"),
+			}
+
+			req := reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      name,
+					Namespace: namespace,
+				},
+			}
+
+			_, err := r.Reconcile(context.TODO(), req)
+			assert.NoError(t, err)
+
+			err = cl.Delete(context.TODO(), secret)
+			assert.NoError(t, err)
+
+			_, err = r.Reconcile(context.TODO(), req)
+			assert.NoError(t, err)
+
+			instance := &routev1.Route{}
+			err = cl.Get(context.TODO(), req.NamespacedName, instance)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedCertificate, instance.Spec.TLS.Certificate != "")
+			assert.Equal(t, test.expectedCertificate, instance.Spec.TLS.Key != "")
+			assert.Equal(t, test.expectedDestinationCA, instance.Spec.TLS.DestinationCACertificate != "")
+			if test.policy == "keep" {
+				assert.Equal(t, "referenced secrets test1 not found", instance.GetAnnotations()["cert-utils-operator.redhat-cop.io/certs-validation-error"])
+			}
+
+			events := r.GetRecorder().(*record.FakeRecorder).Events
+			secretDeleteEvent := false
+			for len(events) > 0 {
+				if strings.Contains(<-events, "SecretDeleted") {
+					secretDeleteEvent = true
+				}
+			}
+			assert.Equal(t, test.expectedSecretDeleteEvent, secretDeleteEvent)
+
+			// the deletion is reported only once
+			_, err = r.Reconcile(context.TODO(), req)
+			assert.NoError(t, err)
+			assert.Empty(t, events)
+		})
+	}
+}
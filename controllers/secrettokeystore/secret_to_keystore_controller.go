@@ -3,8 +3,15 @@ package secrettokeystore
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
@@ -23,6 +30,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 const javaKeyStoresAnnotation = util.AnnotationBase + "/generate-java-keystores"
@@ -31,6 +40,9 @@ const storesCreationTiemstamp = util.AnnotationBase + "/java-keystores-creation-
 const defaultpassword = "changeme"
 const keystoreName = "keystore.jks"
 const truststoreName = "truststore.jks"
+const pkcs12KeyStoresAnnotation = util.AnnotationBase + "/generate-pkcs12-keystores"
+const pkcs12KeystoreName = "keystore.p12"
+const pkcs12TruststoreName = "truststore.p12"
 
 // SecretToKeyStoreReconciler reconciles a Namespace object
 type SecretToKeyStoreReconciler struct {
@@ -56,20 +68,27 @@ func (r *SecretToKeyStoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			if newSecret.Type != util.TLSSecret {
 				return false
 			}
-			oldValue := e.ObjectOld.GetAnnotations()[javaKeyStoresAnnotation]
-			newValue := e.ObjectNew.GetAnnotations()[javaKeyStoresAnnotation]
-			old := oldValue == "true"
-			new := newValue == "true"
+			old := e.ObjectOld.GetAnnotations()[javaKeyStoresAnnotation] == "true"
+			new := e.ObjectNew.GetAnnotations()[javaKeyStoresAnnotation] == "true"
+			oldPKCS12 := e.ObjectOld.GetAnnotations()[pkcs12KeyStoresAnnotation] == "true"
+			newPKCS12 := e.ObjectNew.GetAnnotations()[pkcs12KeyStoresAnnotation] == "true"
+			// if the password has changed we trigger if the annotation is present
+			if e.ObjectOld.GetAnnotations()[keystorepasswordAnnotation] != e.ObjectNew.GetAnnotations()[keystorepasswordAnnotation] ||
+				e.ObjectOld.GetAnnotations()[util.KeystorePasswordSecretAnnotation] != e.ObjectNew.GetAnnotations()[util.KeystorePasswordSecretAnnotation] {
+				return new || newPKCS12
+			}
 			// if the content has changed we trigger if the annotation is present
 			if !reflect.DeepEqual(newSecret.Data[util.Cert], oldSecret.Data[util.Cert]) ||
 				!reflect.DeepEqual(newSecret.Data[util.Key], oldSecret.Data[util.Key]) ||
 				!reflect.DeepEqual(newSecret.Data[util.CA], oldSecret.Data[util.CA]) ||
 				!reflect.DeepEqual(newSecret.Data[keystoreName], oldSecret.Data[keystoreName]) ||
-				!reflect.DeepEqual(newSecret.Data[truststoreName], oldSecret.Data[truststoreName]) {
-				return new
+				!reflect.DeepEqual(newSecret.Data[truststoreName], oldSecret.Data[truststoreName]) ||
+				!reflect.DeepEqual(newSecret.Data[pkcs12KeystoreName], oldSecret.Data[pkcs12KeystoreName]) ||
+				!reflect.DeepEqual(newSecret.Data[pkcs12TruststoreName], oldSecret.Data[pkcs12TruststoreName]) {
+				return new || newPKCS12
 			}
-			// otherwise we trigger if the annotation has changed
-			return old != new
+			// otherwise we trigger if one of the annotations has changed
+			return old != new || oldPKCS12 != newPKCS12
 		},
 		CreateFunc: func(e event.CreateEvent) bool {
 			secret, ok := e.Object.(*corev1.Secret)
@@ -79,8 +98,8 @@ func (r *SecretToKeyStoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			if secret.Type != util.TLSSecret {
 				return false
 			}
-			value := e.Object.GetAnnotations()[javaKeyStoresAnnotation]
-			return value == "true"
+			return e.Object.GetAnnotations()[javaKeyStoresAnnotation] == "true" ||
+				e.Object.GetAnnotations()[pkcs12KeyStoresAnnotation] == "true"
 		},
 	}
 
@@ -90,6 +109,11 @@ func (r *SecretToKeyStoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				Kind: "Secret",
 			},
 		}, builder.WithPredicates(isAnnotatedSecret)).
+		Watches(&source.Kind{Type: &corev1.Secret{
+			TypeMeta: v1.TypeMeta{
+				Kind: "Secret",
+			},
+		}}, util.NewEnqueueRequestForReferencingPasswordSecret(mgr.GetClient(), &corev1.SecretList{}), builder.WithPredicates(util.IsPasswordSecretContentChanged)).
 		Complete(r)
 }
 
@@ -113,18 +137,28 @@ func (r *SecretToKeyStoreReconciler) Reconcile(context context.Context, req ctrl
 		// Error reading the object - requeue the request.
 		return reconcile.Result{}, err
 	}
-	//orig_instance := instance.DeepCopy()
-	value := instance.GetAnnotations()[javaKeyStoresAnnotation]
-	if value == "true" {
+	password := defaultpassword
+	if instance.GetAnnotations()[javaKeyStoresAnnotation] == "true" || instance.GetAnnotations()[pkcs12KeyStoresAnnotation] == "true" {
+		password, err = r.getPassword(instance)
+		if err != nil {
+			log.Error(err, "unable to retrieve keystore password", "secret", instance.Namespace+"/"+instance.Name)
+			return r.ManageError(context, instance, err)
+		}
+	}
+	original := instance.DeepCopy()
+	if instance.GetAnnotations()[javaKeyStoresAnnotation] == "true" {
 		if value, ok := instance.Data[util.Cert]; ok && len(value) != 0 {
 			if value, ok := instance.Data[util.Key]; ok && len(value) != 0 {
-				keyStore, err := r.getKeyStoreFromSecret(instance)
-				if err != nil {
+				keyStore, err := r.getKeyStoreFromSecret(instance, password)
+				var keyErr *invalidPrivateKeyError
+				if errors.As(err, &keyErr) {
+					log.Error(err, "unable to create keystore from secret", "secret", instance.Namespace+"/"+instance.Name)
+					r.GetRecorder().Event(instance, "Warning", keyErr.reason, err.Error())
+				} else if err != nil {
 					log.Error(err, "unable to create keystore from secret", "secret", instance.Namespace+"/"+instance.Name)
 					return reconcile.Result{}, err
-				}
-				if oldKeyStoreB, ok := instance.Data[keystoreName]; ok {
-					if !compareKeyStoreBinary(oldKeyStoreB, keyStore, []byte(getPassword(instance)), r.Log) {
+				} else if oldKeyStoreB, ok := instance.Data[keystoreName]; ok {
+					if !compareKeyStoreBinary(oldKeyStoreB, keyStore, []byte(password), r.Log) {
 						instance.Data[keystoreName] = keyStore
 					}
 				} else {
@@ -133,28 +167,52 @@ func (r *SecretToKeyStoreReconciler) Reconcile(context context.Context, req ctrl
 			}
 		}
 		if value, ok := instance.Data[util.CA]; ok && len(value) != 0 {
-			trustStore, err := r.getTrustStoreFromSecret(instance)
+			trustStore, err := r.getTrustStoreFromSecret(instance, password)
 			if err != nil {
 				log.Error(err, "unable to create truststore from secret", "secret", instance.Namespace+"/"+instance.Name)
 				return reconcile.Result{}, err
 			}
-			if oldTrustStoreB, ok := instance.Data[truststoreName]; ok {
-				if !compareKeyStoreBinary(oldTrustStoreB, trustStore, []byte(getPassword(instance)), r.Log) {
-					instance.Data[truststoreName] = trustStore
-				}
+			if oldTrustStoreB, ok := instance.Data[truststoreName]; !ok || !compareKeyStoreBinary(oldTrustStoreB, trustStore, []byte(password), r.Log) {
+				instance.Data[truststoreName] = trustStore
 			}
-			instance.Data[truststoreName] = trustStore
 		}
 	} else {
 		delete(instance.Data, keystoreName)
 		delete(instance.Data, truststoreName)
 	}
+	if instance.GetAnnotations()[pkcs12KeyStoresAnnotation] == "true" {
+		if value, ok := instance.Data[util.Cert]; ok && len(value) != 0 {
+			if value, ok := instance.Data[util.Key]; ok && len(value) != 0 {
+				keyStore, err := getPKCS12KeyStoreFromSecret(instance, password)
+				var keyErr *invalidPrivateKeyError
+				if errors.As(err, &keyErr) {
+					log.Error(err, "unable to create pkcs12 keystore from secret", "secret", instance.Namespace+"/"+instance.Name)
+					r.GetRecorder().Event(instance, "Warning", keyErr.reason, err.Error())
+				} else if err != nil {
+					log.Error(err, "unable to create pkcs12 keystore from secret", "secret", instance.Namespace+"/"+instance.Name)
+					return reconcile.Result{}, err
+				} else if oldKeyStoreB, ok := instance.Data[pkcs12KeystoreName]; !ok || !comparePKCS12KeyStoreBinary(oldKeyStoreB, keyStore, password, r.Log) {
+					instance.Data[pkcs12KeystoreName] = keyStore
+				}
+			}
+		}
+		if value, ok := instance.Data[util.CA]; ok && len(value) != 0 {
+			trustStore, err := getPKCS12TrustStoreFromSecret(instance, password)
+			if err != nil {
+				log.Error(err, "unable to create pkcs12 truststore from secret", "secret", instance.Namespace+"/"+instance.Name)
+				return reconcile.Result{}, err
+			}
+			if oldTrustStoreB, ok := instance.Data[pkcs12TruststoreName]; !ok || !comparePKCS12TrustStoreBinary(oldTrustStoreB, trustStore, password, r.Log) {
+				instance.Data[pkcs12TruststoreName] = trustStore
+			}
+		}
+	} else {
+		delete(instance.Data, pkcs12KeystoreName)
+		delete(instance.Data, pkcs12TruststoreName)
+	}
 
-	//client.StrategicMergeFrom(orig_instance)
-	//log.V(1).Info("about to patch object", "data len", len(instance.Data), "orig data len", len(orig_instance.Data))
-	//err = r.GetClient().Patch(context, instance, client.StrategicMergeFrom(instance))
-	log.V(1).Info("updating with", "instance", instance, "key len", len(instance.Data), "resource version", instance.GetResourceVersion())
-	err = r.GetClient().Update(context, instance)
+	log.V(1).Info("patching keystores", "key len", len(instance.Data), "original key len", len(original.Data))
+	_, err = util.PatchOwnedFieldsIfChanged(context, r.GetClient(), r.controllerName, instance, original)
 	if err != nil {
 		log.Error(err, "unable to update secret", "secret", instance.GetName())
 		return r.ManageError(context, instance, err)
@@ -226,7 +284,7 @@ func compareKeyStore(a, b keystore.KeyStore, password []byte, flog logr.Logger)
 	return true
 }
 
-func (r *SecretToKeyStoreReconciler) getKeyStoreFromSecret(secret *corev1.Secret) ([]byte, error) {
+func (r *SecretToKeyStoreReconciler) getKeyStoreFromSecret(secret *corev1.Secret, password string) ([]byte, error) {
 	keyStore := keystore.New()
 	key, ok := secret.Data[util.Key]
 	if !ok {
@@ -243,12 +301,21 @@ func (r *SecretToKeyStoreReconciler) getKeyStoreFromSecret(secret *corev1.Secret
 			Content: p.Bytes,
 		})
 	}
-	p, _ := pem.Decode(key)
-	if p == nil {
-		return []byte{}, errors.New("no block found in key.tls, private key should have at least one pem block")
+	if len(certs) == 0 {
+		return []byte{}, errors.New("no certificate found in tls.crt")
 	}
-	if !strings.Contains(p.Type, "PRIVATE KEY") {
-		return []byte{}, errors.New("private key block not of type PRIVATE KEY")
+	leaf, err := x509.ParseCertificate(certs[0].Content)
+	if err != nil {
+		return []byte{}, err
+	}
+	privateKey, err := getPrivateKey(key, leaf)
+	if err != nil {
+		return []byte{}, err
+	}
+	// java keystores require the private key to be in PKCS#8 format
+	pkcs8Key, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return []byte{}, err
 	}
 
 	creationTime, err := r.getCreationTimestamp(secret)
@@ -260,9 +327,9 @@ func (r *SecretToKeyStoreReconciler) getKeyStoreFromSecret(secret *corev1.Secret
 
 	err = keyStore.SetPrivateKeyEntry("alias", keystore.PrivateKeyEntry{
 		CreationTime:     creationTime,
-		PrivateKey:       p.Bytes,
+		PrivateKey:       pkcs8Key,
 		CertificateChain: certs,
-	}, []byte(getPassword(secret)))
+	}, []byte(password))
 
 	if err != nil {
 		r.Log.Error(err, "unable to set private key entry")
@@ -270,7 +337,7 @@ func (r *SecretToKeyStoreReconciler) getKeyStoreFromSecret(secret *corev1.Secret
 	}
 
 	buffer := bytes.Buffer{}
-	err = keyStore.Store(&buffer, []byte(getPassword(secret)))
+	err = keyStore.Store(&buffer, []byte(password))
 	if err != nil {
 		r.Log.Error(err, "unable to encode", "keystore", keyStore)
 		return []byte{}, err
@@ -278,7 +345,7 @@ func (r *SecretToKeyStoreReconciler) getKeyStoreFromSecret(secret *corev1.Secret
 	return buffer.Bytes(), nil
 }
 
-func (r *SecretToKeyStoreReconciler) getTrustStoreFromSecret(secret *corev1.Secret) ([]byte, error) {
+func (r *SecretToKeyStoreReconciler) getTrustStoreFromSecret(secret *corev1.Secret, password string) ([]byte, error) {
 	keyStore := keystore.New()
 	ca, ok := secret.Data[util.CA]
 	if !ok {
@@ -307,7 +374,7 @@ func (r *SecretToKeyStoreReconciler) getTrustStoreFromSecret(secret *corev1.Secr
 		i++
 	}
 	buffer := bytes.Buffer{}
-	err = keyStore.Store(&buffer, []byte(getPassword(secret)))
+	err = keyStore.Store(&buffer, []byte(password))
 	if err != nil {
 		r.Log.Error(err, "unable to encode ", "truststore", keyStore)
 		return []byte{}, err
@@ -315,11 +382,14 @@ func (r *SecretToKeyStoreReconciler) getTrustStoreFromSecret(secret *corev1.Secr
 	return buffer.Bytes(), nil
 }
 
-func getPassword(secret *corev1.Secret) string {
+func (r *SecretToKeyStoreReconciler) getPassword(secret *corev1.Secret) (string, error) {
+	if _, ok := secret.GetAnnotations()[util.KeystorePasswordSecretAnnotation]; ok {
+		return util.GetKeystorePasswordFromSecret(r.GetClient(), secret)
+	}
 	if pwd, ok := secret.GetAnnotations()[keystorepasswordAnnotation]; ok && pwd != "" {
-		return pwd
+		return pwd, nil
 	}
-	return defaultpassword
+	return defaultpassword, nil
 }
 
 func (r *SecretToKeyStoreReconciler) getCreationTimestamp(secret *corev1.Secret) (time.Time, error) {
@@ -337,3 +407,174 @@ func (r *SecretToKeyStoreReconciler) getCreationTimestamp(secret *corev1.Secret)
 		return now, nil
 	}
 }
+
+func getPKCS12KeyStoreFromSecret(secret *corev1.Secret, password string) ([]byte, error) {
+	key, ok := secret.Data[util.Key]
+	if !ok {
+		return []byte{}, errors.New("tls.key not found")
+	}
+	crt, ok := secret.Data[util.Cert]
+	if !ok {
+		return []byte{}, errors.New("tls.crt not found")
+	}
+	certs, err := parseCertificates(crt)
+	if err != nil {
+		return []byte{}, err
+	}
+	if len(certs) == 0 {
+		return []byte{}, errors.New("no certificate found in tls.crt")
+	}
+	privateKey, err := getPrivateKey(key, certs[0])
+	if err != nil {
+		return []byte{}, err
+	}
+	return pkcs12.Encode(rand.Reader, privateKey, certs[0], certs[1:], password)
+}
+
+func getPKCS12TrustStoreFromSecret(secret *corev1.Secret, password string) ([]byte, error) {
+	ca, ok := secret.Data[util.CA]
+	if !ok {
+		return []byte{}, errors.New("ca bundle key not found: ca.crt")
+	}
+	certs, err := parseCertificates(ca)
+	if err != nil {
+		return []byte{}, err
+	}
+	return pkcs12.EncodeTrustStore(rand.Reader, certs, password)
+}
+
+// comparePKCS12KeyStoreBinary returns true if the two PKCS#12 keystores contain the same key and certificate chain.
+// PKCS#12 files are encrypted with a random salt, so two encodings of the same content never match byte by byte.
+func comparePKCS12KeyStoreBinary(a, b []byte, password string, flog logr.Logger) bool {
+	aKey, aCert, aCAs, err := pkcs12.DecodeChain(a, password)
+	if err != nil {
+		flog.Error(err, "unable to load pkcs12 keystore")
+		return false
+	}
+	bKey, bCert, bCAs, err := pkcs12.DecodeChain(b, password)
+	if err != nil {
+		flog.Error(err, "unable to load pkcs12 keystore")
+		return false
+	}
+	aKeyB, err := x509.MarshalPKCS8PrivateKey(aKey)
+	if err != nil {
+		flog.Error(err, "unable to marshal private key")
+		return false
+	}
+	bKeyB, err := x509.MarshalPKCS8PrivateKey(bKey)
+	if err != nil {
+		flog.Error(err, "unable to marshal private key")
+		return false
+	}
+	return bytes.Equal(aKeyB, bKeyB) && compareCertificates(append([]*x509.Certificate{aCert}, aCAs...), append([]*x509.Certificate{bCert}, bCAs...))
+}
+
+// comparePKCS12TrustStoreBinary returns true if the two PKCS#12 truststores contain the same certificates.
+func comparePKCS12TrustStoreBinary(a, b []byte, password string, flog logr.Logger) bool {
+	aCerts, err := pkcs12.DecodeTrustStore(a, password)
+	if err != nil {
+		flog.Error(err, "unable to load pkcs12 truststore")
+		return false
+	}
+	bCerts, err := pkcs12.DecodeTrustStore(b, password)
+	if err != nil {
+		flog.Error(err, "unable to load pkcs12 truststore")
+		return false
+	}
+	return compareCertificates(aCerts, bCerts)
+}
+
+func compareCertificates(a, b []*x509.Certificate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCertificates(pemCerts []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for p, rest := pem.Decode(pemCerts); p != nil; p, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// invalidPrivateKeyError is returned when tls.key cannot be used to build a keystore.
+// Retrying does not help with this kind of error, the secret has to be fixed.
+type invalidPrivateKeyError struct {
+	reason  string
+	message string
+}
+
+func (e *invalidPrivateKeyError) Error() string {
+	return e.message
+}
+
+// getPrivateKey parses the PEM encoded private key and verifies that it is supported and that it matches the leaf certificate.
+func getPrivateKey(keyPEM []byte, leaf *x509.Certificate) (crypto.PrivateKey, error) {
+	var p *pem.Block
+	// openssl may prepend an EC PARAMETERS block to the key, so we look for the first private key block
+	for {
+		p, keyPEM = pem.Decode(keyPEM)
+		if p == nil || strings.HasSuffix(p.Type, "PRIVATE KEY") {
+			break
+		}
+	}
+	if p == nil {
+		return nil, &invalidPrivateKeyError{
+			reason:  "InvalidPrivateKey",
+			message: "no private key block found in tls.key",
+		}
+	}
+	privateKey, err := parsePrivateKey(p.Bytes)
+	if err != nil {
+		return nil, &invalidPrivateKeyError{
+			reason:  "UnsupportedPrivateKey",
+			message: err.Error(),
+		}
+	}
+	var publicKey crypto.PublicKey
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		publicKey = &key.PublicKey
+	case *ecdsa.PrivateKey:
+		publicKey = &key.PublicKey
+	case ed25519.PrivateKey:
+		publicKey = key.Public()
+	default:
+		return nil, &invalidPrivateKeyError{
+			reason:  "UnsupportedPrivateKey",
+			message: fmt.Sprintf("unsupported private key type %T, supported types are RSA, ECDSA and Ed25519", privateKey),
+		}
+	}
+	if key, ok := publicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !key.Equal(leaf.PublicKey) {
+		return nil, &invalidPrivateKeyError{
+			reason:  "PrivateKeyMismatch",
+			message: "the private key in tls.key does not match the public key of the certificate in tls.crt",
+		}
+	}
+	return privateKey, nil
+}
+
+// parsePrivateKey parses a DER encoded private key in PKCS#8, PKCS#1 or SEC1 format.
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unable to parse private key, supported formats are PKCS#8, PKCS#1 and SEC1")
+}
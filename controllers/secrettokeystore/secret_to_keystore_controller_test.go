@@ -0,0 +1,363 @@
+package secrettokeystore
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"testing"
+
+	keystore "github.com/pavel-v-chernykh/keystore-go/v4"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubectl/pkg/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func newTLSSecret(t *testing.T, name string, namespace string, annotations map[string]string) *corev1.Secret {
+	data := map[string][]byte{}
+	for _, key := range []string{"tls.crt", "tls.key", "ca.crt"} {
+		value, err := ioutil.ReadFile("testdata/" + key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[key] = value
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		Type: "kubernetes.io/tls",
+		Data: data,
+	}
+}
+
+func TestSecretControllerCreatePKCS12Stores(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := newTLSSecret(t, name, namespace, map[string]string{
+		"cert-utils-operator.redhat-cop.io/generate-pkcs12-keystores": "true",
+		"cert-utils-operator.redhat-cop.io/java-keystore-password":    "secret",
+	})
+	cl := fake.NewFakeClient(secret)
+	r := &SecretToKeyStoreReconciler{
+		ReconcilerBase: util.NewReconcilerBase(cl, scheme.Scheme, nil, record.NewFakeRecorder(3), nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("secret_to_keystore_controller"),
+	}
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	outSecret := &corev1.Secret{}
+	cl.Get(context.TODO(), req.NamespacedName, outSecret)
+
+	assert.NotContains(t, outSecret.Data, "keystore.jks")
+	assert.NotContains(t, outSecret.Data, "truststore.jks")
+
+	key, cert, cas, err := pkcs12.DecodeChain(outSecret.Data["keystore.p12"], "secret")
+	assert.Nil(t, err, "error reading generated keystore")
+	assert.NotNil(t, key)
+	expectedCerts, err := parseCertificates(secret.Data["tls.crt"])
+	assert.Nil(t, err)
+	assert.True(t, cert.Equal(expectedCerts[0]))
+	assert.Equal(t, len(expectedCerts)-1, len(cas))
+
+	trustedCerts, err := pkcs12.DecodeTrustStore(outSecret.Data["truststore.p12"], "secret")
+	assert.Nil(t, err, "error reading generated truststore")
+	expectedCAs, err := parseCertificates(secret.Data["ca.crt"])
+	assert.Nil(t, err)
+	assert.True(t, compareCertificates(expectedCAs, trustedCerts))
+}
+
+func TestSecretControllerPKCS12StoresAreStable(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := newTLSSecret(t, name, namespace, map[string]string{
+		"cert-utils-operator.redhat-cop.io/generate-pkcs12-keystores": "true",
+	})
+	cl := fake.NewFakeClient(secret)
+	r := &SecretToKeyStoreReconciler{
+		ReconcilerBase: util.NewReconcilerBase(cl, scheme.Scheme, nil, record.NewFakeRecorder(3), nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("secret_to_keystore_controller"),
+	}
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	outSecret := &corev1.Secret{}
+	cl.Get(context.TODO(), req.NamespacedName, outSecret)
+
+	_, err = r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	outSecret2 := &corev1.Secret{}
+	cl.Get(context.TODO(), req.NamespacedName, outSecret2)
+
+	// pkcs12 encoding is salted, so an unchanged secret must keep the first encoding
+	assert.Equal(t, outSecret.Data["keystore.p12"], outSecret2.Data["keystore.p12"])
+	assert.Equal(t, outSecret.Data["truststore.p12"], outSecret2.Data["truststore.p12"])
+}
+
+func TestSecretControllerRemovesPKCS12Stores(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := newTLSSecret(t, name, namespace, map[string]string{
+		"cert-utils-operator.redhat-cop.io/generate-pkcs12-keystores": "true",
+	})
+	cl := fake.NewFakeClient(secret)
+	r := &SecretToKeyStoreReconciler{
+		ReconcilerBase: util.NewReconcilerBase(cl, scheme.Scheme, nil, record.NewFakeRecorder(3), nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("secret_to_keystore_controller"),
+	}
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	outSecret := &corev1.Secret{}
+	cl.Get(context.TODO(), req.NamespacedName, outSecret)
+	assert.Contains(t, outSecret.Data, "keystore.p12")
+
+	outSecret.Annotations["cert-utils-operator.redhat-cop.io/generate-pkcs12-keystores"] = "false"
+	err = cl.Update(context.TODO(), outSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	outSecret = &corev1.Secret{}
+	cl.Get(context.TODO(), req.NamespacedName, outSecret)
+	assert.NotContains(t, outSecret.Data, "keystore.p12")
+	assert.NotContains(t, outSecret.Data, "truststore.p12")
+}
+
+func TestSecretControllerNormalizesPKCS1Key(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := newTLSSecret(t, name, namespace, map[string]string{
+		"cert-utils-operator.redhat-cop.io/generate-java-keystores": "true",
+	})
+	p, _ := pem.Decode(secret.Data["tls.key"])
+	key, err := x509.ParsePKCS8PrivateKey(p.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Data["tls.key"] = pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key.(*rsa.PrivateKey)),
+	})
+	cl := fake.NewFakeClient(secret)
+	r := &SecretToKeyStoreReconciler{
+		ReconcilerBase: util.NewReconcilerBase(cl, scheme.Scheme, nil, record.NewFakeRecorder(3), nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("secret_to_keystore_controller"),
+	}
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err = r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	outSecret := &corev1.Secret{}
+	cl.Get(context.TODO(), req.NamespacedName, outSecret)
+
+	ks := keystore.New()
+	err = ks.Load(bytes.NewReader(outSecret.Data["keystore.jks"]), []byte("changeme"))
+	assert.Nil(t, err, "error reading generated keystore")
+	entry, err := ks.GetPrivateKeyEntry("alias", []byte("changeme"))
+	assert.Nil(t, err, "error reading private key entry")
+	// the keystore must contain the PKCS#8 encoding of the key
+	assert.Equal(t, p.Bytes, entry.PrivateKey)
+}
+
+func TestSecretControllerRejectsMismatchedKey(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	secret := newTLSSecret(t, name, namespace, map[string]string{
+		"cert-utils-operator.redhat-cop.io/generate-java-keystores": "true",
+	})
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Data["tls.key"] = pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: der,
+	})
+	cl := fake.NewFakeClient(secret)
+	r := &SecretToKeyStoreReconciler{
+		ReconcilerBase: util.NewReconcilerBase(cl, scheme.Scheme, nil, record.NewFakeRecorder(3), nil),
+		Log:            ctrl.Log.WithName("controllers").WithName("secret_to_keystore_controller"),
+	}
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	_, err = r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	outSecret := &corev1.Secret{}
+	cl.Get(context.TODO(), req.NamespacedName, outSecret)
+
+	assert.NotContains(t, outSecret.Data, "keystore.jks")
+	// the truststore does not depend on the key and is still generated
+	assert.Contains(t, outSecret.Data, "truststore.jks")
+	recorder := r.GetRecorder().(*record.FakeRecorder)
+	assert.Contains(t, <-recorder.Events, "PrivateKeyMismatch")
+}
+
+func TestSecretControllerPasswordFromSecret(t *testing.T) {
+	var (
+		name      = "cert-utils-operator"
+		namespace = "cert-utils-operator"
+	)
+
+	tests := []struct {
+		name           string
+		passwordSecret *corev1.Secret
+		expectedError  string
+	}{
+		{
+			name: "referenced secret",
+			passwordSecret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "keystore-password",
+					Namespace: namespace,
+				},
+				Data: map[string][]byte{
+					"password": []byte("secret-password"),
+				},
+			},
+		},
+		{
+			name:          "missing secret",
+			expectedError: "not found",
+		},
+		{
+			name: "missing key",
+			passwordSecret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "keystore-password",
+					Namespace: namespace,
+				},
+				Data: map[string][]byte{
+					"other": []byte("secret-password"),
+				},
+			},
+			expectedError: "key password not found or empty in keystore password secret keystore-password",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the password secret takes precedence over the password annotation
+			secret := newTLSSecret(t, name, namespace, map[string]string{
+				"cert-utils-operator.redhat-cop.io/generate-java-keystores":       "true",
+				"cert-utils-operator.redhat-cop.io/java-keystore-password":        "annotation-password",
+				"cert-utils-operator.redhat-cop.io/java-keystore-password-secret": "keystore-password/password",
+			})
+			objs := []runtime.Object{secret}
+			if test.passwordSecret != nil {
+				objs = append(objs, test.passwordSecret)
+			}
+			cl := fake.NewFakeClient(objs...)
+			fakeRecorder := record.NewFakeRecorder(3)
+			r := &SecretToKeyStoreReconciler{
+				ReconcilerBase: util.NewReconcilerBase(cl, scheme.Scheme, nil, fakeRecorder, nil),
+				Log:            ctrl.Log.WithName("controllers").WithName("secret_to_keystore_controller"),
+			}
+			req := reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      name,
+					Namespace: namespace,
+				},
+			}
+
+			_, err := r.Reconcile(context.TODO(), req)
+			outSecret := &corev1.Secret{}
+			cl.Get(context.TODO(), req.NamespacedName, outSecret)
+
+			if test.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedError)
+				// no keystore is generated with a fallback password
+				assert.NotContains(t, outSecret.Data, "keystore.jks")
+				assert.NotContains(t, outSecret.Data, "truststore.jks")
+				assert.Contains(t, <-fakeRecorder.Events, test.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			ks := keystore.New()
+			err = ks.Load(bytes.NewReader(outSecret.Data["keystore.jks"]), []byte("secret-password"))
+			assert.Nil(t, err, "error reading generated keystore")
+			_, err = ks.GetPrivateKeyEntry("alias", []byte("secret-password"))
+			assert.Nil(t, err, "error reading private key entry")
+		})
+	}
+}
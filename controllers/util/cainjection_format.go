@@ -0,0 +1,137 @@
+package util
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// CertAnnotationTargetKey is a comma separated list of the keys in which the ca bundle is injected in secrets and configmaps, ca.crt by default
+const CertAnnotationTargetKey = AnnotationBase + "/injectca-target-key"
+
+// CertAnnotationFormat is the format of the ca bundle injected in secrets and configmaps, one of pem, der, jks and p12, pem by default
+const CertAnnotationFormat = AnnotationBase + "/injectca-format"
+
+// KeystorePasswordAnnotation is the password of the java keystores generated by the operator
+const KeystorePasswordAnnotation = AnnotationBase + "/java-keystore-password"
+
+// DefaultKeystorePassword is the password of the java keystores generated by the operator when no password is configured
+const DefaultKeystorePassword = "changeme"
+
+const (
+	CAFormatPEM    = "pem"
+	CAFormatDER    = "der"
+	CAFormatJKS    = "jks"
+	CAFormatPKCS12 = "p12"
+)
+
+// GetCATargetKeys returns the keys in which the ca bundle is injected in obj
+func GetCATargetKeys(obj client.Object) []string {
+	keys := splitReferences(obj.GetAnnotations()[CertAnnotationTargetKey])
+	if len(keys) == 0 {
+		return []string{CA}
+	}
+	return keys
+}
+
+// GetCAFormat returns the format of the ca bundle injected in obj
+func GetCAFormat(obj client.Object) (string, error) {
+	format, ok := obj.GetAnnotations()[CertAnnotationFormat]
+	if !ok || format == "" {
+		return CAFormatPEM, nil
+	}
+	switch format {
+	case CAFormatPEM, CAFormatDER, CAFormatJKS, CAFormatPKCS12:
+		return format, nil
+	}
+	return "", errors.New("unsupported ca bundle format " + format + ", it must be one of pem, der, jks, p12")
+}
+
+// IsBinaryCAFormat returns whether the ca bundle in format must be stored in the binary data of a configmap
+func IsBinaryCAFormat(format string) bool {
+	return format != CAFormatPEM
+}
+
+// GetKeystorePassword returns the password of the truststores generated for obj, read from either the java-keystore-password-secret
+// or the java-keystore-password annotations
+func GetKeystorePassword(c client.Client, obj client.Object) (string, error) {
+	if _, ok := obj.GetAnnotations()[KeystorePasswordSecretAnnotation]; ok {
+		return GetKeystorePasswordFromSecret(c, obj)
+	}
+	if password, ok := obj.GetAnnotations()[KeystorePasswordAnnotation]; ok && password != "" {
+		return password, nil
+	}
+	return DefaultKeystorePassword, nil
+}
+
+// EncodeCABundle converts the pem ca bundle to format. der bundles are the concatenation of the der encoded certificates.
+// An empty bundle is encoded as an empty value in every format.
+func EncodeCABundle(caBundle []byte, format string, password string) ([]byte, error) {
+	if len(caBundle) == 0 {
+		return []byte{}, nil
+	}
+	switch format {
+	case CAFormatPEM:
+		return caBundle, nil
+	case CAFormatDER:
+		der := []byte{}
+		for p, rest := pem.Decode(caBundle); p != nil; p, rest = pem.Decode(rest) {
+			der = append(der, p.Bytes...)
+		}
+		return der, nil
+	case CAFormatJKS:
+		return NewJKSTrustStore(caBundle, password)
+	case CAFormatPKCS12:
+		certs, err := parseCABundle(caBundle)
+		if err != nil {
+			return []byte{}, err
+		}
+		return pkcs12.EncodeTrustStore(rand.Reader, certs, password)
+	}
+	return []byte{}, errors.New("unsupported ca bundle format " + format)
+}
+
+// IsEncodedCABundleEqual returns whether current contains the same certificates as encoded, which was returned by EncodeCABundle.
+// PKCS#12 truststores are encrypted with a random salt and java truststores contain the creation time of their entries, so they are compared by content.
+func IsEncodedCABundleEqual(current []byte, encoded []byte, format string, password string) bool {
+	if format == CAFormatJKS {
+		return isJKSTrustStoreEqual(current, encoded, password)
+	}
+	if format != CAFormatPKCS12 {
+		return bytes.Equal(current, encoded)
+	}
+	currentCerts, err := pkcs12.DecodeTrustStore(current, password)
+	if err != nil {
+		return false
+	}
+	encodedCerts, err := pkcs12.DecodeTrustStore(encoded, password)
+	if err != nil {
+		return false
+	}
+	if len(currentCerts) != len(encodedCerts) {
+		return false
+	}
+	for i := range currentCerts {
+		if !currentCerts[i].Equal(encodedCerts[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCABundle(caBundle []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for p, rest := pem.Decode(caBundle); p != nil; p, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	keystore "github.com/pavlo-v-chernykh/keystore-go/v4"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetCATargetKeysAndFormat(t *testing.T) {
+	obj := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{},
+		},
+	}
+	assert.Equal(t, []string{"ca.crt"}, GetCATargetKeys(obj))
+	format, err := GetCAFormat(obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "pem", format)
+
+	obj.Annotations[CertAnnotationTargetKey] = "ca-bundle.crt,tls-ca-bundle.pem"
+	obj.Annotations[CertAnnotationFormat] = "jks"
+	assert.Equal(t, []string{"ca-bundle.crt", "tls-ca-bundle.pem"}, GetCATargetKeys(obj))
+	format, err = GetCAFormat(obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "jks", format)
+
+	obj.Annotations[CertAnnotationFormat] = "pkcs7"
+	_, err = GetCAFormat(obj)
+	assert.Error(t, err)
+}
+
+func TestEncodeCABundle(t *testing.T) {
+	now := time.Now()
+	caBundle := []byte(newTestCA(t, "ca-1", now.Add(-time.Hour), now.Add(time.Hour)) + newTestCA(t, "ca-2", now.Add(-time.Hour), now.Add(time.Hour)))
+
+	for _, format := range []string{CAFormatPEM, CAFormatDER, CAFormatJKS, CAFormatPKCS12} {
+		empty, err := EncodeCABundle([]byte{}, format, DefaultKeystorePassword)
+		assert.NoError(t, err)
+		assert.Empty(t, empty, format)
+
+		encoded, err := EncodeCABundle(caBundle, format, DefaultKeystorePassword)
+		assert.NoError(t, err)
+		again, err := EncodeCABundle(caBundle, format, DefaultKeystorePassword)
+		assert.NoError(t, err)
+		assert.True(t, IsEncodedCABundleEqual(again, encoded, format, DefaultKeystorePassword), format)
+		other, err := EncodeCABundle(caBundle[:len(caBundle)/2+1], format, DefaultKeystorePassword)
+		if err == nil {
+			assert.False(t, IsEncodedCABundleEqual(other, encoded, format, DefaultKeystorePassword), format)
+		}
+	}
+
+	der, err := EncodeCABundle(caBundle, CAFormatDER, "")
+	assert.NoError(t, err)
+	certs, err := parseCABundle(caBundle)
+	assert.NoError(t, err)
+	assert.Equal(t, append(certs[0].Raw, certs[1].Raw...), der)
+
+	jks, err := EncodeCABundle(caBundle, CAFormatJKS, "secret")
+	assert.NoError(t, err)
+	keyStore := keystore.New()
+	err = keyStore.Load(bytes.NewReader(jks), []byte("secret"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{TrustStoreAlias(certs[0]), TrustStoreAlias(certs[1])}, keyStore.Aliases())
+}
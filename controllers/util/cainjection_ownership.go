@@ -0,0 +1,88 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CertAnnotationInjectedFields marks the fields in which the operator injected a ca bundle. Its value is a json object mapping every injected
+// field to its value before the first injection, or null if the field was not set, so that the injection can be reverted when it is disabled.
+const CertAnnotationInjectedFields = AnnotationBase + "/injectca-managed-fields"
+
+// InjectedFields maps the fields in which the operator injected a ca bundle to their value before the injection, nil if they were not set
+type InjectedFields map[string][]byte
+
+// IsCAInjectionEnabled returns whether obj has one of the annotations that enable the ca injection
+func IsCAInjectionEnabled(obj client.Object) bool {
+	for _, annotation := range []string{CertAnnotationSecret, CertAnnotationConfigMap, CertAnnotationServiceCA} {
+		if _, ok := obj.GetAnnotations()[annotation]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GetInjectedFields returns the fields of obj in which the operator injected a ca bundle, as recorded in the injectca-managed-fields annotation
+func GetInjectedFields(obj client.Object) (InjectedFields, error) {
+	fields := InjectedFields{}
+	value, ok := obj.GetAnnotations()[CertAnnotationInjectedFields]
+	if !ok || value == "" {
+		return fields, nil
+	}
+	err := json.Unmarshal([]byte(value), &fields)
+	if err != nil {
+		log.Error(err, "unable to parse injected fields annotation", "annotation", CertAnnotationInjectedFields, "value", value)
+		return InjectedFields{}, err
+	}
+	return fields, nil
+}
+
+// Track marks field as injected. If the field was not marked yet, current is recorded as its original value, nil meaning that the field is not set.
+// A current value equal to the injected one was left by an injection performed before the field was tracked, so it is recorded as not set.
+func (f InjectedFields) Track(field string, current []byte, injected []byte) {
+	if _, ok := f[field]; ok {
+		return
+	}
+	if current != nil && len(injected) != 0 && bytes.Equal(current, injected) {
+		current = nil
+	}
+	f[field] = current
+}
+
+// Release stops tracking the fields that are not in keep. It returns the released fields with their original value, which must be restored.
+func (f InjectedFields) Release(keep ...string) InjectedFields {
+	kept := map[string]bool{}
+	for _, field := range keep {
+		kept[field] = true
+	}
+	released := InjectedFields{}
+	for field, original := range f {
+		if !kept[field] {
+			released[field] = original
+			delete(f, field)
+		}
+	}
+	return released
+}
+
+// SetInjectedFields records fields in the injectca-managed-fields annotation of obj, removing the annotation if there are no fields
+func SetInjectedFields(obj client.Object, fields InjectedFields) error {
+	annotations := obj.GetAnnotations()
+	if len(fields) == 0 {
+		delete(annotations, CertAnnotationInjectedFields)
+		obj.SetAnnotations(annotations)
+		return nil
+	}
+	value, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[CertAnnotationInjectedFields] = string(value)
+	obj.SetAnnotations(annotations)
+	return nil
+}
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestInjectedFields(t *testing.T) {
+	obj := &corev1.ConfigMap{}
+	fields, err := GetInjectedFields(obj)
+	assert.NoError(t, err)
+	assert.Empty(t, fields)
+
+	fields.Track("data.a", []byte("original"), []byte("ca"))
+	fields.Track("data.b", nil, []byte("ca"))
+	// a value equal to the injected one was set by a previous injection
+	fields.Track("data.c", []byte("ca"), []byte("ca"))
+	// the original value is recorded only the first time
+	fields.Track("data.a", []byte("ca"), []byte("ca"))
+	err = SetInjectedFields(obj, fields)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"data.a":"b3JpZ2luYWw=","data.b":null,"data.c":null}`, obj.Annotations[CertAnnotationInjectedFields])
+
+	fields, err = GetInjectedFields(obj)
+	assert.NoError(t, err)
+	released := fields.Release("data.b")
+	assert.Equal(t, InjectedFields{"data.a": []byte("original"), "data.c": nil}, released)
+	assert.Equal(t, InjectedFields{"data.b": nil}, fields)
+
+	fields.Release()
+	err = SetInjectedFields(obj, fields)
+	assert.NoError(t, err)
+	assert.NotContains(t, obj.Annotations, CertAnnotationInjectedFields)
+
+	obj.Annotations[CertAnnotationInjectedFields] = "not json"
+	_, err = GetInjectedFields(obj)
+	assert.Error(t, err)
+}
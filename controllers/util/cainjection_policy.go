@@ -0,0 +1,141 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// CrossNamespaceCAInjectionAllow allows objects to inject ca bundles from any namespace
+	CrossNamespaceCAInjectionAllow = "allow"
+	// CrossNamespaceCAInjectionShared allows objects to inject ca bundles from other namespaces only if the source is shared with their namespace
+	CrossNamespaceCAInjectionShared = "shared"
+	// CrossNamespaceCAInjectionSubjectAccessReview also allows objects to inject ca bundles from other namespaces if the
+	// CrossNamespaceCAInjectionServiceAccount service account of their namespace can read the source
+	CrossNamespaceCAInjectionSubjectAccessReview = "subjectaccessreview"
+)
+
+// CrossNamespaceCAInjectionPolicies are the supported values of CrossNamespaceCAInjectionPolicy
+var CrossNamespaceCAInjectionPolicies = []string{CrossNamespaceCAInjectionAllow, CrossNamespaceCAInjectionShared, CrossNamespaceCAInjectionSubjectAccessReview}
+
+// CrossNamespaceCAInjectionPolicy is the policy applied when a namespaced object injects the ca bundle of a secret or configmap of another namespace
+var CrossNamespaceCAInjectionPolicy = CrossNamespaceCAInjectionAllow
+
+// CrossNamespaceCAInjectionServiceAccount is the name of the service account, in the namespace of the target object, whose permissions are
+// checked by the subjectaccessreview policy. It defaults to the default service account, which exists in every namespace and is used by the
+// pods that do not declare one, so that the injection is allowed only if the workloads of the namespace could read the source themselves.
+var CrossNamespaceCAInjectionServiceAccount = "default"
+
+// ValidateCrossNamespaceCAInjectionPolicy returns an error if policy is not one of CrossNamespaceCAInjectionPolicies
+func ValidateCrossNamespaceCAInjectionPolicy(policy string) error {
+	for _, supported := range CrossNamespaceCAInjectionPolicies {
+		if policy == supported {
+			return nil
+		}
+	}
+	return errors.New("unsupported cross-namespace ca injection policy " + policy + ", it must be one of " + strings.Join(CrossNamespaceCAInjectionPolicies, ", "))
+}
+
+// CrossNamespaceCAInjectionDeniedReason is the reason of the warning events reporting a denied cross-namespace ca injection
+const CrossNamespaceCAInjectionDeniedReason = "CrossNamespaceCAInjectionDenied"
+
+// CrossNamespaceCAInjectionDeniedError is returned when CrossNamespaceCAInjectionPolicy does not allow an object to inject the ca bundle
+// of a secret or configmap of another namespace. Retrying does not help until the source is shared with the namespace of the object.
+type CrossNamespaceCAInjectionDeniedError struct {
+	// Resource is secrets or configmaps
+	Resource string
+	// Source is the secret or configmap that cannot be injected
+	Source types.NamespacedName
+	// Namespace is the namespace of the object in which the ca bundle would be injected
+	Namespace string
+}
+
+func (e *CrossNamespaceCAInjectionDeniedError) Error() string {
+	return "cross-namespace ca injection denied: " + e.Resource + " " + e.Source.String() + " is not shared with namespace " + e.Namespace
+}
+
+// IsCrossNamespaceCAInjectionDenied returns whether err is, or wraps, a CrossNamespaceCAInjectionDeniedError
+func IsCrossNamespaceCAInjectionDenied(err error) bool {
+	var denied *CrossNamespaceCAInjectionDeniedError
+	return errors.As(err, &denied)
+}
+
+// RecordCrossNamespaceCAInjectionDenied emits a CrossNamespaceCAInjectionDenied warning event on obj if err is a denied cross-namespace
+// ca injection, and returns whether it was. Denied injections should not be requeued, they are retried when the sharing of the source changes.
+func RecordCrossNamespaceCAInjectionDenied(recorder record.EventRecorder, obj client.Object, err error) bool {
+	if !IsCrossNamespaceCAInjectionDenied(err) {
+		return false
+	}
+	recorder.Event(obj, corev1.EventTypeWarning, CrossNamespaceCAInjectionDeniedReason, err.Error())
+	return true
+}
+
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// CheckCrossNamespaceCAInjection returns a CrossNamespaceCAInjectionDeniedError if target is not allowed to inject the ca bundle of the secret or configmap sourceName,
+// according to CrossNamespaceCAInjectionPolicy. source is the empty object in which the secret or configmap is read.
+// Cluster scoped targets can only be created by cluster administrators, so they are always allowed.
+func CheckCrossNamespaceCAInjection(c client.Client, target client.Object, source client.Object, sourceName types.NamespacedName) error {
+	if CrossNamespaceCAInjectionPolicy == CrossNamespaceCAInjectionAllow || target.GetNamespace() == "" || target.GetNamespace() == sourceName.Namespace {
+		return nil
+	}
+	err := c.Get(context.TODO(), sourceName, source)
+	if err != nil {
+		log.Error(err, "unable to find referenced ca source", "source", sourceName)
+		return err
+	}
+	shared, err := IsSharedWithNamespace(c, source, target.GetNamespace())
+	if err != nil {
+		return err
+	}
+	if shared {
+		return nil
+	}
+	resource := "secrets"
+	if _, ok := source.(*corev1.ConfigMap); ok {
+		resource = "configmaps"
+	}
+	if CrossNamespaceCAInjectionPolicy == CrossNamespaceCAInjectionSubjectAccessReview {
+		allowed, err := canServiceAccountGet(c, target.GetNamespace(), resource, sourceName)
+		if err != nil {
+			return err
+		}
+		if allowed {
+			return nil
+		}
+	}
+	return &CrossNamespaceCAInjectionDeniedError{
+		Resource:  resource,
+		Source:    sourceName,
+		Namespace: target.GetNamespace(),
+	}
+}
+
+// canServiceAccountGet returns whether the CrossNamespaceCAInjectionServiceAccount service account of namespace can get the given secret or configmap
+func canServiceAccountGet(c client.Client, namespace string, resource string, name types.NamespacedName) (bool, error) {
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   "system:serviceaccount:" + namespace + ":" + CrossNamespaceCAInjectionServiceAccount,
+			Groups: []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace, "system:authenticated"},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: name.Namespace,
+				Verb:      "get",
+				Resource:  resource,
+				Name:      name.Name,
+			},
+		},
+	}
+	err := c.Create(context.TODO(), review)
+	if err != nil {
+		log.Error(err, "unable to create subject access review", "namespace", namespace, "source", name)
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
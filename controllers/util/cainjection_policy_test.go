@@ -0,0 +1,126 @@
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// reviewingClient answers subject access reviews allowing only the given service account
+type reviewingClient struct {
+	client.Client
+	allowedUser string
+	reviews     int
+	lastReview  authorizationv1.SubjectAccessReviewSpec
+}
+
+func (c *reviewingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if review, ok := obj.(*authorizationv1.SubjectAccessReview); ok {
+		c.reviews++
+		c.lastReview = review.Spec
+		review.Status.Allowed = review.Spec.User == c.allowedUser
+		return nil
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func setCrossNamespaceCAInjectionPolicy(t *testing.T, policy string) {
+	previous := CrossNamespaceCAInjectionPolicy
+	CrossNamespaceCAInjectionPolicy = policy
+	t.Cleanup(func() {
+		CrossNamespaceCAInjectionPolicy = previous
+	})
+}
+
+func TestCheckCrossNamespaceCAInjection(t *testing.T) {
+	sharedSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "shared-ca",
+			Namespace: "certificates",
+			Annotations: map[string]string{
+				SharedWithNamespacesAnnotation: "tenant-a",
+			},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "private-ca",
+			Namespace: "certificates",
+		},
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "private-ca",
+			Namespace: "certificates",
+		},
+	}
+	cl := &reviewingClient{
+		Client:      fake.NewFakeClient(sharedSecret, secret, configMap),
+		allowedUser: "system:serviceaccount:tenant-b:default",
+	}
+	target := func(namespace string) client.Object {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "target",
+				Namespace: namespace,
+			},
+		}
+	}
+	sharedSecretName := types.NamespacedName{Namespace: "certificates", Name: "shared-ca"}
+	secretName := types.NamespacedName{Namespace: "certificates", Name: "private-ca"}
+
+	// by default everything is allowed
+	assert.NoError(t, CheckCrossNamespaceCAInjection(cl, target("tenant-a"), &corev1.Secret{}, secretName))
+
+	setCrossNamespaceCAInjectionPolicy(t, CrossNamespaceCAInjectionShared)
+	assert.NoError(t, CheckCrossNamespaceCAInjection(cl, target("tenant-a"), &corev1.Secret{}, sharedSecretName))
+	assert.NoError(t, CheckCrossNamespaceCAInjection(cl, target("certificates"), &corev1.Secret{}, secretName))
+	assert.NoError(t, CheckCrossNamespaceCAInjection(cl, &corev1.Secret{}, &corev1.Secret{}, secretName), "cluster scoped targets are always allowed")
+	assert.Error(t, CheckCrossNamespaceCAInjection(cl, target("tenant-a"), &corev1.Secret{}, secretName))
+	assert.Error(t, CheckCrossNamespaceCAInjection(cl, target("tenant-b"), &corev1.Secret{}, sharedSecretName))
+	assert.Equal(t, 0, cl.reviews)
+
+	setCrossNamespaceCAInjectionPolicy(t, CrossNamespaceCAInjectionSubjectAccessReview)
+	assert.NoError(t, CheckCrossNamespaceCAInjection(cl, target("tenant-a"), &corev1.Secret{}, sharedSecretName))
+	assert.Equal(t, 0, cl.reviews, "shared sources do not need a review")
+	assert.NoError(t, CheckCrossNamespaceCAInjection(cl, target("tenant-b"), &corev1.Secret{}, secretName))
+	assert.NoError(t, CheckCrossNamespaceCAInjection(cl, target("tenant-b"), &corev1.ConfigMap{}, secretName))
+	err := CheckCrossNamespaceCAInjection(cl, target("tenant-a"), &corev1.ConfigMap{}, secretName)
+	assert.EqualError(t, err, "cross-namespace ca injection denied: configmaps certificates/private-ca is not shared with namespace tenant-a")
+	assert.True(t, IsCrossNamespaceCAInjectionDenied(err))
+	assert.False(t, IsCrossNamespaceCAInjectionDenied(CheckCrossNamespaceCAInjection(cl, target("tenant-a"), &corev1.Secret{}, types.NamespacedName{Namespace: "certificates", Name: "missing"})))
+	assert.Equal(t, 3, cl.reviews)
+	assert.Equal(t, "system:serviceaccount:tenant-a:default", cl.lastReview.User)
+	assert.Contains(t, cl.lastReview.Groups, "system:serviceaccounts:tenant-a")
+	assert.Equal(t, authorizationv1.ResourceAttributes{
+		Namespace: "certificates",
+		Verb:      "get",
+		Resource:  "configmaps",
+		Name:      "private-ca",
+	}, *cl.lastReview.ResourceAttributes)
+
+	// the reviewed service account can be configured
+	previous := CrossNamespaceCAInjectionServiceAccount
+	CrossNamespaceCAInjectionServiceAccount = "ca-reader"
+	defer func() {
+		CrossNamespaceCAInjectionServiceAccount = previous
+	}()
+	cl.allowedUser = "system:serviceaccount:tenant-a:ca-reader"
+	assert.NoError(t, CheckCrossNamespaceCAInjection(cl, target("tenant-a"), &corev1.Secret{}, secretName))
+	assert.Equal(t, "system:serviceaccount:tenant-a:ca-reader", cl.lastReview.User)
+	assert.True(t, IsCrossNamespaceCAInjectionDenied(CheckCrossNamespaceCAInjection(cl, target("tenant-b"), &corev1.Secret{}, secretName)))
+}
+
+func TestValidateCrossNamespaceCAInjectionPolicy(t *testing.T) {
+	for _, policy := range CrossNamespaceCAInjectionPolicies {
+		assert.NoError(t, ValidateCrossNamespaceCAInjectionPolicy(policy))
+	}
+	assert.Error(t, ValidateCrossNamespaceCAInjectionPolicy("deny"))
+}
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// WritePerformed is the result of a write that changed an object
+	WritePerformed = "performed"
+	// WriteSkipped is the result of a write that was not sent because the object was already up to date
+	WriteSkipped = "skipped"
+)
+
+var objectWrites = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "certutils",
+		Name:      "object_writes_total",
+		Help:      "number of writes of the objects managed by the operator, either performed or skipped because the objects were already up to date",
+	},
+	[]string{"controller", "result"},
+)
+
+func init() {
+	// Register custom metrics with the global prometheus registry
+	metrics.Registry.MustRegister(objectWrites)
+}
+
+// PatchOwnedFieldsIfChanged patches obj, if it semantically differs from original, sending as a json patch only the changes made since
+// original, which must be a deep copy of obj taken before the changes, see OwnedFieldsFrom. Unlike a full update, the patch does not conflict
+// with and does not overwrite concurrent changes to the fields, or list entries, not owned by the operator. It returns whether obj was patched.
+func PatchOwnedFieldsIfChanged(ctx context.Context, c client.Client, controller string, obj client.Object, original client.Object) (bool, error) {
+	return PatchIfChanged(ctx, c, controller, obj, original, OwnedFieldsFrom(original))
+}
+
+// PatchIfChanged sends patch, computed from original, with the field manager of the operator, only if obj semantically differs from original.
+// Performed and skipped writes are counted for controller. It returns whether obj was patched.
+func PatchIfChanged(ctx context.Context, c client.Client, controller string, obj client.Object, original client.Object, patch client.Patch) (bool, error) {
+	if equality.Semantic.DeepEqual(original, obj) {
+		objectWrites.WithLabelValues(controller, WriteSkipped).Inc()
+		return false, nil
+	}
+	err := c.Patch(ctx, obj, patch, client.FieldOwner(FieldManager))
+	if err != nil {
+		return false, err
+	}
+	objectWrites.WithLabelValues(controller, WritePerformed).Inc()
+	return true, nil
+}
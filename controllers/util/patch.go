@@ -0,0 +1,124 @@
+package util
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// jsonPatchOperation is an operation of a json patch, as defined by RFC 6902
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+type ownedFieldsPatch struct {
+	from client.Object
+}
+
+// OwnedFieldsFrom creates a json patch containing only the changes made to an object since from, which must be a deep copy of the object
+// taken before the changes. Unlike a json merge patch, which replaces lists as a whole, the entries of a list are patched one by one: every
+// changed entry is tested against its value in from before being replaced, so the patch fails, instead of reverting them, if the entry or
+// the length of the list were changed concurrently, and the changes made concurrently to the other entries are preserved.
+func OwnedFieldsFrom(from client.Object) client.Patch {
+	return &ownedFieldsPatch{from: from}
+}
+
+// Type implements client.Patch
+func (p *ownedFieldsPatch) Type() types.PatchType {
+	return types.JSONPatchType
+}
+
+// Data implements client.Patch
+func (p *ownedFieldsPatch) Data(obj runtime.Object) ([]byte, error) {
+	original, err := toJSONValue(p.from)
+	if err != nil {
+		return nil, err
+	}
+	modified, err := toJSONValue(obj)
+	if err != nil {
+		return nil, err
+	}
+	operations := diffJSONValues("", original, modified, []jsonPatchOperation{})
+	return json.Marshal(operations)
+}
+
+func toJSONValue(obj runtime.Object) (interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	err = json.Unmarshal(data, &value)
+	return value, err
+}
+
+// diffJSONValues appends to operations the json patch operations that change original into modified at path
+func diffJSONValues(path string, original interface{}, modified interface{}, operations []jsonPatchOperation) []jsonPatchOperation {
+	if reflect.DeepEqual(original, modified) {
+		return operations
+	}
+	switch modifiedValue := modified.(type) {
+	case map[string]interface{}:
+		originalValue, ok := original.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(originalValue)+len(modifiedValue))
+		for key := range originalValue {
+			keys = append(keys, key)
+		}
+		for key := range modifiedValue {
+			if _, ok := originalValue[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			keyPath := path + "/" + escapeJSONPointer(key)
+			originalField, inOriginal := originalValue[key]
+			modifiedField, inModified := modifiedValue[key]
+			switch {
+			case !inModified:
+				operations = append(operations, jsonPatchOperation{Op: "remove", Path: keyPath})
+			case !inOriginal:
+				operations = append(operations, jsonPatchOperation{Op: "add", Path: keyPath, Value: modifiedField})
+			default:
+				operations = diffJSONValues(keyPath, originalField, modifiedField, operations)
+			}
+		}
+		return operations
+	case []interface{}:
+		originalValue, ok := original.([]interface{})
+		if !ok || len(originalValue) != len(modifiedValue) {
+			break
+		}
+		for i := range modifiedValue {
+			if reflect.DeepEqual(originalValue[i], modifiedValue[i]) {
+				continue
+			}
+			itemPath := path + "/" + strconv.Itoa(i)
+			operations = append(operations,
+				jsonPatchOperation{Op: "test", Path: itemPath, Value: originalValue[i]},
+				jsonPatchOperation{Op: "replace", Path: itemPath, Value: modifiedValue[i]})
+		}
+		return operations
+	}
+	// scalars, and lists whose length changed, are replaced as a whole, lists only if they were not changed concurrently
+	if _, ok := original.([]interface{}); ok {
+		operations = append(operations, jsonPatchOperation{Op: "test", Path: path, Value: original})
+	}
+	return append(operations, jsonPatchOperation{Op: "replace", Path: path, Value: modified})
+}
+
+// escapeJSONPointer escapes a reference token of a json pointer, as defined by RFC 6901
+func escapeJSONPointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
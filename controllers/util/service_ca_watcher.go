@@ -0,0 +1,113 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ServiceCAFile is the service ca file mounted in the operator pod
+var ServiceCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/service-ca.crt"
+
+const serviceCAPollInterval = time.Minute
+
+// GetServiceCA returns the content of the service ca file
+func GetServiceCA() ([]byte, error) {
+	serviceCA, err := ioutil.ReadFile(ServiceCAFile)
+	if err != nil {
+		log.Error(err, "unable to read service ca file", "file", ServiceCAFile)
+		return []byte{}, err
+	}
+	return serviceCA, nil
+}
+
+// ServiceCAWatcher watches the service ca file and notifies its subscribers when its content changes.
+// It must be added to the manager, which will start it.
+type ServiceCAWatcher struct {
+	lock        sync.Mutex
+	subscribers []chan event.GenericEvent
+	serviceCA   []byte
+}
+
+// NewServiceCAWatcher returns a watcher for ServiceCAFile
+func NewServiceCAWatcher() *ServiceCAWatcher {
+	return &ServiceCAWatcher{}
+}
+
+// NewSource returns a source that emits a generic event every time the service ca changes
+func (w *ServiceCAWatcher) NewSource() source.Source {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	subscriber := make(chan event.GenericEvent)
+	w.subscribers = append(w.subscribers, subscriber)
+	return &source.Channel{Source: subscriber}
+}
+
+// Start implements manager.Runnable
+func (w *ServiceCAWatcher) Start(ctx context.Context) error {
+	w.serviceCA, _ = ioutil.ReadFile(ServiceCAFile)
+	// the file is usually mounted via a symlink which gets replaced on update, so we watch the directory
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		err = watcher.Add(filepath.Dir(ServiceCAFile))
+	}
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	if err != nil {
+		log.Error(err, "unable to watch service ca file, falling back to polling", "file", ServiceCAFile)
+	} else {
+		events = watcher.Events
+		// the errors channel is unbuffered, it must be drained for the events to keep being delivered
+		watchErrors = watcher.Errors
+	}
+	// polling also covers the case in which the file is created after the operator starts
+	ticker := time.NewTicker(serviceCAPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-events:
+			w.checkServiceCA(ctx)
+		case err := <-watchErrors:
+			log.Error(err, "error watching service ca file, polling continues", "file", ServiceCAFile)
+		case <-ticker.C:
+			w.checkServiceCA(ctx)
+		}
+	}
+}
+
+func (w *ServiceCAWatcher) checkServiceCA(ctx context.Context) {
+	serviceCA, err := ioutil.ReadFile(ServiceCAFile)
+	if err != nil || bytes.Equal(serviceCA, w.serviceCA) {
+		return
+	}
+	log.Info("service ca changed", "file", ServiceCAFile)
+	w.serviceCA = serviceCA
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	evt := event.GenericEvent{
+		Object: &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: filepath.Base(ServiceCAFile),
+			},
+		},
+	}
+	for _, subscriber := range w.subscribers {
+		select {
+		case subscriber <- evt:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+func setServiceCAFile(t *testing.T, content string) {
+	dir, err := ioutil.TempDir("", "service-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	previous := ServiceCAFile
+	ServiceCAFile = filepath.Join(dir, "service-ca.crt")
+	t.Cleanup(func() { ServiceCAFile = previous })
+	err = ioutil.WriteFile(ServiceCAFile, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServiceCAWatcher(t *testing.T) {
+	setServiceCAFile(t, "service-ca")
+
+	watcher := NewServiceCAWatcher()
+	events := watcher.NewSource().(*source.Channel).Source
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go watcher.Start(ctx)
+
+	// give the watcher the time to read the initial content
+	time.Sleep(100 * time.Millisecond)
+	err := ioutil.WriteFile(ServiceCAFile, []byte("rotated-service-ca"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case evt := <-events:
+		assert.Equal(t, "service-ca.crt", evt.Object.GetName())
+	case <-time.After(10 * time.Second):
+		t.Fatal("no event received after the service ca changed")
+	}
+}
+
+func TestGetCABundleFromServiceCA(t *testing.T) {
+	serviceCA := newTestCA(t, "service-ca", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	setServiceCAFile(t, serviceCA)
+
+	target := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "target",
+			Namespace: "test",
+			Annotations: map[string]string{
+				CertAnnotationServiceCA: "true",
+			},
+		},
+	}
+
+	caBundle, err := GetCABundle(fake.NewFakeClient(), target)
+	assert.NoError(t, err)
+	assert.Equal(t, serviceCA, string(caBundle))
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+
+	keystore "github.com/pavlo-v-chernykh/keystore-go/v4"
+)
+
+// TrustStoreAlias returns the alias of cert in the java truststores generated by the operator, the hex encoded SHA-256 fingerprint of the certificate.
+// Unlike positional aliases, it does not change when other certificates are added to or removed from the bundle.
+func TrustStoreAlias(cert *x509.Certificate) string {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// NewJKSTrustStore returns a java truststore containing the certificates of the pem caBundle, protected by password.
+// Entries are aliased by TrustStoreAlias, duplicated certificates are added once, and the creation time of every entry is the start of
+// the validity of its certificate, so that the same bundle and password always result in the same truststore, byte by byte.
+func NewJKSTrustStore(caBundle []byte, password string) ([]byte, error) {
+	certs, err := parseCABundle(caBundle)
+	if err != nil {
+		return []byte{}, err
+	}
+	keyStore := keystore.New(
+		keystore.WithOrderedAliases(),
+	)
+	for _, cert := range certs {
+		alias := TrustStoreAlias(cert)
+		if keyStore.IsTrustedCertificateEntry(alias) {
+			continue
+		}
+		err := keyStore.SetTrustedCertificateEntry(alias, keystore.TrustedCertificateEntry{
+			CreationTime: cert.NotBefore,
+			Certificate: keystore.Certificate{
+				Type:    "X.509",
+				Content: cert.Raw,
+			},
+		})
+		if err != nil {
+			return []byte{}, err
+		}
+	}
+	buffer := bytes.Buffer{}
+	err = keyStore.Store(&buffer, []byte(password))
+	if err != nil {
+		return []byte{}, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// isJKSTrustStoreEqual returns whether the java truststores a and b contain the same certificates with the same aliases
+func isJKSTrustStoreEqual(a []byte, b []byte, password string) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	aKeyStore := keystore.New()
+	if err := aKeyStore.Load(bytes.NewReader(a), []byte(password)); err != nil {
+		return false
+	}
+	bKeyStore := keystore.New()
+	if err := bKeyStore.Load(bytes.NewReader(b), []byte(password)); err != nil {
+		return false
+	}
+	aliases := aKeyStore.Aliases()
+	if len(aliases) != len(bKeyStore.Aliases()) {
+		return false
+	}
+	for _, alias := range aliases {
+		aEntry, err := aKeyStore.GetTrustedCertificateEntry(alias)
+		if err != nil {
+			return false
+		}
+		bEntry, err := bKeyStore.GetTrustedCertificateEntry(alias)
+		if err != nil {
+			return false
+		}
+		if aEntry.Certificate.Type != bEntry.Certificate.Type || !bytes.Equal(aEntry.Certificate.Content, bEntry.Certificate.Content) {
+			return false
+		}
+	}
+	return true
+}
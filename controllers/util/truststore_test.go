@@ -0,0 +1,41 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	keystore "github.com/pavlo-v-chernykh/keystore-go/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewJKSTrustStore(t *testing.T) {
+	now := time.Now()
+	oldCA := newTestCA(t, "old-ca", now.Add(-2*time.Hour), now.Add(time.Hour))
+	newCA := newTestCA(t, "new-ca", now.Add(-time.Hour), now.Add(2*time.Hour))
+
+	trustStore, err := NewJKSTrustStore([]byte(oldCA), DefaultKeystorePassword)
+	assert.NoError(t, err)
+	// the same bundle always results in the same truststore
+	again, err := NewJKSTrustStore([]byte(oldCA), DefaultKeystorePassword)
+	assert.NoError(t, err)
+	assert.Equal(t, trustStore, again)
+
+	// adding a certificate in front of the bundle does not change the alias of the existing one, duplicates are added once
+	rotated, err := NewJKSTrustStore([]byte(newCA+oldCA+oldCA), DefaultKeystorePassword)
+	assert.NoError(t, err)
+	certs, err := parseCABundle([]byte(newCA + oldCA))
+	assert.NoError(t, err)
+	keyStore := keystore.New()
+	err = keyStore.Load(bytes.NewReader(rotated), []byte(DefaultKeystorePassword))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{TrustStoreAlias(certs[0]), TrustStoreAlias(certs[1])}, keyStore.Aliases())
+	entry, err := keyStore.GetTrustedCertificateEntry(TrustStoreAlias(certs[1]))
+	assert.NoError(t, err)
+	assert.Equal(t, certs[1].Raw, entry.Certificate.Content)
+	assert.True(t, certs[1].NotBefore.Equal(entry.CreationTime))
+
+	assert.True(t, isJKSTrustStoreEqual(trustStore, again, DefaultKeystorePassword))
+	assert.False(t, isJKSTrustStoreEqual(trustStore, rotated, DefaultKeystorePassword))
+	assert.False(t, isJKSTrustStoreEqual(trustStore, []byte("not a truststore"), DefaultKeystorePassword))
+}
@@ -1,19 +1,21 @@
 package util
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -32,6 +34,9 @@ const CABundle = "ca-bundle.crt"
 var log = ctrl.Log.WithName("controllers").WithName("KeepalivedGroup")
 
 const CertAnnotationSecret = AnnotationBase + "/injectca-from-secret"
+const CertAnnotationConfigMap = AnnotationBase + "/injectca-from-configmap"
+const CertAnnotationServiceCA = AnnotationBase + "/injectca-from-service-ca"
+const CertAnnotationPruneExpired = AnnotationBase + "/injectca-prune-expired"
 
 func ValidateSecretName(secretNamespacedName string) error {
 	if strings.Index(secretNamespacedName, "/") == -1 {
@@ -51,15 +56,30 @@ func ValidateConfigMapName(configMapNamespacedName string) error {
 	return nil
 }
 
+// IsAnnotatedForSecretCAInjection filters objects annotated for ca injection, either from a secret, from a configmap or from the service ca,
+// and objects in which a ca bundle was injected
 var IsAnnotatedForSecretCAInjection = predicate.Funcs{
 	UpdateFunc: func(e event.UpdateEvent) bool {
 		oldSecret, _ := e.ObjectOld.GetAnnotations()[CertAnnotationSecret]
 		newSecret, _ := e.ObjectNew.GetAnnotations()[CertAnnotationSecret]
-		return oldSecret != newSecret
+		oldConfigMap, _ := e.ObjectOld.GetAnnotations()[CertAnnotationConfigMap]
+		newConfigMap, _ := e.ObjectNew.GetAnnotations()[CertAnnotationConfigMap]
+		oldServiceCA, _ := e.ObjectOld.GetAnnotations()[CertAnnotationServiceCA]
+		newServiceCA, _ := e.ObjectNew.GetAnnotations()[CertAnnotationServiceCA]
+		if oldSecret != newSecret || oldConfigMap != newConfigMap || oldServiceCA != newServiceCA {
+			return true
+		}
+		for _, annotation := range []string{CertAnnotationPruneExpired, CertAnnotationTargetKey, CertAnnotationFormat, KeystorePasswordAnnotation, KeystorePasswordSecretAnnotation} {
+			if e.ObjectOld.GetAnnotations()[annotation] != e.ObjectNew.GetAnnotations()[annotation] {
+				return true
+			}
+		}
+		return false
 	},
 	CreateFunc: func(e event.CreateEvent) bool {
-		_, ok1 := e.Object.GetAnnotations()[CertAnnotationSecret]
-		return ok1
+		// objects with injected fields are reconciled even when the injection is disabled, so that the injection is reverted
+		_, injected := e.Object.GetAnnotations()[CertAnnotationInjectedFields]
+		return IsCAInjectionEnabled(e.Object) || injected
 	},
 }
 
@@ -76,7 +96,7 @@ var IsCAContentChanged = predicate.Funcs{
 		if newSecret.Type != TLSSecret {
 			return false
 		}
-		return !reflect.DeepEqual(newSecret.Data[CA], oldSecret.Data[CA])
+		return !reflect.DeepEqual(newSecret.Data[CA], oldSecret.Data[CA]) || IsSecretSharingChanged(oldSecret, newSecret)
 	},
 	CreateFunc: func(e event.CreateEvent) bool {
 		secret, ok := e.Object.(*corev1.Secret)
@@ -90,39 +110,140 @@ var IsCAContentChanged = predicate.Funcs{
 	},
 }
 
-func (e *enqueueRequestForReferecingObject) matchSecretWithResource(secret types.NamespacedName) ([]unstructured.Unstructured, error) {
-	unstructuredList, err := e.client.List(context.TODO(), metav1.ListOptions{})
+// IsCAConfigMapContentChanged filters configmap events that may change the ca bundle injected in referencing objects
+var IsCAConfigMapContentChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldConfigMap, ok := e.ObjectOld.(*corev1.ConfigMap)
+		if !ok {
+			return false
+		}
+		newConfigMap, ok := e.ObjectNew.(*corev1.ConfigMap)
+		if !ok {
+			return false
+		}
+		return !reflect.DeepEqual(newConfigMap.Data, oldConfigMap.Data) || IsSecretSharingChanged(oldConfigMap, newConfigMap)
+	},
+	CreateFunc: func(e event.CreateEvent) bool {
+		return true
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		return false
+	},
+	GenericFunc: func(e event.GenericEvent) bool {
+		return false
+	},
+}
+
+// ReferenceIndexField returns the name of the field index of the objects that reference secrets, configmaps or the service ca with annotation
+func ReferenceIndexField(annotation string) string {
+	return "metadata.annotations[" + annotation + "]"
+}
+
+// GetReferencedObjects returns the {namespace}/{name} of the secrets or configmaps that obj references with annotation.
+// For the service ca annotation it returns "true" when the service ca is referenced.
+func GetReferencedObjects(obj client.Object, annotation string) []string {
+	reference, ok := obj.GetAnnotations()[annotation]
+	if !ok {
+		return []string{}
+	}
+	if annotation == CertAnnotationServiceCA {
+		// there is only one service ca, every object that requires it references it
+		if reference == "true" {
+			return []string{reference}
+		}
+		return []string{}
+	}
+	result := []string{}
+	// references have format {namespace}/{name} optionally followed by /{key}
+	for _, ref := range splitReferences(reference) {
+		parts := strings.Split(ref, "/")
+		if len(parts) >= 2 {
+			result = append(result, parts[0]+"/"+parts[1])
+		}
+	}
+	return result
+}
+
+// IndexReferencingObjects indexes the objects of the type of obj by the secrets, configmaps or service ca they reference with the given annotations,
+// so that NewEnqueueRequestForReferecingObject can find them in the cache.
+func IndexReferencingObjects(indexer client.FieldIndexer, obj client.Object, annotations ...string) error {
+	for _, annotation := range annotations {
+		annotation := annotation
+		err := indexer.IndexField(context.TODO(), obj, ReferenceIndexField(annotation), func(o client.Object) []string {
+			return GetReferencedObjects(o, annotation)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *enqueueRequestForReferecingObject) matchSecretWithResource(secret types.NamespacedName) ([]client.Object, error) {
+	reference := secret.String()
+	if e.annotation == CertAnnotationServiceCA {
+		reference = "true"
+	}
+	list := e.list.DeepCopyObject().(client.ObjectList)
+	opts := []client.ListOption{}
+	if e.indexed {
+		opts = append(opts, client.MatchingFields{ReferenceIndexField(e.annotation): reference})
+	}
+	err := e.reader.List(context.TODO(), list, opts...)
 	if err != nil {
-		log.Error(err, "unable to list resources", "with client", e.client)
-		return []unstructured.Unstructured{}, err
+		log.Error(err, "unable to list resources", "annotation", e.annotation)
+		return []client.Object{}, err
 	}
-	result := []unstructured.Unstructured{}
-	for _, obj := range unstructuredList.Items {
-		if secretNamespacedName := obj.GetAnnotations()[CertAnnotationSecret]; secretNamespacedName[strings.Index(secretNamespacedName, "/")+1:] == secret.Name && secretNamespacedName[:strings.Index(secretNamespacedName, "/")] == secret.Namespace {
-			result = append(result, obj)
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return []client.Object{}, err
+	}
+	result := []client.Object{}
+	for _, item := range items {
+		obj, ok := item.(client.Object)
+		if !ok {
+			continue
+		}
+		// without an index all the objects are returned, so they have to be filtered here
+		for _, ref := range GetReferencedObjects(obj, e.annotation) {
+			if ref == reference {
+				result = append(result, obj)
+				break
+			}
 		}
 	}
 	return result, nil
 }
 
-func NewEnqueueRequestForReferecingObject(config *rest.Config, gvk schema.GroupVersionKind) *enqueueRequestForReferecingObject {
-	gvr, _ := meta.UnsafeGuessKindToResource(gvk)
-	client := dynamic.NewForConfigOrDie(config).Resource(gvr)
+// NewEnqueueRequestForReferecingObject returns an event handler that, given a secret or a configmap, enqueues the objects of type list
+// whose annotation references it. The objects are looked up in reader, which should be the manager cache, using the index added by IndexReferencingObjects.
+func NewEnqueueRequestForReferecingObject(reader client.Reader, list client.ObjectList, annotation string) *enqueueRequestForReferecingObject {
 	return &enqueueRequestForReferecingObject{
-		client: client,
+		reader:     reader,
+		list:       list,
+		annotation: annotation,
+		indexed:    true,
+	}
+}
+
+// NewEnqueueRequestForReferecingUnindexedObject is like NewEnqueueRequestForReferecingObject, but for types that have not been indexed.
+// It is meant for types watched after the cache is started, when indexes can no longer be added, and it filters all the cached objects of type list.
+func NewEnqueueRequestForReferecingUnindexedObject(reader client.Reader, list client.ObjectList, annotation string) *enqueueRequestForReferecingObject {
+	return &enqueueRequestForReferecingObject{
+		reader:     reader,
+		list:       list,
+		annotation: annotation,
 	}
 }
 
 type enqueueRequestForReferecingObject struct {
-	client dynamic.NamespaceableResourceInterface
+	reader     client.Reader
+	list       client.ObjectList
+	annotation string
+	indexed    bool
 }
 
-// trigger a router reconcile event for those routes that reference this secret
-func (e *enqueueRequestForReferecingObject) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
-	namespacedName := types.NamespacedName{
-		Name:      evt.Object.GetName(),
-		Namespace: evt.Object.GetNamespace(),
-	}
+func (e *enqueueRequestForReferecingObject) enqueue(namespacedName types.NamespacedName, q workqueue.RateLimitingInterface) {
 	objs, err := e.matchSecretWithResource(namespacedName)
 
 	if err != nil {
@@ -137,35 +258,193 @@ func (e *enqueueRequestForReferecingObject) Create(evt event.CreateEvent, q work
 	}
 }
 
+// trigger a router reconcile event for those routes that reference this secret
+func (e *enqueueRequestForReferecingObject) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	e.enqueue(types.NamespacedName{
+		Name:      evt.Object.GetName(),
+		Namespace: evt.Object.GetNamespace(),
+	}, q)
+}
+
 // Update implements EventHandler
 // trigger a router reconcile event for those routes that reference this secret
 func (e *enqueueRequestForReferecingObject) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
-	namespacedName := types.NamespacedName{
+	e.enqueue(types.NamespacedName{
 		Name:      evt.ObjectNew.GetName(),
 		Namespace: evt.ObjectNew.GetNamespace(),
+	}, q)
+}
+
+// Delete implements EventHandler
+func (e *enqueueRequestForReferecingObject) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	return
+}
+
+// Generic implements EventHandler
+// trigger a reconcile event for those objects that reference this object, used for service ca changes
+func (e *enqueueRequestForReferecingObject) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	e.enqueue(types.NamespacedName{
+		Name:      evt.Object.GetName(),
+		Namespace: evt.Object.GetNamespace(),
+	}, q)
+}
+
+// ParseConfigMapReference parses the value of the injectca-from-configmap annotation, which has format {namespace}/{configmap-name}[/{key}].
+// When the key is not specified ca-bundle.crt is used.
+func ParseConfigMapReference(reference string) (types.NamespacedName, string, error) {
+	err := ValidateConfigMapName(reference)
+	if err != nil {
+		return types.NamespacedName{}, "", err
 	}
-	objs, err := e.matchSecretWithResource(namespacedName)
+	parts := strings.Split(reference, "/")
+	if len(parts) > 3 || parts[0] == "" || parts[1] == "" || (len(parts) == 3 && parts[2] == "") {
+		return types.NamespacedName{}, "", errors.New("Invalid ca configmap name does not match format {namespace}/{configmap-name}[/{key}]")
+	}
+	key := CABundle
+	if len(parts) == 3 {
+		key = parts[2]
+	}
+	return types.NamespacedName{
+		Namespace: parts[0],
+		Name:      parts[1],
+	}, key, nil
+}
 
+func GetConfigMapCA(c client.Client, configMapName types.NamespacedName, key string) ([]byte, error) {
+	configMap := &corev1.ConfigMap{}
+	err := c.Get(context.TODO(), configMapName, configMap)
 	if err != nil {
-		log.Error(err, "unable to match resources", "with namespaced name", namespacedName)
+		log.Error(err, "unable to find referenced configmap", "configmap", configMapName)
+		return []byte{}, err
 	}
+	return []byte(configMap.Data[key]), nil
+}
 
-	for _, obj := range objs {
-		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{
-			Name:      obj.GetName(),
-			Namespace: obj.GetNamespace(),
-		}})
+// GetCABundle returns the ca bundle to be injected in obj, according to its injectca-from-secret, injectca-from-configmap and injectca-from-service-ca annotations.
+// The secret and configmap annotations accept comma separated lists of references. The returned bundle is the deduplicated union of all the
+// referenced certificates, sorted in a stable order. Expired certificates are removed if obj has the injectca-prune-expired annotation.
+func GetCABundle(c client.Client, obj client.Object) ([]byte, error) {
+	bundles := [][]byte{}
+	if secretNamespacedNames, ok := obj.GetAnnotations()[CertAnnotationSecret]; ok {
+		for _, secretNamespacedName := range splitReferences(secretNamespacedNames) {
+			err := ValidateSecretName(secretNamespacedName)
+			if err != nil {
+				log.Error(err, "invalid ca secret name", "secret", secretNamespacedName)
+				return []byte{}, err
+			}
+			//we need to inject the secret ca
+			secretName := types.NamespacedName{
+				Namespace: secretNamespacedName[:strings.Index(secretNamespacedName, "/")],
+				Name:      secretNamespacedName[strings.Index(secretNamespacedName, "/")+1:],
+			}
+			err = CheckCrossNamespaceCAInjection(c, obj, &corev1.Secret{}, secretName)
+			if err != nil {
+				return []byte{}, err
+			}
+			ca, err := GetSecretCA(c, secretName.Name, secretName.Namespace)
+			if err != nil {
+				log.Error(err, "unable to retrive ca from secret", "secret", secretNamespacedName)
+				return []byte{}, err
+			}
+			bundles = append(bundles, ca)
+		}
+	}
+	if references, ok := obj.GetAnnotations()[CertAnnotationConfigMap]; ok {
+		for _, reference := range splitReferences(references) {
+			configMapName, key, err := ParseConfigMapReference(reference)
+			if err != nil {
+				log.Error(err, "invalid ca configmap name", "configmap", reference)
+				return []byte{}, err
+			}
+			err = CheckCrossNamespaceCAInjection(c, obj, &corev1.ConfigMap{}, configMapName)
+			if err != nil {
+				return []byte{}, err
+			}
+			ca, err := GetConfigMapCA(c, configMapName, key)
+			if err != nil {
+				log.Error(err, "unable to retrive ca from configmap", "configmap", reference)
+				return []byte{}, err
+			}
+			bundles = append(bundles, ca)
+		}
+	}
+	if value, ok := obj.GetAnnotations()[CertAnnotationServiceCA]; ok && value == "true" {
+		ca, err := GetServiceCA()
+		if err != nil {
+			return []byte{}, err
+		}
+		bundles = append(bundles, ca)
 	}
+	pruneExpired := obj.GetAnnotations()[CertAnnotationPruneExpired] == "true"
+	return MergeCABundles(bundles, pruneExpired, time.Now())
 }
 
-// Delete implements EventHandler
-func (e *enqueueRequestForReferecingObject) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
-	return
+// splitReferences splits a comma separated list of references, ignoring empty entries
+func splitReferences(references string) []string {
+	result := []string{}
+	for _, reference := range strings.Split(references, ",") {
+		if reference = strings.TrimSpace(reference); reference != "" {
+			result = append(result, reference)
+		}
+	}
+	return result
 }
 
-// Generic implements EventHandler
-func (e *enqueueRequestForReferecingObject) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
-	return
+// MergeCABundles returns the deduplicated union of the certificates of the given pem bundles. Certificates are sorted by subject, then by
+// validity start and finally by content, so that the same set of certificates always results in the same bundle.
+// If pruneExpired is true the certificates expired at the given time are removed.
+func MergeCABundles(bundles [][]byte, pruneExpired bool, now time.Time) ([]byte, error) {
+	certs := []*x509.Certificate{}
+	for _, bundle := range bundles {
+		for {
+			p, rest := pem.Decode(bundle)
+			if p == nil {
+				break
+			}
+			bundle = rest
+			if p.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(p.Bytes)
+			if err != nil {
+				log.Error(err, "unable to parse ca certificate")
+				return []byte{}, err
+			}
+			if pruneExpired && now.After(cert.NotAfter) {
+				continue
+			}
+			duplicate := false
+			for _, c := range certs {
+				if c.Equal(cert) {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				certs = append(certs, cert)
+			}
+		}
+	}
+	sort.Slice(certs, func(i, j int) bool {
+		if certs[i].Subject.String() != certs[j].Subject.String() {
+			return certs[i].Subject.String() < certs[j].Subject.String()
+		}
+		if !certs[i].NotBefore.Equal(certs[j].NotBefore) {
+			return certs[i].NotBefore.Before(certs[j].NotBefore)
+		}
+		return bytes.Compare(certs[i].Raw, certs[j].Raw) < 0
+	})
+	var buffer bytes.Buffer
+	for _, cert := range certs {
+		err := pem.Encode(&buffer, &pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Raw,
+		})
+		if err != nil {
+			return []byte{}, err
+		}
+	}
+	return buffer.Bytes(), nil
 }
 
 func GetSecretCA(c client.Client, secretName string, secretNamespace string) ([]byte, error) {
@@ -180,3 +459,242 @@ func GetSecretCA(c client.Client, secretName string, secretNamespace string) ([]
 	}
 	return secret.Data[CA], nil
 }
+
+const KeystorePasswordSecretAnnotation = AnnotationBase + "/java-keystore-password-secret"
+
+// ParseKeystorePasswordSecretReference parses the value of the java-keystore-password-secret annotation, which has format {secret-name}/{key}
+func ParseKeystorePasswordSecretReference(reference string) (string, string, error) {
+	parts := strings.Split(reference, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New("Invalid keystore password secret reference does not match format {secret-name}/{key}")
+	}
+	return parts[0], parts[1], nil
+}
+
+// GetKeystorePasswordFromSecret returns the keystore password referenced by the java-keystore-password-secret annotation of obj.
+// The password secret is looked up in the namespace of obj.
+func GetKeystorePasswordFromSecret(c client.Client, obj client.Object) (string, error) {
+	secretName, key, err := ParseKeystorePasswordSecretReference(obj.GetAnnotations()[KeystorePasswordSecretAnnotation])
+	if err != nil {
+		return "", err
+	}
+	secret := &corev1.Secret{}
+	err = c.Get(context.TODO(), types.NamespacedName{
+		Namespace: obj.GetNamespace(),
+		Name:      secretName,
+	}, secret)
+	if err != nil {
+		log.Error(err, "unable to find referenced keystore password secret", "secret", secretName)
+		return "", err
+	}
+	password, ok := secret.Data[key]
+	if !ok || len(password) == 0 {
+		return "", errors.New("key " + key + " not found or empty in keystore password secret " + secretName)
+	}
+	return string(password), nil
+}
+
+// IsPasswordSecretContentChanged filters secret events that may change the keystore password of referencing objects
+var IsPasswordSecretContentChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldSecret, ok := e.ObjectOld.(*corev1.Secret)
+		if !ok {
+			return false
+		}
+		newSecret, ok := e.ObjectNew.(*corev1.Secret)
+		if !ok {
+			return false
+		}
+		return !reflect.DeepEqual(newSecret.Data, oldSecret.Data)
+	},
+	CreateFunc: func(e event.CreateEvent) bool {
+		return true
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		return false
+	},
+	GenericFunc: func(e event.GenericEvent) bool {
+		return false
+	},
+}
+
+// NewEnqueueRequestForReferencingPasswordSecret returns an event handler that, given a secret, enqueues the objects of the same namespace
+// whose java-keystore-password-secret annotation references that secret. list determines the type of the referencing objects.
+func NewEnqueueRequestForReferencingPasswordSecret(c client.Client, list client.ObjectList) *enqueueRequestForReferencingPasswordSecret {
+	return &enqueueRequestForReferencingPasswordSecret{
+		Client: c,
+		list:   list,
+	}
+}
+
+type enqueueRequestForReferencingPasswordSecret struct {
+	client.Client
+	list client.ObjectList
+}
+
+func (e *enqueueRequestForReferencingPasswordSecret) matchPasswordSecret(secret types.NamespacedName) ([]client.Object, error) {
+	list := e.list.DeepCopyObject().(client.ObjectList)
+	err := e.List(context.TODO(), list, &client.ListOptions{
+		Namespace: secret.Namespace,
+	})
+	if err != nil {
+		log.Error(err, "unable to list objects for this namespace: ", "namespace", secret.Namespace)
+		return []client.Object{}, err
+	}
+	objs, err := meta.ExtractList(list)
+	if err != nil {
+		return []client.Object{}, err
+	}
+	result := []client.Object{}
+	for _, o := range objs {
+		obj, ok := o.(client.Object)
+		if !ok {
+			continue
+		}
+		reference, ok := obj.GetAnnotations()[KeystorePasswordSecretAnnotation]
+		if !ok {
+			continue
+		}
+		if secretName, _, err := ParseKeystorePasswordSecretReference(reference); err == nil && secretName == secret.Name {
+			result = append(result, obj)
+		}
+	}
+	return result, nil
+}
+
+func (e *enqueueRequestForReferencingPasswordSecret) enqueue(secret types.NamespacedName, q workqueue.RateLimitingInterface) {
+	objs, err := e.matchPasswordSecret(secret)
+	if err != nil {
+		log.Error(err, "unable to match objects", "with namespaced name", secret)
+	}
+	for _, obj := range objs {
+		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      obj.GetName(),
+			Namespace: obj.GetNamespace(),
+		}})
+	}
+}
+
+// Create implements EventHandler
+// trigger a reconcile event for those objects that reference this password secret
+func (e *enqueueRequestForReferencingPasswordSecret) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	e.enqueue(types.NamespacedName{
+		Name:      evt.Object.GetName(),
+		Namespace: evt.Object.GetNamespace(),
+	}, q)
+}
+
+// Update implements EventHandler
+// trigger a reconcile event for those objects that reference this password secret
+func (e *enqueueRequestForReferencingPasswordSecret) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	e.enqueue(types.NamespacedName{
+		Name:      evt.ObjectNew.GetName(),
+		Namespace: evt.ObjectNew.GetNamespace(),
+	}, q)
+}
+
+// Delete implements EventHandler
+func (e *enqueueRequestForReferencingPasswordSecret) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	return
+}
+
+// Generic implements EventHandler
+func (e *enqueueRequestForReferencingPasswordSecret) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	return
+}
+
+// GetNamespacedName parses a reference in format {namespace}/{name} or {name}. In the latter case defaultNamespace is used.
+func GetNamespacedName(reference string, defaultNamespace string) types.NamespacedName {
+	if index := strings.Index(reference, "/"); index != -1 {
+		return types.NamespacedName{
+			Namespace: reference[:index],
+			Name:      reference[index+1:],
+		}
+	}
+	return types.NamespacedName{
+		Namespace: defaultNamespace,
+		Name:      reference,
+	}
+}
+
+// SharedWithNamespacesAnnotation lists, comma separated, the namespaces whose objects are allowed to reference a secret from another namespace
+const SharedWithNamespacesAnnotation = AnnotationBase + "/shared-with-namespaces"
+
+// SharedWithNamespaceSelectorAnnotation contains a label selector, the namespaces matching it are allowed to reference a secret from another namespace
+const SharedWithNamespaceSelectorAnnotation = AnnotationBase + "/shared-with-namespace-selector"
+
+// IsSharedWithNamespace returns whether objects in namespace are allowed to reference secret, which can also be a configmap.
+// Secrets can always be referenced from their own namespace, other namespaces must be listed in the shared-with-namespaces annotation
+// or match the shared-with-namespace-selector annotation of the secret.
+func IsSharedWithNamespace(c client.Client, secret client.Object, namespace string) (bool, error) {
+	if secret.GetNamespace() == namespace {
+		return true, nil
+	}
+	if namespaces, ok := secret.GetAnnotations()[SharedWithNamespacesAnnotation]; ok {
+		for _, sharedNamespace := range strings.Split(namespaces, ",") {
+			if strings.TrimSpace(sharedNamespace) == namespace {
+				return true, nil
+			}
+		}
+	}
+	if namespaceSelector, ok := secret.GetAnnotations()[SharedWithNamespaceSelectorAnnotation]; ok {
+		selector, err := labels.Parse(namespaceSelector)
+		if err != nil {
+			log.Error(err, "unable to parse namespace selector", "secret", secret.GetNamespace()+"/"+secret.GetName())
+			return false, err
+		}
+		ns := &corev1.Namespace{}
+		err = c.Get(context.TODO(), types.NamespacedName{
+			Name: namespace,
+		}, ns)
+		if err != nil {
+			log.Error(err, "unable to find namespace", "namespace", namespace)
+			return false, err
+		}
+		if selector.Matches(labels.Set(ns.GetLabels())) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SecretNotSharedReason is the reason of the warning events reporting a reference to a secret of another namespace that is not shared with the referencing object
+const SecretNotSharedReason = "SecretNotShared"
+
+// SecretNotSharedError is returned when an object references a secret of another namespace that is not shared with its namespace.
+// Retrying does not help until the secret is shared with the namespace of the object.
+type SecretNotSharedError struct {
+	// Secret is the referenced secret
+	Secret types.NamespacedName
+	// Namespace is the namespace of the referencing object
+	Namespace string
+}
+
+func (e *SecretNotSharedError) Error() string {
+	return "secret " + e.Secret.String() + " is not shared with namespace " + e.Namespace
+}
+
+// IsSecretNotShared returns whether err is, or wraps, a SecretNotSharedError
+func IsSecretNotShared(err error) bool {
+	var notShared *SecretNotSharedError
+	return errors.As(err, &notShared)
+}
+
+// RecordSecretNotShared emits a SecretNotShared warning event on obj if err is a reference to a secret that is not shared with obj, and returns
+// whether it was. These errors should not be requeued, they are retried when the sharing annotations of the secret change.
+func RecordSecretNotShared(recorder record.EventRecorder, obj client.Object, err error) bool {
+	if !IsSecretNotShared(err) {
+		return false
+	}
+	recorder.Event(obj, corev1.EventTypeWarning, SecretNotSharedReason, err.Error())
+	return true
+}
+
+// IsSecretSharingChanged returns whether the namespaces a secret is shared with may have changed
+func IsSecretSharingChanged(oldSecret client.Object, newSecret client.Object) bool {
+	return oldSecret.GetAnnotations()[SharedWithNamespacesAnnotation] != newSecret.GetAnnotations()[SharedWithNamespacesAnnotation] ||
+		oldSecret.GetAnnotations()[SharedWithNamespaceSelectorAnnotation] != newSecret.GetAnnotations()[SharedWithNamespaceSelectorAnnotation]
+}
+
+// FieldManager is the field manager of the operator, it identifies the fields owned by the operator in the managed fields of the objects it changes
+const FieldManager = "cert-utils-operator"
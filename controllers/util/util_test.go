@@ -0,0 +1,350 @@
+package util
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestParseConfigMapReference(t *testing.T) {
+	name, key, err := ParseConfigMapReference("openshift-config/trusted-ca")
+	assert.NoError(t, err)
+	assert.Equal(t, types.NamespacedName{Namespace: "openshift-config", Name: "trusted-ca"}, name)
+	assert.Equal(t, "ca-bundle.crt", key)
+
+	name, key, err = ParseConfigMapReference("openshift-config/trusted-ca/ca.crt")
+	assert.NoError(t, err)
+	assert.Equal(t, types.NamespacedName{Namespace: "openshift-config", Name: "trusted-ca"}, name)
+	assert.Equal(t, "ca.crt", key)
+
+	for _, reference := range []string{"trusted-ca", "/trusted-ca", "openshift-config/", "openshift-config/trusted-ca/", "a/b/c/d"} {
+		_, _, err = ParseConfigMapReference(reference)
+		assert.Error(t, err, reference)
+	}
+}
+
+func newTestCA(t *testing.T, commonName string, notBefore time.Time, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestGetCABundle(t *testing.T) {
+	now := time.Now()
+	oldCA := newTestCA(t, "old-ca", now.Add(-time.Hour), now.Add(time.Hour))
+	newCA := newTestCA(t, "new-ca", now.Add(-time.Hour), now.Add(time.Hour))
+	trustedCA := newTestCA(t, "trusted-ca", now.Add(-time.Hour), now.Add(time.Hour))
+	expiredCA := newTestCA(t, "expired-ca", now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	oldSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "old-ca",
+			Namespace: "test",
+		},
+		Type: TLSSecret,
+		Data: map[string][]byte{
+			CA: []byte(oldCA + expiredCA),
+		},
+	}
+	newSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "new-ca",
+			Namespace: "test",
+		},
+		Type: TLSSecret,
+		Data: map[string][]byte{
+			CA: []byte(newCA + oldCA),
+		},
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "trusted-ca",
+			Namespace: "openshift-config",
+		},
+		Data: map[string]string{
+			CABundle: trustedCA,
+		},
+	}
+	target := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "target",
+			Namespace: "test",
+			Annotations: map[string]string{
+				CertAnnotationConfigMap: "openshift-config/trusted-ca",
+			},
+		},
+	}
+
+	cl := fake.NewFakeClient([]runtime.Object{oldSecret, newSecret, configMap}...)
+
+	caBundle, err := GetCABundle(cl, target)
+	assert.NoError(t, err)
+	assert.Equal(t, trustedCA, string(caBundle))
+
+	// duplicates are removed and the certificates are sorted by subject
+	target.Annotations[CertAnnotationSecret] = "test/old-ca, test/new-ca"
+	caBundle, err = GetCABundle(cl, target)
+	assert.NoError(t, err)
+	assert.Equal(t, expiredCA+newCA+oldCA+trustedCA, string(caBundle))
+
+	// the order of the references does not matter
+	target.Annotations[CertAnnotationSecret] = "test/new-ca,test/old-ca"
+	reversedCABundle, err := GetCABundle(cl, target)
+	assert.NoError(t, err)
+	assert.Equal(t, caBundle, reversedCABundle)
+
+	target.Annotations[CertAnnotationPruneExpired] = "true"
+	caBundle, err = GetCABundle(cl, target)
+	assert.NoError(t, err)
+	assert.Equal(t, newCA+oldCA+trustedCA, string(caBundle))
+
+	target.Annotations[CertAnnotationConfigMap] = "openshift-config/trusted-ca,openshift-config/missing"
+	_, err = GetCABundle(cl, target)
+	assert.Error(t, err)
+}
+
+func TestGetReferencedObjects(t *testing.T) {
+	obj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				CertAnnotationSecret:    "test/old-ca, test/new-ca",
+				CertAnnotationConfigMap: "openshift-config/trusted-ca/ca.crt,invalid",
+				CertAnnotationServiceCA: "false",
+			},
+		},
+	}
+	assert.Equal(t, []string{"test/old-ca", "test/new-ca"}, GetReferencedObjects(obj, CertAnnotationSecret))
+	assert.Equal(t, []string{"openshift-config/trusted-ca"}, GetReferencedObjects(obj, CertAnnotationConfigMap))
+	assert.Equal(t, []string{}, GetReferencedObjects(obj, CertAnnotationServiceCA))
+	obj.Annotations[CertAnnotationServiceCA] = "true"
+	assert.Equal(t, []string{"true"}, GetReferencedObjects(obj, CertAnnotationServiceCA))
+	assert.Equal(t, []string{}, GetReferencedObjects(obj, CertAnnotationPruneExpired))
+}
+
+// countingReader counts the calls made to the wrapped reader, which in the operator is the informer cache
+type countingReader struct {
+	client.Reader
+	gets        int
+	listOptions []*client.ListOptions
+}
+
+func (r *countingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	r.gets++
+	return r.Reader.Get(ctx, key, obj)
+}
+
+func (r *countingReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOptions := &client.ListOptions{}
+	listOptions.ApplyOptions(opts)
+	r.listOptions = append(r.listOptions, listOptions)
+	return r.Reader.List(ctx, list, opts...)
+}
+
+func newReferencingWebhooks(count int) []runtime.Object {
+	objs := []runtime.Object{}
+	for i := 0; i < count; i++ {
+		objs = append(objs, &admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "webhook-" + strconv.Itoa(i),
+				Annotations: map[string]string{
+					CertAnnotationSecret: "test/ca-" + strconv.Itoa(i),
+				},
+			},
+		})
+	}
+	return objs
+}
+
+func TestEnqueueRequestForReferecingObjectUsesIndex(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ca-42",
+			Namespace: "test",
+		},
+		Type: TLSSecret,
+	}
+	reader := &countingReader{Reader: fake.NewFakeClient(newReferencingWebhooks(100)...)}
+
+	for _, handler := range []*enqueueRequestForReferecingObject{
+		NewEnqueueRequestForReferecingObject(reader, &admissionregistrationv1.ValidatingWebhookConfigurationList{}, CertAnnotationSecret),
+		NewEnqueueRequestForReferecingUnindexedObject(reader, &admissionregistrationv1.ValidatingWebhookConfigurationList{}, CertAnnotationSecret),
+	} {
+		reader.gets = 0
+		reader.listOptions = nil
+		q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		handler.Update(event.UpdateEvent{ObjectOld: secret, ObjectNew: secret}, q)
+
+		assert.Equal(t, 1, q.Len())
+		item, _ := q.Get()
+		assert.Equal(t, reconcile.Request{NamespacedName: types.NamespacedName{Name: "webhook-42"}}, item)
+
+		// a secret event results in a single list call to the cache and no call to the api server
+		assert.Equal(t, 0, reader.gets)
+		assert.Len(t, reader.listOptions, 1)
+		if handler.indexed {
+			assert.Equal(t, "metadata.annotations["+CertAnnotationSecret+"]=test/ca-42", reader.listOptions[0].FieldSelector.String())
+		} else {
+			assert.Nil(t, reader.listOptions[0].FieldSelector)
+		}
+	}
+}
+
+func TestPatchOwnedFieldsIfChangedPreservesConcurrentChanges(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret",
+			Namespace: "test",
+		},
+		Data: map[string][]byte{
+			Cert: []byte("cert"),
+		},
+	}
+	cl := fake.NewFakeClient(secret)
+
+	instance := &corev1.Secret{}
+	err := cl.Get(context.TODO(), types.NamespacedName{Name: "secret", Namespace: "test"}, instance)
+	assert.NoError(t, err)
+	original := instance.DeepCopy()
+
+	// another controller changes the secret after it was read
+	concurrent := instance.DeepCopy()
+	concurrent.Data[Cert] = []byte("renewed cert")
+	err = cl.Update(context.TODO(), concurrent)
+	assert.NoError(t, err)
+
+	instance.Data["keystore.jks"] = []byte("keystore")
+	patched, err := PatchOwnedFieldsIfChanged(context.TODO(), cl, "test", instance, original)
+	assert.NoError(t, err)
+	assert.True(t, patched)
+
+	result := &corev1.Secret{}
+	err = cl.Get(context.TODO(), types.NamespacedName{Name: "secret", Namespace: "test"}, result)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("renewed cert"), result.Data[Cert])
+	assert.Equal(t, []byte("keystore"), result.Data["keystore.jks"])
+}
+
+func TestPatchOwnedFieldsIfChangedPreservesConcurrentListChanges(t *testing.T) {
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ingress",
+			Namespace: "test",
+		},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{
+				{Hosts: []string{"a.example.com"}},
+				{Hosts: []string{"b.example.com"}, SecretName: "b"},
+			},
+		},
+	}
+	cl := fake.NewFakeClient(ingress)
+	name := types.NamespacedName{Name: "ingress", Namespace: "test"}
+
+	instance := &networkingv1.Ingress{}
+	err := cl.Get(context.TODO(), name, instance)
+	assert.NoError(t, err)
+	original := instance.DeepCopy()
+
+	// another writer changes a sibling entry of the list after it was read
+	concurrent := instance.DeepCopy()
+	concurrent.Spec.TLS[1].SecretName = "b-renewed"
+	err = cl.Update(context.TODO(), concurrent)
+	assert.NoError(t, err)
+
+	instance.Spec.TLS[0].SecretName = "a"
+	patched, err := PatchOwnedFieldsIfChanged(context.TODO(), cl, "test", instance, original)
+	assert.NoError(t, err)
+	assert.True(t, patched)
+
+	result := &networkingv1.Ingress{}
+	err = cl.Get(context.TODO(), name, result)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", result.Spec.TLS[0].SecretName)
+	assert.Equal(t, "b-renewed", result.Spec.TLS[1].SecretName)
+
+	// a concurrent change of the same entry makes the patch fail instead of being reverted
+	original = result.DeepCopy()
+	instance = result.DeepCopy()
+	concurrent = result.DeepCopy()
+	concurrent.Spec.TLS[0].Hosts = []string{"c.example.com"}
+	err = cl.Update(context.TODO(), concurrent)
+	assert.NoError(t, err)
+	instance.Spec.TLS[0].SecretName = "a-renewed"
+	_, err = PatchOwnedFieldsIfChanged(context.TODO(), cl, "test", instance, original)
+	assert.Error(t, err)
+
+	err = cl.Get(context.TODO(), name, result)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c.example.com"}, result.Spec.TLS[0].Hosts)
+	assert.Equal(t, "a", result.Spec.TLS[0].SecretName)
+}
+
+func TestPatchOwnedFieldsIfChangedSkipsNoOpWrites(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret",
+			Namespace: "test",
+		},
+		Data: map[string][]byte{
+			Cert: []byte("cert"),
+		},
+	}
+	cl := fake.NewFakeClient(secret)
+	performed := testutil.ToFloat64(objectWrites.WithLabelValues("noop", WritePerformed))
+	skipped := testutil.ToFloat64(objectWrites.WithLabelValues("noop", WriteSkipped))
+
+	instance := &corev1.Secret{}
+	err := cl.Get(context.TODO(), types.NamespacedName{Name: "secret", Namespace: "test"}, instance)
+	assert.NoError(t, err)
+	resourceVersion := instance.GetResourceVersion()
+	original := instance.DeepCopy()
+	// the desired state is recomputed, but it is semantically equal to the current one
+	instance.Data[Cert] = []byte("cert")
+	instance.Data[Key] = nil
+	delete(instance.Data, Key)
+
+	patched, err := PatchOwnedFieldsIfChanged(context.TODO(), cl, "noop", instance, original)
+	assert.NoError(t, err)
+	assert.False(t, patched)
+	err = cl.Get(context.TODO(), types.NamespacedName{Name: "secret", Namespace: "test"}, instance)
+	assert.NoError(t, err)
+	assert.Equal(t, resourceVersion, instance.GetResourceVersion())
+	assert.Equal(t, performed, testutil.ToFloat64(objectWrites.WithLabelValues("noop", WritePerformed)))
+	assert.Equal(t, skipped+1, testutil.ToFloat64(objectWrites.WithLabelValues("noop", WriteSkipped)))
+}
@@ -19,13 +19,19 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 
+	redhatcopv1alpha1 "github.com/redhat-cop/cert-utils-operator/api/v1alpha1"
 	"github.com/redhat-cop/cert-utils-operator/controllers/cainjection"
 	"github.com/redhat-cop/cert-utils-operator/controllers/certexpiryalert"
 	"github.com/redhat-cop/cert-utils-operator/controllers/certificateinfo"
+	"github.com/redhat-cop/cert-utils-operator/controllers/certificatepolicy"
 	"github.com/redhat-cop/cert-utils-operator/controllers/configmaptokeystore"
+	"github.com/redhat-cop/cert-utils-operator/controllers/gateway"
+	"github.com/redhat-cop/cert-utils-operator/controllers/ingress"
 	"github.com/redhat-cop/cert-utils-operator/controllers/route"
 	"github.com/redhat-cop/cert-utils-operator/controllers/secrettokeystore"
+	"github.com/redhat-cop/cert-utils-operator/controllers/util"
 	outils "github.com/redhat-cop/operator-utils/pkg/util"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
@@ -42,6 +48,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -55,6 +62,7 @@ func init() {
 	utilruntime.Must(routev1.AddToScheme(scheme))
 	utilruntime.Must(crd.AddToScheme(scheme))
 	utilruntime.Must(apiregistrationv1.AddToScheme(scheme))
+	utilruntime.Must(redhatcopv1alpha1.AddToScheme(scheme))
 
 	// +kubebuilder:scaffold:scheme
 }
@@ -63,8 +71,15 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var enableCertificatePolicyWebhook bool
+	var chainMetricLabels string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&util.CrossNamespaceCAInjectionPolicy, "cross-namespace-ca-injection-policy", util.CrossNamespaceCAInjectionPolicy, "The policy applied when a namespaced object injects a ca bundle from another namespace, one of "+strings.Join(util.CrossNamespaceCAInjectionPolicies, ", ")+".")
+	flag.StringVar(&util.CrossNamespaceCAInjectionServiceAccount, "cross-namespace-ca-injection-service-account", util.CrossNamespaceCAInjectionServiceAccount, "The service account, in the namespace of the target object, that must be able to read the injected ca source with the "+util.CrossNamespaceCAInjectionSubjectAccessReview+" cross-namespace ca injection policy.")
+	flag.StringVar(&util.ServiceCAFile, "service-ca-file", util.ServiceCAFile, "The service ca file injected in the objects annotated with "+util.CertAnnotationServiceCA+".")
+	flag.StringVar(&chainMetricLabels, "certificate-chain-metric-labels", strings.Join(certexpiryalert.ChainMetricLabels, ","), "The comma separated labels of the certificate chain metrics, in addition to name and namespace, among "+strings.Join(certexpiryalert.SupportedChainMetricLabels, ", ")+".")
+	flag.BoolVar(&enableCertificatePolicyWebhook, "enable-certificate-policy-webhook", false, "Serve the validating webhook that rejects the tls secrets violating certificate policies with the deny enforcement action.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -76,6 +91,22 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if err := util.ValidateCrossNamespaceCAInjectionPolicy(util.CrossNamespaceCAInjectionPolicy); err != nil {
+		setupLog.Error(err, "invalid value of --cross-namespace-ca-injection-policy")
+		os.Exit(1)
+	}
+
+	certexpiryalert.ChainMetricLabels = []string{}
+	for _, label := range strings.Split(chainMetricLabels, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			certexpiryalert.ChainMetricLabels = append(certexpiryalert.ChainMetricLabels, label)
+		}
+	}
+	if err := certexpiryalert.ValidateChainMetricLabels(certexpiryalert.ChainMetricLabels); err != nil {
+		setupLog.Error(err, "invalid value of --certificate-chain-metric-labels")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                     scheme,
 		MetricsBindAddress:         metricsAddr,
@@ -90,54 +121,75 @@ func main() {
 		os.Exit(1)
 	}
 
+	serviceCAWatcher := util.NewServiceCAWatcher()
+	if err := mgr.Add(serviceCAWatcher); err != nil {
+		setupLog.Error(err, "unable to set up service ca watcher")
+		os.Exit(1)
+	}
+
 	if err = (&cainjection.APIServiceReconciler{
-		ReconcilerBase: outils.NewFromManager(mgr, mgr.GetEventRecorderFor("apiservice_ca_injection_controller")),
-		Log:            ctrl.Log.WithName("controllers").WithName("apiservice_ca_injection_controller"),
+		ReconcilerBase:   outils.NewFromManager(mgr, mgr.GetEventRecorderFor("apiservice_ca_injection_controller")),
+		Log:              ctrl.Log.WithName("controllers").WithName("apiservice_ca_injection_controller"),
+		ServiceCAWatcher: serviceCAWatcher,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "apiservice_ca_injection_controller")
 		os.Exit(1)
 	}
 
 	if err = (&cainjection.ConfigmapReconciler{
-		ReconcilerBase: outils.NewFromManager(mgr, mgr.GetEventRecorderFor("configmap_ca_injection_controller")),
-		Log:            ctrl.Log.WithName("controllers").WithName("configmap_ca_injection_controller"),
+		ReconcilerBase:   outils.NewFromManager(mgr, mgr.GetEventRecorderFor("configmap_ca_injection_controller")),
+		Log:              ctrl.Log.WithName("controllers").WithName("configmap_ca_injection_controller"),
+		ServiceCAWatcher: serviceCAWatcher,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "configmap_ca_injection_controller")
 		os.Exit(1)
 	}
 
 	if err = (&cainjection.CRDReconciler{
-		ReconcilerBase: outils.NewFromManager(mgr, mgr.GetEventRecorderFor("crd_ca_injection_controller")),
-		Log:            ctrl.Log.WithName("controllers").WithName("crd_ca_injection_controller"),
+		ReconcilerBase:   outils.NewFromManager(mgr, mgr.GetEventRecorderFor("crd_ca_injection_controller")),
+		Log:              ctrl.Log.WithName("controllers").WithName("crd_ca_injection_controller"),
+		ServiceCAWatcher: serviceCAWatcher,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "crd_ca_injection_controller")
 		os.Exit(1)
 	}
 
 	if err = (&cainjection.MutatingWebhookConfigurationReconciler{
-		ReconcilerBase: outils.NewFromManager(mgr, mgr.GetEventRecorderFor("mutating_webhook_ca_injection_controller")),
-		Log:            ctrl.Log.WithName("controllers").WithName("mutating_webhook_ca_injection_controller"),
+		ReconcilerBase:   outils.NewFromManager(mgr, mgr.GetEventRecorderFor("mutating_webhook_ca_injection_controller")),
+		Log:              ctrl.Log.WithName("controllers").WithName("mutating_webhook_ca_injection_controller"),
+		ServiceCAWatcher: serviceCAWatcher,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "mutating_webhook_ca_injection_controller")
 		os.Exit(1)
 	}
 
 	if err = (&cainjection.SecretReconciler{
-		ReconcilerBase: outils.NewFromManager(mgr, mgr.GetEventRecorderFor("secret_ca_injection_controller")),
-		Log:            ctrl.Log.WithName("controllers").WithName("secret_ca_injection_controller"),
+		ReconcilerBase:   outils.NewFromManager(mgr, mgr.GetEventRecorderFor("secret_ca_injection_controller")),
+		Log:              ctrl.Log.WithName("controllers").WithName("secret_ca_injection_controller"),
+		ServiceCAWatcher: serviceCAWatcher,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "secret_ca_injection_controller")
 		os.Exit(1)
 	}
 
 	if err = (&cainjection.ValidatingWebhookConfigurationReconciler{
-		ReconcilerBase: outils.NewFromManager(mgr, mgr.GetEventRecorderFor("validating_webhook_ca_injection_controller")),
-		Log:            ctrl.Log.WithName("controllers").WithName("validating_webhook_ca_injection_controller"),
+		ReconcilerBase:   outils.NewFromManager(mgr, mgr.GetEventRecorderFor("validating_webhook_ca_injection_controller")),
+		Log:              ctrl.Log.WithName("controllers").WithName("validating_webhook_ca_injection_controller"),
+		ServiceCAWatcher: serviceCAWatcher,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "validating_webhook_ca_injection_controller")
 		os.Exit(1)
 	}
 
+	if err = (&cainjection.CAInjectionTargetReconciler{
+		ReconcilerBase:   outils.NewFromManager(mgr, mgr.GetEventRecorderFor("cainjectiontarget_controller")),
+		Log:              ctrl.Log.WithName("controllers").WithName("cainjectiontarget_controller"),
+		ServiceCAWatcher: serviceCAWatcher,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "cainjectiontarget_controller")
+		os.Exit(1)
+	}
+
 	if err = (&certexpiryalert.CertExpiryAlertReconciler{
 		ReconcilerBase: outils.NewFromManager(mgr, mgr.GetEventRecorderFor("certexpiryalert_controller")),
 		Log:            ctrl.Log.WithName("controllers").WithName("certexpiryalert_controller"),
@@ -162,6 +214,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err = (&certificatepolicy.CertificatePolicyReconciler{
+		ReconcilerBase: outils.NewFromManager(mgr, mgr.GetEventRecorderFor("certificatepolicy_controller")),
+		Log:            ctrl.Log.WithName("controllers").WithName("certificatepolicy_controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "certificatepolicy_controller")
+		os.Exit(1)
+	}
+
+	if enableCertificatePolicyWebhook {
+		mgr.GetWebhookServer().Register(certificatepolicy.SecretValidatorPath, &webhook.Admission{Handler: &certificatepolicy.SecretValidator{
+			Client: mgr.GetClient(),
+		}})
+	}
+
 	if err = (&secrettokeystore.SecretToKeyStoreReconciler{
 		ReconcilerBase: outils.NewFromManager(mgr, mgr.GetEventRecorderFor("secret_to_keystore_contoller")),
 		Log:            ctrl.Log.WithName("controllers").WithName("secret_to_keystore_contoller"),
@@ -184,6 +250,40 @@ func main() {
 		}
 	}
 
+	if res, err := outils.IsGVKDefined(schema.GroupVersionKind{
+		Group:   "networking.k8s.io",
+		Version: "v1",
+		Kind:    "Ingress",
+	}, discovery.NewDiscoveryClientForConfigOrDie(mgr.GetConfig())); err == nil && res != nil {
+		if err = (&ingress.IngressCertificateReconciler{
+			ReconcilerBase: outils.NewFromManager(mgr, mgr.GetEventRecorderFor("ingress_certificate_controller")),
+			Log:            ctrl.Log.WithName("controllers").WithName("ingress_certificate_controller"),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ingress_certificate_controller")
+			os.Exit(1)
+		}
+	}
+
+	if res, err := outils.IsGVKDefined(gateway.GatewayGVK, discovery.NewDiscoveryClientForConfigOrDie(mgr.GetConfig())); err == nil && res != nil {
+		if err = (&gateway.GatewayCertificateReconciler{
+			ReconcilerBase: outils.NewFromManager(mgr, mgr.GetEventRecorderFor("gateway_certificate_controller")),
+			Log:            ctrl.Log.WithName("controllers").WithName("gateway_certificate_controller"),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "gateway_certificate_controller")
+			os.Exit(1)
+		}
+	}
+
+	if res, err := outils.IsGVKDefined(gateway.BackendTLSPolicyGVK, discovery.NewDiscoveryClientForConfigOrDie(mgr.GetConfig())); err == nil && res != nil {
+		if err = (&gateway.BackendTLSPolicyReconciler{
+			ReconcilerBase: outils.NewFromManager(mgr, mgr.GetEventRecorderFor("backendtlspolicy_ca_controller")),
+			Log:            ctrl.Log.WithName("controllers").WithName("backendtlspolicy_ca_controller"),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "backendtlspolicy_ca_controller")
+			os.Exit(1)
+		}
+	}
+
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("health", healthz.Ping); err != nil {